@@ -0,0 +1,172 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+	"github.com/stolostron/multicloud-operators-deployable/pkg/utils"
+)
+
+// diffOptions are the flags of the diff command.
+type diffOptions struct {
+	*options
+
+	cluster string
+	verify  bool
+}
+
+func newDiffCommand(o *options) *cobra.Command {
+	do := &diffOptions{options: o}
+
+	cmd := &cobra.Command{
+		Use:   "diff SOURCE TARGET",
+		Short: "Print the overrides turning the template of the SOURCE deployable manifest into the template of TARGET",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return do.run(args[0], args[1])
+		},
+	}
+
+	cmd.Flags().StringVar(&do.cluster, "cluster", "", "Print the overrides for this cluster, ready for spec.overrides.")
+	cmd.Flags().BoolVar(&do.verify, "verify", false, "Check that applying the overrides to SOURCE reproduces the template of TARGET.")
+
+	return cmd
+}
+
+func (do *diffOptions) run(srcPath, dstPath string) error {
+	src, err := readDeployable(srcPath)
+	if err != nil {
+		return err
+	}
+
+	dst, err := readDeployable(dstPath)
+	if err != nil {
+		return err
+	}
+
+	covs, fallback := utils.DiffOverrides(src, dst)
+
+	if fallback != nil {
+		fmt.Fprintf(do.out, "# fallback: %v, the \".\" override replaces the whole template\n", fallback)
+	}
+
+	var out interface{} = covs
+	if do.cluster != "" {
+		out = appv1alpha1.Overrides{ClusterName: do.cluster, ClusterOverrides: covs}
+	}
+
+	data, err := yaml.Marshal(out)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(do.out, "%s", data)
+
+	if !do.verify {
+		return nil
+	}
+
+	mismatches, err := verifyOverrides(src, dst, covs)
+	if err != nil {
+		return err
+	}
+
+	if len(mismatches) > 0 {
+		return fmt.Errorf("applying the overrides does not reproduce the target template, it differs at %s", strings.Join(mismatches, ", "))
+	}
+
+	fmt.Fprintln(do.out, "# verified: applying the overrides reproduces the target template")
+
+	return nil
+}
+
+func readDeployable(path string) (*appv1alpha1.Deployable, error) {
+	objs, err := readObjects(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(objs) != 1 {
+		return nil, fmt.Errorf("%s must hold exactly one deployable", path)
+	}
+
+	dpl := &appv1alpha1.Deployable{}
+	if err := convertObject(objs[0], "Deployable", dpl); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	if dpl.Spec.Template == nil {
+		return nil, fmt.Errorf("%s: deployable %s has no template", path, dpl.GetName())
+	}
+
+	return dpl, nil
+}
+
+// verifyOverrides applies the overrides to the template of src like the controller does, and returns the override paths
+// still needed to reach the template of dst.
+func verifyOverrides(src, dst *appv1alpha1.Deployable, covs []appv1alpha1.ClusterOverride) ([]string, error) {
+	tplobj := &unstructured.Unstructured{}
+	if err := json.Unmarshal(src.Spec.Template.Raw, tplobj); err != nil {
+		return nil, err
+	}
+
+	tplobj, err := utils.OverrideTemplate(tplobj, covs)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := json.Marshal(tplobj)
+	if err != nil {
+		return nil, err
+	}
+
+	if utils.TemplateHash(&runtime.RawExtension{Raw: raw}) == utils.TemplateHash(dst.Spec.Template) {
+		return nil, nil
+	}
+
+	overridden := src.DeepCopy()
+	overridden.Spec.Template = &runtime.RawExtension{Raw: raw}
+
+	remaining, _ := utils.DiffOverrides(overridden, dst)
+
+	var paths []string
+
+	for _, cov := range remaining {
+		ov := struct {
+			Path string `json:"path"`
+		}{}
+
+		if err := json.Unmarshal(cov.Raw, &ov); err != nil {
+			return nil, err
+		}
+
+		paths = append(paths, ov.Path)
+	}
+
+	if len(paths) == 0 {
+		paths = append(paths, ".")
+	}
+
+	return paths, nil
+}
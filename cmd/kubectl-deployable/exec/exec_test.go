@@ -0,0 +1,225 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	spokeClusterV1 "github.com/open-cluster-management/api/cluster/v1"
+	workv1 "github.com/open-cluster-management/api/work/v1"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+	"github.com/stolostron/multicloud-operators-deployable/pkg/controller/deployable"
+	"github.com/stolostron/multicloud-operators-deployable/pkg/utils"
+	placementv1alpha1 "github.com/stolostron/multicloud-operators-placementrule/pkg/apis/apps/v1"
+)
+
+func TestExplainPlacement(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	now := metav1.Now()
+	clusters := []spokeClusterV1.ManagedCluster{
+		{ObjectMeta: metav1.ObjectMeta{Name: "east", Labels: map[string]string{"name": "east", "env": "prod"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "west", Labels: map[string]string{"name": "west", "env": "dev"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "gone", Labels: map[string]string{"name": "gone", "env": "prod"}, DeletionTimestamp: &now}},
+	}
+
+	hub := &appv1alpha1.Deployable{
+		Spec: appv1alpha1.DeployableSpec{
+			Placement: &placementv1alpha1.Placement{
+				GenericPlacementFields: placementv1alpha1.GenericPlacementFields{
+					ClusterSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+				},
+			},
+		},
+	}
+
+	rows := explainPlacement(hub, nil, clusters, time.Now())
+	g.Expect(rows).To(gomega.HaveLen(3))
+	g.Expect(rows[0].selected).To(gomega.BeTrue())
+	g.Expect(rows[0].reasons).To(gomega.ConsistOf("matches env=prod"))
+	g.Expect(rows[1].selected).To(gomega.BeFalse())
+	g.Expect(rows[1].reasons).To(gomega.ConsistOf("does not match env=prod"))
+	g.Expect(rows[2].selected).To(gomega.BeFalse())
+	g.Expect(rows[2].reasons).To(gomega.ConsistOf("cluster is terminating"))
+
+	// cluster names win over the cluster selector
+	hub.Spec.Placement.Clusters = []placementv1alpha1.GenericClusterReference{{Name: "west"}}
+
+	rows = explainPlacement(hub, nil, clusters, time.Now())
+	g.Expect(rows[0].selected).To(gomega.BeFalse())
+	g.Expect(rows[1].selected).To(gomega.BeTrue())
+
+	// the placementRef wins over the cluster names
+	hub.Spec.Placement.PlacementRef = &corev1.ObjectReference{Name: "rule"}
+	rule := &placementv1alpha1.PlacementRule{}
+	rule.Status.Decisions = []placementv1alpha1.PlacementDecision{{ClusterName: "gone", ClusterNamespace: "gone"}}
+
+	rows = explainPlacement(hub, rule, clusters, time.Now())
+	g.Expect(rows[1].selected).To(gomega.BeFalse())
+	g.Expect(rows[2].selected).To(gomega.BeTrue())
+	g.Expect(rows[2].reasons).To(gomega.ConsistOf("in the decisions of placementRule rule"))
+}
+
+func TestClusterRows(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	hub := &appv1alpha1.Deployable{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "hub"}}
+	hub.Status.PropagatedStatus = map[string]*appv1alpha1.ResourceUnitStatus{
+		"east": {Phase: appv1alpha1.DeployableFailed, Reason: "Boom"},
+	}
+
+	child := func(cluster, generateName string, phase appv1alpha1.DeployablePhase) appv1alpha1.Deployable {
+		dpl := appv1alpha1.Deployable{ObjectMeta: metav1.ObjectMeta{
+			Name:         generateName + cluster,
+			Namespace:    cluster,
+			GenerateName: generateName,
+			Annotations: map[string]string{
+				appv1alpha1.AnnotationHosting:        "hub/app",
+				appv1alpha1.AnnotationManagedCluster: cluster + "/" + cluster,
+			},
+		}}
+		dpl.Status.Phase = phase
+
+		return dpl
+	}
+
+	children := childrenOf(hub, []appv1alpha1.Deployable{
+		child("west", "app-", appv1alpha1.DeployableDeployed),
+		child("east", "app-", appv1alpha1.DeployableDeployed),
+		child("west", "config-", appv1alpha1.DeployableFailed),
+	})
+	g.Expect(children).To(gomega.HaveLen(3))
+	g.Expect(isDependencyChild(hub, &children[2])).To(gomega.BeTrue())
+
+	// the hub status wins, the clusters dropped from a summarized status come from the children
+	rows := clusterRows(hub, children)
+	g.Expect(rows).To(gomega.HaveLen(2))
+	g.Expect(rows[0].cluster).To(gomega.Equal("east"))
+	g.Expect(rows[0].status.Phase).To(gomega.Equal(appv1alpha1.DeployableFailed))
+	g.Expect(rows[1].cluster).To(gomega.Equal("west"))
+	g.Expect(rows[1].status.Phase).To(gomega.Equal(appv1alpha1.DeployableDeployed))
+}
+
+func TestVerifyOverrides(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	template := func(raw string) *appv1alpha1.Deployable {
+		return &appv1alpha1.Deployable{Spec: appv1alpha1.DeployableSpec{Template: &runtime.RawExtension{Raw: []byte(raw)}}}
+	}
+
+	src := template(`{"kind":"ConfigMap","data":{"a":"1","b":"2"}}`)
+
+	dst := template(`{"kind":"ConfigMap","data":{"a":"3","b":"2"}}`)
+	covs, _ := utils.DiffOverrides(src, dst)
+	g.Expect(verifyOverrides(src, dst, covs)).To(gomega.BeEmpty())
+
+	dst = template(`{"kind":"ConfigMap","data":"a"}`)
+	covs, fallback := utils.DiffOverrides(src, dst)
+	g.Expect(fallback).To(gomega.MatchError(gomega.ContainSubstring("path data changes from map[string]interface {} to string")))
+	g.Expect(verifyOverrides(src, dst, covs)).To(gomega.BeEmpty())
+
+	// removed fields are overridden with null rather than removed
+	dst = template(`{"kind":"ConfigMap","data":{"a":"1"}}`)
+	covs, _ = utils.DiffOverrides(src, dst)
+	g.Expect(verifyOverrides(src, dst, covs)).To(gomega.Equal([]string{"data.b"}))
+}
+
+func TestMigrate(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme, err := newScheme()
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	hub := &appv1alpha1.Deployable{
+		ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "default"},
+		Spec: appv1alpha1.DeployableSpec{
+			Template: &runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cm"},"data":{"a":"1"}}`)},
+			Placement: &placementv1alpha1.Placement{GenericPlacementFields: placementv1alpha1.GenericPlacementFields{
+				Clusters: []placementv1alpha1.GenericClusterReference{{Name: "east"}, {Name: "west"}},
+			}},
+		},
+	}
+	clusters := []client.Object{
+		&spokeClusterV1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "east", Labels: map[string]string{"name": "east"}}},
+		&spokeClusterV1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "west", Labels: map[string]string{"name": "west"}}},
+	}
+
+	// east has an up to date child, west has none yet and north is no longer targeted
+	rendered, err := deployable.RenderChildren(context.TODO(), fake.NewClientBuilder().WithScheme(scheme).WithObjects(clusters...).Build(), hub)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	east := rendered[0].DeepCopy()
+	east.SetName("cm-east")
+
+	north := east.DeepCopy()
+	north.SetName("cm-north")
+	north.SetNamespace("north")
+	north.GetAnnotations()[appv1alpha1.AnnotationManagedCluster] = "north/north"
+
+	objs := append(clusters, hub, east, north)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+	mo := &migrateOptions{options: &options{}, wait: false}
+
+	entries, err := mo.migrate(context.TODO(), c, hub)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(entries).To(gomega.Equal([]migrationEntry{
+		{Deployable: "default/cm", Cluster: "east", Phase: migrationSwitched},
+		{Deployable: "default/cm", Cluster: "north", Phase: migrationExpired, Message: "cluster no longer targeted, the child is removed"},
+		{Deployable: "default/cm", Cluster: "west", Phase: migrationSwitched, Message: "no child yet, the ManifestWork is created"},
+	}))
+
+	// the children are left to the controller
+	g.Expect(c.Get(context.TODO(), client.ObjectKeyFromObject(east), east)).To(gomega.Succeed())
+	g.Expect(c.Get(context.TODO(), client.ObjectKeyFromObject(hub), hub)).To(gomega.Succeed())
+	g.Expect(hub.Spec.Backend).To(gomega.Equal(appv1alpha1.PropagationBackendManifestWork))
+
+	// the migration is over once the children are removed and the ManifestWorks are available
+	g.Expect(c.Delete(context.TODO(), east)).To(gomega.Succeed())
+	g.Expect(c.Delete(context.TODO(), north)).To(gomega.Succeed())
+
+	for _, child := range rendered {
+		g.Expect(c.Create(context.TODO(), deployable.NewManifestWork(hub, child))).To(gomega.Succeed())
+	}
+
+	g.Expect(isMigrated(context.TODO(), c, hub, rendered)).To(gomega.BeFalse())
+
+	works := &workv1.ManifestWorkList{}
+	g.Expect(c.List(context.TODO(), works)).To(gomega.Succeed())
+
+	for i := range works.Items {
+		apimeta.SetStatusCondition(&works.Items[i].Status.Conditions, metav1.Condition{
+			Type: workv1.WorkAvailable, Status: metav1.ConditionTrue, Reason: "ResourcesAvailable"})
+		g.Expect(c.Status().Update(context.TODO(), &works.Items[i])).To(gomega.Succeed())
+	}
+
+	g.Expect(isMigrated(context.TODO(), c, hub, rendered)).To(gomega.BeTrue())
+
+	// a child edited out of band blocks the migration
+	east.Spec.Template = &runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cm"},"data":{"a":"2"}}`)}
+
+	entries, ok := verifyMigration(hub, []appv1alpha1.Deployable{*east}, rendered)
+	g.Expect(ok).To(gomega.BeFalse())
+	g.Expect(entries[0].Phase).To(gomega.Equal(migrationMismatch))
+}
@@ -0,0 +1,364 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ghodss/yaml"
+	workv1 "github.com/open-cluster-management/api/work/v1"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+	"github.com/stolostron/multicloud-operators-deployable/pkg/controller/deployable"
+	"github.com/stolostron/multicloud-operators-deployable/pkg/utils"
+)
+
+// The phases of the clusters of a hub deployable in the migration report.
+const (
+	// migrationVerified is a cluster whose child matches the ManifestWork rendered for it.
+	migrationVerified = "Verified"
+	// migrationMismatch is a cluster whose child differs from the ManifestWork rendered for it, blocking the migration.
+	migrationMismatch = "Mismatch"
+	// migrationExpired is a cluster no longer targeted, its child is removed anyway.
+	migrationExpired = "Expired"
+	// migrationPending is a targeted cluster without child yet, its ManifestWork is created.
+	migrationPending = "Pending"
+	// migrationFailed is a hub deployable whose migration stopped on an error.
+	migrationFailed = "Failed"
+	// migrationSkipped is a hub deployable the ManifestWork backend cannot propagate.
+	migrationSkipped = "Skipped"
+	// migrationSwitched is a cluster whose hub deployable moved to the ManifestWork backend.
+	migrationSwitched = "Switched"
+	// migrationMigrated is a cluster propagated by ManifestWork only.
+	migrationMigrated = "Migrated"
+)
+
+// migrationEntry is a row of the migration report.
+type migrationEntry struct {
+	Deployable string `json:"deployable"`
+	Cluster    string `json:"cluster,omitempty"`
+	Phase      string `json:"phase"`
+	Message    string `json:"message,omitempty"`
+}
+
+// migrationReport is written after each hub deployable, so an interrupted migration tells where it stopped.
+type migrationReport struct {
+	Entries []migrationEntry `json:"entries"`
+}
+
+// migrateOptions are the flags of the migrate command.
+type migrateOptions struct {
+	*options
+
+	all     bool
+	dryRun  bool
+	wait    bool
+	timeout time.Duration
+	report  string
+}
+
+func newMigrateCommand(o *options) *cobra.Command {
+	mo := &migrateOptions{options: o}
+
+	cmd := &cobra.Command{
+		Use:   "migrate [NAME...]",
+		Short: "Move hub deployables from child deployables to ManifestWorks without deleting their workloads",
+		Long: `Move hub deployables from child deployables to ManifestWorks without deleting their workloads.
+
+Each child is rendered into the ManifestWork the controller would create for its cluster, and the two must match.
+Then spec.backend is set to ManifestWork: the controller creates the ManifestWorks, and keeps the child of each
+cluster until the work agent reports its ManifestWork Available. A hub deployable is migrated once every targeted
+cluster has an Available ManifestWork and no child left.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return mo.run(cmd.Context(), args)
+		},
+	}
+
+	cmd.Flags().BoolVar(&mo.all, "all", false, "Migrate all the hub deployables of the namespace.")
+	cmd.Flags().BoolVar(&mo.dryRun, "dry-run", false, "Only verify the ManifestWorks rendered from the children.")
+	cmd.Flags().BoolVar(&mo.wait, "wait", true, "Wait for the controller to replace the children of each hub deployable.")
+	cmd.Flags().DurationVar(&mo.timeout, "timeout", 5*time.Minute, "How long to wait for each hub deployable.")
+	cmd.Flags().StringVar(&mo.report, "report", "", "Write the progress report to this file as YAML.")
+
+	return cmd
+}
+
+func (mo *migrateOptions) run(ctx context.Context, names []string) error {
+	if len(names) == 0 && !mo.all {
+		return fmt.Errorf("name the hub deployables to migrate, or pass --all")
+	}
+
+	c, namespace, err := mo.client()
+	if err != nil {
+		return err
+	}
+
+	hubs, err := listHubDeployables(ctx, c, namespace, names)
+	if err != nil {
+		return err
+	}
+
+	report := &migrationReport{}
+	blocked := 0
+	w := newTable(mo.out, "DEPLOYABLE", "CLUSTER", "PHASE", "MESSAGE")
+
+	for i := range hubs {
+		entries, err := mo.migrate(ctx, c, &hubs[i])
+		if err != nil {
+			entries = append(entries, migrationEntry{Deployable: hubKey(&hubs[i]), Phase: migrationFailed, Message: err.Error()})
+		}
+
+		for _, entry := range entries {
+			if entry.Phase == migrationMismatch || entry.Phase == migrationFailed {
+				blocked++
+				break
+			}
+		}
+
+		report.Entries = append(report.Entries, entries...)
+
+		for _, entry := range entries {
+			printRow(w, entry.Deployable, orNone(entry.Cluster), entry.Phase, entry.Message)
+		}
+
+		w.Flush()
+
+		if err := mo.writeReport(report); err != nil {
+			return err
+		}
+	}
+
+	if blocked > 0 {
+		return fmt.Errorf("%d of %d hub deployables were not migrated", blocked, len(hubs))
+	}
+
+	return nil
+}
+
+// listHubDeployables returns the named hub deployables, all the hub deployables of the namespace if none is named.
+func listHubDeployables(ctx context.Context, c client.Client, namespace string, names []string) ([]appv1alpha1.Deployable, error) {
+	var hubs []appv1alpha1.Deployable
+
+	for _, name := range names {
+		hub := appv1alpha1.Deployable{}
+		if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &hub); err != nil {
+			return nil, err
+		}
+
+		hubs = append(hubs, hub)
+	}
+
+	if len(names) > 0 {
+		return hubs, nil
+	}
+
+	list := &appv1alpha1.DeployableList{}
+	if err := c.List(ctx, list, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+
+	for _, dpl := range list.Items {
+		if dpl.Spec.Placement != nil {
+			hubs = append(hubs, dpl)
+		}
+	}
+
+	return hubs, nil
+}
+
+// migrate moves a hub deployable to the ManifestWork backend, and returns the report of its clusters.
+func (mo *migrateOptions) migrate(ctx context.Context, c client.Client, hub *appv1alpha1.Deployable) ([]migrationEntry, error) {
+	key := hubKey(hub)
+
+	switch {
+	case hub.Spec.Placement == nil:
+		return []migrationEntry{{Deployable: key, Phase: migrationSkipped, Message: "not a hub deployable"}}, nil
+	case hub.Spec.Backend == appv1alpha1.PropagationBackendManifestWork:
+		return []migrationEntry{{Deployable: key, Phase: migrationMigrated, Message: "already propagated by ManifestWork"}}, nil
+	case len(hub.Spec.Dependencies) > 0:
+		return []migrationEntry{{Deployable: key, Phase: migrationSkipped, Message: "dependencies are only propagated by the Deployable backend"}}, nil
+	}
+
+	list := &appv1alpha1.DeployableList{}
+	if err := c.List(ctx, list, client.MatchingLabels{appv1alpha1.PropertyHostingDeployableName: hub.GetName()}); err != nil {
+		return nil, err
+	}
+
+	children := childrenOf(hub, list.Items)
+
+	rendered, err := deployable.RenderChildren(ctx, c, hub)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, ok := verifyMigration(hub, children, rendered)
+	if !ok || mo.dryRun {
+		return entries, nil
+	}
+
+	// the controller keeps each child until the ManifestWork of its cluster is available
+	patch := client.MergeFrom(hub.DeepCopy())
+	hub.Spec.Backend = appv1alpha1.PropagationBackendManifestWork
+
+	if err := c.Patch(ctx, hub, patch); err != nil {
+		return entries, err
+	}
+
+	phase := migrationSwitched
+
+	if mo.wait {
+		err := wait.PollImmediate(2*time.Second, mo.timeout, func() (bool, error) {
+			return isMigrated(ctx, c, hub, rendered)
+		})
+
+		if err != nil && err != wait.ErrWaitTimeout {
+			return entries, err
+		}
+
+		if err == nil {
+			phase = migrationMigrated
+		}
+	}
+
+	for i := range entries {
+		if entries[i].Phase != migrationExpired {
+			entries[i].Phase = phase
+		}
+	}
+
+	return entries, nil
+}
+
+// sameManifests tells if the ManifestWorks hold the same manifests, in the same order.
+func sameManifests(work, want *workv1.ManifestWork) bool {
+	if len(work.Spec.Workload.Manifests) != len(want.Spec.Workload.Manifests) {
+		return false
+	}
+
+	for i := range work.Spec.Workload.Manifests {
+		if utils.TemplateHash(&work.Spec.Workload.Manifests[i].RawExtension) != utils.TemplateHash(&want.Spec.Workload.Manifests[i].RawExtension) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// verifyMigration renders each child into a ManifestWork and compares it with the ManifestWork rendered for its cluster.
+// The migration goes on when no child differs, the children of the clusters no longer targeted are removed anyway.
+func verifyMigration(hub *appv1alpha1.Deployable, children []appv1alpha1.Deployable, rendered []*appv1alpha1.Deployable) ([]migrationEntry, bool) {
+	key := hubKey(hub)
+	expected := make(map[string]*workv1.ManifestWork)
+
+	for _, child := range rendered {
+		expected[child.GetNamespace()] = deployable.NewManifestWork(hub, child)
+	}
+
+	var entries []migrationEntry
+
+	ok := true
+	seen := make(map[string]bool)
+
+	for i := range children {
+		child := &children[i]
+		if isDependencyChild(hub, child) {
+			continue
+		}
+
+		entry := migrationEntry{Deployable: key, Cluster: childCluster(child), Phase: migrationVerified}
+		seen[child.GetNamespace()] = true
+
+		want, targeted := expected[child.GetNamespace()]
+		work := deployable.NewManifestWork(hub, child)
+
+		switch {
+		case !targeted:
+			entry.Phase, entry.Message = migrationExpired, "cluster no longer targeted, the child is removed"
+		case !sameManifests(work, want):
+			entry.Phase, entry.Message = migrationMismatch, "the template of the child differs from the template rendered for the cluster"
+			ok = false
+		}
+
+		entries = append(entries, entry)
+	}
+
+	for _, child := range rendered {
+		if !seen[child.GetNamespace()] {
+			entries = append(entries, migrationEntry{Deployable: key, Cluster: childCluster(child), Phase: migrationPending,
+				Message: "no child yet, the ManifestWork is created"})
+		}
+	}
+
+	return entries, ok
+}
+
+// isMigrated tells if the ManifestWorks of the rendered children are available, and the controller removed the children.
+func isMigrated(ctx context.Context, c client.Client, hub *appv1alpha1.Deployable, rendered []*appv1alpha1.Deployable) (bool, error) {
+	list := &appv1alpha1.DeployableList{}
+	if err := c.List(ctx, list, client.MatchingLabels{appv1alpha1.PropertyHostingDeployableName: hub.GetName()}); err != nil {
+		return false, err
+	}
+
+	if len(childrenOf(hub, list.Items)) > 0 {
+		return false, nil
+	}
+
+	works := &workv1.ManifestWorkList{}
+	if err := c.List(ctx, works, client.MatchingLabels{appv1alpha1.PropertyHostingDeployableName: hub.GetName()}); err != nil {
+		return false, err
+	}
+
+	hosting := hubKey(hub)
+	found := make(map[string]bool)
+
+	for _, work := range works.Items {
+		if work.GetAnnotations()[appv1alpha1.AnnotationHosting] == hosting && meta.IsStatusConditionTrue(work.Status.Conditions, workv1.WorkAvailable) {
+			found[work.GetNamespace()] = true
+		}
+	}
+
+	for _, child := range rendered {
+		if !found[child.GetNamespace()] {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (mo *migrateOptions) writeReport(report *migrationReport) error {
+	if mo.report == "" {
+		return nil
+	}
+
+	data, err := yaml.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(mo.report, data, 0600)
+}
+
+func hubKey(hub *appv1alpha1.Deployable) string {
+	return types.NamespacedName{Namespace: hub.GetNamespace(), Name: hub.GetName()}.String()
+}
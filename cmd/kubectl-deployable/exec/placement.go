@@ -0,0 +1,200 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	spokeClusterV1 "github.com/open-cluster-management/api/cluster/v1"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+	"github.com/stolostron/multicloud-operators-deployable/pkg/utils"
+	placementv1alpha1 "github.com/stolostron/multicloud-operators-placementrule/pkg/apis/apps/v1"
+)
+
+// placementRow explains the placement decision of a cluster.
+type placementRow struct {
+	cluster  string
+	selected bool
+	reasons  []string
+}
+
+func newExplainPlacementCommand(o *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "explain-placement NAME",
+		Short: "Show why each managed cluster is or is not selected by the placement of a hub deployable",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			c, hub, _, err := o.getHubDeployable(ctx, args[0])
+			if err != nil {
+				return err
+			}
+
+			rule, err := getPlacementRule(ctx, c, hub)
+			if err != nil {
+				return err
+			}
+
+			clusters := &spokeClusterV1.ManagedClusterList{}
+			if err := c.List(ctx, clusters); err != nil {
+				return err
+			}
+
+			w := newTable(o.out, "CLUSTER", "SELECTED", "REASON")
+
+			for _, row := range explainPlacement(hub, rule, clusters.Items, time.Now()) {
+				printRow(w, row.cluster, row.selected, strings.Join(row.reasons, "; "))
+			}
+
+			return w.Flush()
+		},
+	}
+}
+
+// getPlacementRule returns the placement rule referenced by the hub deployable, if any.
+func getPlacementRule(ctx context.Context, c client.Client, hub *appv1alpha1.Deployable) (*placementv1alpha1.PlacementRule, error) {
+	if hub.Spec.Placement == nil || hub.Spec.Placement.PlacementRef == nil {
+		return nil, nil
+	}
+
+	rule := &placementv1alpha1.PlacementRule{}
+	key := types.NamespacedName{Namespace: hub.GetNamespace(), Name: hub.Spec.Placement.PlacementRef.Name}
+
+	if err := c.Get(ctx, key, rule); err != nil {
+		return nil, err
+	}
+
+	return rule, nil
+}
+
+// explainPlacement explains the decision of the placement of the hub deployable for each managed cluster,
+// following the controller: the placementRef wins over the cluster names, which win over the cluster selector.
+func explainPlacement(hub *appv1alpha1.Deployable, rule *placementv1alpha1.PlacementRule,
+	clusters []spokeClusterV1.ManagedCluster, now time.Time) []placementRow {
+	rows := make([]placementRow, 0, len(clusters))
+
+	for i := range clusters {
+		cl := &clusters[i]
+		row := placementRow{cluster: cl.GetName()}
+
+		switch {
+		case hub.Spec.Placement == nil:
+			row.reasons = append(row.reasons, "deployable has no placement")
+		case hub.Spec.Placement.PlacementRef != nil:
+			row.selected, row.reasons = explainPlacementRef(hub.Spec.Placement.PlacementRef.Name, rule, cl)
+		case len(hub.Spec.Placement.Clusters) > 0:
+			row.selected, row.reasons = explainClusterNames(hub.Spec.Placement.Clusters, cl)
+		default:
+			row.selected, row.reasons = explainClusterSelector(hub.Spec.Placement.ClusterSelector, cl)
+		}
+
+		if row.selected {
+			next, err := utils.NextMaintenanceWindow(hub.Spec.MaintenanceWindows, labels.Set(cl.GetLabels()), now)
+
+			switch {
+			case err != nil:
+				row.reasons = append(row.reasons, "changes deferred until the "+err.Error()+" is fixed")
+			case !next.IsZero():
+				row.reasons = append(row.reasons, "changes deferred until the maintenance window at "+next.UTC().Format(time.RFC3339))
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows
+}
+
+func explainPlacementRef(name string, rule *placementv1alpha1.PlacementRule, cl *spokeClusterV1.ManagedCluster) (bool, []string) {
+	if rule == nil {
+		return false, []string{"placementRule " + name + " not found"}
+	}
+
+	for _, decision := range rule.Status.Decisions {
+		if decision.ClusterName == cl.GetName() {
+			return true, []string{"in the decisions of placementRule " + name}
+		}
+	}
+
+	return false, []string{"not in the decisions of placementRule " + name}
+}
+
+func explainClusterNames(names []placementv1alpha1.GenericClusterReference, cl *spokeClusterV1.ManagedCluster) (bool, []string) {
+	if terminating(cl) {
+		return false, []string{"cluster is terminating"}
+	}
+
+	// clusters are matched by their name label, like the placement rule controller
+	name := cl.GetLabels()["name"]
+
+	for _, ref := range names {
+		if ref.Name == name {
+			return true, []string{"listed in placement clusters"}
+		}
+	}
+
+	if name == "" {
+		return false, []string{"cluster has no name label"}
+	}
+
+	return false, []string{"not listed in placement clusters"}
+}
+
+func explainClusterSelector(selector *metav1.LabelSelector, cl *spokeClusterV1.ManagedCluster) (bool, []string) {
+	if terminating(cl) {
+		return false, []string{"cluster is terminating"}
+	}
+
+	clSelector, err := utils.ConvertLabels(selector)
+	if err != nil {
+		return false, []string{"invalid cluster selector: " + err.Error()}
+	}
+
+	requirements, _ := clSelector.Requirements()
+	if len(requirements) == 0 {
+		return true, []string{"cluster selector selects all clusters"}
+	}
+
+	set := labels.Set(cl.GetLabels())
+	selected := true
+
+	var reasons []string
+
+	for _, req := range requirements {
+		if req.Matches(set) {
+			reasons = append(reasons, "matches "+req.String())
+			continue
+		}
+
+		selected = false
+
+		reasons = append(reasons, "does not match "+req.String())
+	}
+
+	return selected, reasons
+}
+
+func terminating(cl *spokeClusterV1.ManagedCluster) bool {
+	return cl.DeletionTimestamp != nil && !cl.DeletionTimestamp.IsZero()
+}
@@ -0,0 +1,207 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ghodss/yaml"
+	spokeClusterV1 "github.com/open-cluster-management/api/cluster/v1"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+	"github.com/stolostron/multicloud-operators-deployable/pkg/controller/deployable"
+	placementv1alpha1 "github.com/stolostron/multicloud-operators-placementrule/pkg/apis/apps/v1"
+)
+
+// renderOptions are the files read by the render command.
+type renderOptions struct {
+	*options
+
+	filename      string
+	clusters      []string
+	placementRule string
+}
+
+func newRenderCommand(o *options) *cobra.Command {
+	ro := &renderOptions{options: o}
+
+	cmd := &cobra.Command{
+		Use:   "render -f DEPLOYABLE --clusters CLUSTERS [--placement-rule PLACEMENTRULE]",
+		Short: "Print the child deployables each cluster receives, without contacting any cluster",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return ro.run(cmd.Context())
+		},
+	}
+
+	cmd.Flags().StringVarP(&ro.filename, "filename", "f", "", "The YAML file of the hub deployable.")
+	cmd.Flags().StringSliceVar(&ro.clusters, "clusters", nil, "The YAML files of the managed clusters, with one or more clusters each.")
+	cmd.Flags().StringVar(&ro.placementRule, "placement-rule", "", "The YAML file of the placement rule referenced by the deployable, with its decisions.")
+
+	_ = cmd.MarkFlagRequired("filename")
+
+	return cmd
+}
+
+func (ro *renderOptions) run(ctx context.Context) error {
+	objs, err := readObjects(ro.filename)
+	if err != nil {
+		return err
+	}
+
+	if len(objs) != 1 || objs[0].GetKind() != "Deployable" {
+		return fmt.Errorf("%s must hold exactly one deployable", ro.filename)
+	}
+
+	hub := &appv1alpha1.Deployable{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(objs[0].Object, hub); err != nil {
+		return err
+	}
+
+	if hub.GetNamespace() == "" {
+		hub.SetNamespace(ro.namespace)
+	}
+
+	if hub.GetNamespace() == "" {
+		hub.SetNamespace("default")
+	}
+
+	var initObjs []client.Object
+
+	for _, path := range ro.clusters {
+		clusters, err := readObjects(path)
+		if err != nil {
+			return err
+		}
+
+		for _, obj := range clusters {
+			cl := &spokeClusterV1.ManagedCluster{}
+			if err := convertObject(obj, "ManagedCluster", cl); err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+
+			initObjs = append(initObjs, cl)
+		}
+	}
+
+	if ro.placementRule != "" {
+		rules, err := readObjects(ro.placementRule)
+		if err != nil {
+			return err
+		}
+
+		for _, obj := range rules {
+			rule := &placementv1alpha1.PlacementRule{}
+			if err := convertObject(obj, "PlacementRule", rule); err != nil {
+				return fmt.Errorf("%s: %v", ro.placementRule, err)
+			}
+
+			if rule.GetNamespace() == "" {
+				rule.SetNamespace(hub.GetNamespace())
+			}
+
+			initObjs = append(initObjs, rule)
+		}
+	}
+
+	scheme, err := newScheme()
+	if err != nil {
+		return err
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(initObjs...).Build()
+
+	children, err := deployable.RenderChildren(ctx, c, hub)
+	if err != nil {
+		return err
+	}
+
+	return printChildren(ro.out, children)
+}
+
+// printChildren prints the rendered children as a YAML stream.
+func printChildren(out io.Writer, children []*appv1alpha1.Deployable) error {
+	for _, child := range children {
+		child.SetGroupVersionKind(appv1alpha1.SchemeGroupVersion.WithKind("Deployable"))
+
+		data, err := yaml.Marshal(child)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "---\n%s", data)
+	}
+
+	return nil
+}
+
+// readObjects reads the objects of a YAML or JSON stream, expanding lists.
+func readObjects(path string) ([]*unstructured.Unstructured, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	var objs []*unstructured.Unstructured
+
+	decoder := utilyaml.NewYAMLOrJSONDecoder(f, 4096)
+
+	for {
+		obj := &unstructured.Unstructured{}
+		if err := decoder.Decode(&obj.Object); err != nil {
+			if err == io.EOF {
+				return objs, nil
+			}
+
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+
+		if len(obj.Object) == 0 {
+			continue
+		}
+
+		if !obj.IsList() {
+			objs = append(objs, obj)
+			continue
+		}
+
+		err := obj.EachListItem(func(item runtime.Object) error {
+			objs = append(objs, item.(*unstructured.Unstructured))
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+}
+
+func convertObject(obj *unstructured.Unstructured, kind string, into interface{}) error {
+	if obj.GetKind() != kind {
+		return fmt.Errorf("expected a %s, found a %s named %s", kind, obj.GetKind(), obj.GetName())
+	}
+
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, into)
+}
@@ -0,0 +1,185 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+	"github.com/stolostron/multicloud-operators-deployable/pkg/utils"
+)
+
+func newRolloutCommand(o *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rollout",
+		Short: "Manage the rolling update of a hub deployable",
+	}
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "status NAME",
+			Short: "Show the progress of the rolling update",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				_, hub, _, err := o.getHubDeployable(cmd.Context(), args[0])
+				if err != nil {
+					return err
+				}
+
+				printRolloutStatus(o.out, hub)
+
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "pause NAME",
+			Short: "Suspend the propagation of the hub deployable",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return o.setSuspend(cmd.Context(), args[0], true)
+			},
+		},
+		&cobra.Command{
+			Use:   "resume NAME",
+			Short: "Resume the propagation of the hub deployable",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return o.setSuspend(cmd.Context(), args[0], false)
+			},
+		},
+		&cobra.Command{
+			Use:   "undo NAME",
+			Short: "Restore the template and overrides saved when the rolling update started",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return o.undoRollout(cmd.Context(), args[0])
+			},
+		},
+	)
+
+	return cmd
+}
+
+func (o *options) setSuspend(ctx context.Context, name string, suspend bool) error {
+	c, namespace, err := o.client()
+	if err != nil {
+		return err
+	}
+
+	hub := &appv1alpha1.Deployable{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, hub); err != nil {
+		return err
+	}
+
+	patch := client.MergeFrom(hub.DeepCopy())
+	hub.Spec.Suspend = suspend
+
+	if err := c.Patch(ctx, hub, patch); err != nil {
+		return err
+	}
+
+	action := "resumed"
+	if suspend {
+		action = "paused"
+	}
+
+	fmt.Fprintf(o.out, "deployable/%s %s\n", name, action)
+
+	return nil
+}
+
+func (o *options) undoRollout(ctx context.Context, name string) error {
+	c, namespace, err := o.client()
+	if err != nil {
+		return err
+	}
+
+	hub := &appv1alpha1.Deployable{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, hub); err != nil {
+		return err
+	}
+
+	if !utils.RollbackRollingUpdate(hub) {
+		return fmt.Errorf("deployable/%s has no previous template to roll back to", name)
+	}
+
+	if err := c.Update(ctx, hub); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(o.out, "deployable/%s rolled back\n", name)
+
+	return nil
+}
+
+// printRolloutStatus prints the target, stages, failed clusters and progress of the rolling update.
+func printRolloutStatus(out io.Writer, hub *appv1alpha1.Deployable) {
+	if hub.Spec.Suspend {
+		fmt.Fprintf(out, "deployable/%s is paused\n", hub.GetName())
+	}
+
+	rus := hub.Status.RollingUpdate
+	if rus == nil {
+		fmt.Fprintf(out, "deployable/%s has no rolling update\n", hub.GetName())
+		return
+	}
+
+	fmt.Fprintf(out, "Target:\t%s\n", orNone(rus.Target))
+
+	if rus.StartTime != nil {
+		fmt.Fprintf(out, "Started:\t%s\n", rus.StartTime.Format(time.RFC3339))
+	}
+
+	fmt.Fprintf(out, "Rollback:\t%t\n", rus.PreviousTemplate != nil)
+
+	if len(rus.FailedClusters) > 0 {
+		fmt.Fprintf(out, "Failed clusters:\t%s\n", strings.Join(rus.FailedClusters, ", "))
+	}
+
+	if cond := meta.FindStatusCondition(hub.Status.Conditions, appv1alpha1.ConditionProgressing); cond != nil {
+		fmt.Fprintf(out, "Progressing:\t%s (%s) %s\n", cond.Status, cond.Reason, cond.Message)
+	}
+
+	if len(rus.Stages) == 0 {
+		return
+	}
+
+	fmt.Fprintln(out)
+
+	w := newTable(out, "STAGE", "PHASE", "UPDATED", "APPROVED-BY")
+
+	for _, stage := range rus.Stages {
+		approver := ""
+
+		for _, approval := range rus.Approvals {
+			if approval.Stage == stage.Name {
+				approver = approval.Approver
+			}
+		}
+
+		printRow(w, stage.Name, stage.Phase, fmt.Sprintf("%d/%d", stage.UpdatedClusters, len(stage.Clusters)), orNone(approver))
+	}
+
+	w.Flush()
+}
@@ -0,0 +1,192 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package exec implements the kubectl-deployable plugin commands.
+package exec
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/stolostron/multicloud-operators-deployable/pkg/apis"
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+	"github.com/stolostron/multicloud-operators-deployable/pkg/utils"
+)
+
+// options are the flags shared by all commands.
+type options struct {
+	kubeconfig string
+	context    string
+	namespace  string
+
+	out io.Writer
+}
+
+// NewCommand returns the kubectl-deployable command, writing its output to out.
+func NewCommand(out io.Writer) *cobra.Command {
+	o := &options{out: out}
+
+	cmd := &cobra.Command{
+		Use:          "kubectl-deployable",
+		Short:        "Inspect hub deployables, their children in clusters and their rolling updates",
+		SilenceUsage: true,
+	}
+
+	cmd.PersistentFlags().StringVar(&o.kubeconfig, "kubeconfig", "", "Path to the kubeconfig file of the hub.")
+	cmd.PersistentFlags().StringVar(&o.context, "context", "", "The kubeconfig context to use.")
+	cmd.PersistentFlags().StringVarP(&o.namespace, "namespace", "n", "", "The namespace of the hub deployable.")
+
+	cmd.AddCommand(
+		newTreeCommand(o),
+		newStatusCommand(o),
+		newRolloutCommand(o),
+		newExplainPlacementCommand(o),
+		newRenderCommand(o),
+		newDiffCommand(o),
+		newMigrateCommand(o),
+	)
+
+	return cmd
+}
+
+// client connects to the hub and returns the namespace of the hub deployables.
+func (o *options) client() (client.Client, string, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = o.kubeconfig
+
+	kubeconfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{CurrentContext: o.context})
+
+	namespace := o.namespace
+	if namespace == "" {
+		var err error
+
+		if namespace, _, err = kubeconfig.Namespace(); err != nil {
+			return nil, "", err
+		}
+	}
+
+	cfg, err := kubeconfig.ClientConfig()
+	if err != nil {
+		return nil, "", err
+	}
+
+	scheme, err := newScheme()
+	if err != nil {
+		return nil, "", err
+	}
+
+	c, err := client.New(cfg, client.Options{Scheme: scheme})
+
+	return c, namespace, err
+}
+
+func newScheme() (*runtime.Scheme, error) {
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+
+	return scheme, nil
+}
+
+// getHubDeployable returns the hub deployable with its children, sorted by cluster and name.
+func (o *options) getHubDeployable(ctx context.Context, name string) (client.Client, *appv1alpha1.Deployable, []appv1alpha1.Deployable, error) {
+	c, namespace, err := o.client()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	hub := &appv1alpha1.Deployable{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, hub); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// label values cannot hold the namespace, the hosting annotation tells the children of this hub deployable
+	list := &appv1alpha1.DeployableList{}
+	if err := c.List(ctx, list, client.MatchingLabels{appv1alpha1.PropertyHostingDeployableName: name}); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return c, hub, childrenOf(hub, list.Items), nil
+}
+
+// childrenOf filters the children of the hub deployable, sorted by cluster and name.
+func childrenOf(hub *appv1alpha1.Deployable, dpls []appv1alpha1.Deployable) []appv1alpha1.Deployable {
+	hosting := types.NamespacedName{Namespace: hub.GetNamespace(), Name: hub.GetName()}.String()
+
+	var children []appv1alpha1.Deployable
+
+	for _, dpl := range dpls {
+		if dpl.GetAnnotations()[appv1alpha1.AnnotationHosting] == hosting {
+			children = append(children, dpl)
+		}
+	}
+
+	sort.Slice(children, func(i, j int) bool {
+		ci, cj := childCluster(&children[i]), childCluster(&children[j])
+		if ci != cj {
+			return ci < cj
+		}
+
+		return children[i].GetName() < children[j].GetName()
+	})
+
+	return children
+}
+
+// childCluster returns the name of the managed cluster of a child deployable.
+func childCluster(child *appv1alpha1.Deployable) string {
+	if key := utils.GetClusterFromResourceObject(child); key != nil && key.Name != "" {
+		return key.Name
+	}
+
+	return child.GetNamespace()
+}
+
+func newTable(out io.Writer, header ...interface{}) *tabwriter.Writer {
+	w := tabwriter.NewWriter(out, 0, 4, 3, ' ', 0)
+	printRow(w, header...)
+
+	return w
+}
+
+func printRow(w io.Writer, columns ...interface{}) {
+	for i, col := range columns {
+		if i > 0 {
+			fmt.Fprint(w, "\t")
+		}
+
+		fmt.Fprint(w, col)
+	}
+
+	fmt.Fprintln(w)
+}
+
+// orNone prints empty values as <none>, like kubectl.
+func orNone(value string) string {
+	if value == "" {
+		return "<none>"
+	}
+
+	return value
+}
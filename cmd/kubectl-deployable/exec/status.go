@@ -0,0 +1,167 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/meta"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+	"github.com/stolostron/multicloud-operators-deployable/pkg/utils"
+)
+
+// clusterRow is a row of the status table.
+type clusterRow struct {
+	cluster string
+	status  appv1alpha1.ResourceUnitStatus
+	drifted bool
+}
+
+func newStatusCommand(o *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "status NAME",
+		Short: "Show the phase of a hub deployable in each cluster",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, hub, children, err := o.getHubDeployable(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+
+			printStatus(o.out, hub, children)
+
+			return nil
+		},
+	}
+}
+
+// clusterRows merges the cluster status of the hub deployable with the status of its children.
+// The hub only keeps the failing clusters when its cluster status is summarized, the children tell the others.
+func clusterRows(hub *appv1alpha1.Deployable, children []appv1alpha1.Deployable) []clusterRow {
+	rows := make(map[string]*clusterRow)
+
+	for cluster, status := range hub.Status.PropagatedStatus {
+		if status == nil {
+			continue
+		}
+
+		rows[cluster] = &clusterRow{
+			cluster: cluster,
+			status:  *status,
+			drifted: meta.IsStatusConditionTrue(status.Conditions, appv1alpha1.ConditionDrifted),
+		}
+	}
+
+	for i := range children {
+		child := &children[i]
+		if isDependencyChild(hub, child) {
+			continue
+		}
+
+		cluster := childCluster(child)
+
+		row, ok := rows[cluster]
+		if !ok {
+			row = &clusterRow{cluster: cluster, status: child.Status.ResourceUnitStatus}
+			rows[cluster] = row
+		}
+
+		row.drifted = row.drifted || utils.IsDeployableDrifted(child)
+	}
+
+	sorted := make([]clusterRow, 0, len(rows))
+	for _, row := range rows {
+		sorted = append(sorted, *row)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].cluster < sorted[j].cluster })
+
+	return sorted
+}
+
+func printStatus(out io.Writer, hub *appv1alpha1.Deployable, children []appv1alpha1.Deployable) {
+	rows := clusterRows(hub, children)
+
+	w := newTable(out, "CLUSTER", "PHASE", "REASON", "DEFERRED-UNTIL", "DRIFTED", "MESSAGE")
+
+	for _, row := range rows {
+		deferred := ""
+		if row.status.DeferredUntil != nil {
+			deferred = row.status.DeferredUntil.Format(time.RFC3339)
+		}
+
+		printRow(w, row.cluster, orNone(string(row.status.Phase)), orNone(row.status.Reason), orNone(deferred), row.drifted, row.status.Message)
+	}
+
+	w.Flush()
+
+	if plan := hub.Status.Plan; plan != nil {
+		fmt.Fprintf(out, "\nDry run plan for generation %d:\n", plan.ObservedGeneration)
+
+		pw := tabwriter.NewWriter(out, 0, 4, 3, ' ', 0)
+		printRow(pw, "  Create:", orNone(strings.Join(plan.Create, ", ")))
+		printRow(pw, "  Update:", orNone(strings.Join(plan.Update, ", ")))
+		printRow(pw, "  Delete:", orNone(strings.Join(plan.Delete, ", ")))
+		printRow(pw, "  Deferred:", orNone(strings.Join(plan.Deferred, ", ")))
+		printRow(pw, "  Rollout batch:", orNone(strings.Join(plan.RolloutBatch, ", ")))
+		pw.Flush()
+	}
+
+	if chart := hub.Status.Chart; chart != nil {
+		fmt.Fprintf(out, "\nChart %s %s rendered to %s\n", chart.Name, chart.Version, chart.RenderedHash)
+	}
+
+	if len(hub.Status.Channels) > 0 {
+		fmt.Fprintln(out, "\nChannels:")
+
+		names := make([]string, 0, len(hub.Status.Channels))
+		for name := range hub.Status.Channels {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		cw := tabwriter.NewWriter(out, 0, 4, 3, ' ', 0)
+
+		for _, name := range names {
+			channel := hub.Status.Channels[name]
+			printRow(cw, "  "+name, string(channel.Phase), orNone(channel.Deployable), channel.Message)
+		}
+
+		cw.Flush()
+	}
+
+	if summary := hub.Status.ClusterSummary; summary != nil {
+		fmt.Fprintf(out, "\n%d clusters: %d deployed, %d failed, %d pending, %d deferred, %d drifted\n",
+			summary.Total, summary.Deployed, summary.Failed, summary.Pending, summary.Deferred, summary.Drifted)
+
+		return
+	}
+
+	phases := make(map[appv1alpha1.DeployablePhase]int)
+	for _, row := range rows {
+		phases[row.status.Phase]++
+	}
+
+	fmt.Fprintf(out, "\n%d clusters: %d deployed, %d failed, %d propagated\n", len(rows),
+		phases[appv1alpha1.DeployableDeployed], phases[appv1alpha1.DeployableFailed], phases[appv1alpha1.DeployablePropagated])
+}
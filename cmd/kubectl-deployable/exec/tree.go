@@ -0,0 +1,90 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+	"github.com/stolostron/multicloud-operators-deployable/pkg/utils"
+)
+
+func newTreeCommand(o *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "tree NAME",
+		Short: "Show a hub deployable with its children and dependencies per cluster",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, hub, children, err := o.getHubDeployable(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+
+			printTree(o.out, hub, children)
+
+			return nil
+		},
+	}
+}
+
+// printTree prints the hub deployable, then its children grouped by cluster.
+func printTree(out io.Writer, hub *appv1alpha1.Deployable, children []appv1alpha1.Deployable) {
+	fmt.Fprintf(out, "Deployable/%s (%s) %s\n", hub.GetName(), templateRef(hub), orNone(string(hub.Status.Phase)))
+
+	for i := range children {
+		child := &children[i]
+		cluster := childCluster(child)
+
+		if i == 0 || cluster != childCluster(&children[i-1]) {
+			fmt.Fprintf(out, "└── Cluster/%s\n", cluster)
+		}
+
+		role := ""
+		if isDependencyChild(hub, child) {
+			role = " [dependency]"
+		}
+
+		drifted := ""
+		if utils.IsDeployableDrifted(child) {
+			drifted = " [drifted]"
+		}
+
+		fmt.Fprintf(out, "    └── Deployable/%s (%s) %s%s%s\n",
+			child.GetNamespace()+"/"+child.GetName(), templateRef(child), orNone(string(child.Status.Phase)), role, drifted)
+	}
+}
+
+// isDependencyChild tells if the child was generated for a dependency rather than for the template of the hub deployable.
+func isDependencyChild(hub, child *appv1alpha1.Deployable) bool {
+	return child.GetGenerateName() != "" && child.GetGenerateName() != hub.GetName()+"-"
+}
+
+// templateRef returns the kind and name of the template of the deployable.
+func templateRef(dpl *appv1alpha1.Deployable) string {
+	if dpl.Spec.Template == nil {
+		return "<no template>"
+	}
+
+	tpl := &unstructured.Unstructured{}
+	if err := tpl.UnmarshalJSON(dpl.Spec.Template.Raw); err != nil {
+		return "<invalid template>"
+	}
+
+	return tpl.GetKind() + "/" + tpl.GetName()
+}
@@ -0,0 +1,103 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	spokeClusterV1 "github.com/open-cluster-management/api/cluster/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// cacheSyncTimeout bounds how long the readiness check waits for the caches to sync.
+	cacheSyncTimeout = time.Second
+	// clusterRegistryTimeout bounds how long the readiness check waits for the cluster registry API.
+	clusterRegistryTimeout = 2 * time.Second
+)
+
+var leaderGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "deployable",
+	Name:      "leader",
+	Help:      "1 if this manager is the elected leader running the controllers, 0 otherwise.",
+})
+
+func init() {
+	metrics.Registry.MustRegister(leaderGauge)
+}
+
+// addHealthChecks registers the liveness and readiness checks of the manager.
+// The controllers add their own liveness checks.
+func addHealthChecks(mgr manager.Manager) error {
+	if err := mgr.AddHealthzCheck("ping", healthz.Ping); err != nil {
+		return err
+	}
+
+	if err := mgr.AddReadyzCheck("cache-sync", cacheSyncCheck(mgr.GetCache())); err != nil {
+		return err
+	}
+
+	return mgr.AddReadyzCheck("cluster-registry", clusterRegistryCheck(mgr.GetAPIReader()))
+}
+
+func cacheSyncCheck(c cache.Cache) healthz.Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), cacheSyncTimeout)
+		defer cancel()
+
+		if !c.WaitForCacheSync(ctx) {
+			return errors.New("caches are not synced")
+		}
+
+		return nil
+	}
+}
+
+// clusterRegistryCheck lists the managed clusters from the API server on each probe, failing while the cluster registry
+// API is not served.
+func clusterRegistryCheck(reader client.Reader) healthz.Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), clusterRegistryTimeout)
+		defer cancel()
+
+		if err := reader.List(ctx, &spokeClusterV1.ManagedClusterList{}, client.Limit(1)); err != nil {
+			return fmt.Errorf("cluster registry API is not available: %w", err)
+		}
+
+		return nil
+	}
+}
+
+// watchLeaderElection reports when the manager is elected leader, in the logs and the leader gauge.
+func watchLeaderElection(ctx context.Context, mgr manager.Manager) {
+	go func() {
+		select {
+		case <-mgr.Elected():
+			klog.Info("Elected leader, starting the controllers")
+			leaderGauge.Set(1)
+		case <-ctx.Done():
+		}
+	}()
+}
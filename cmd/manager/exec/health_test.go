@@ -0,0 +1,41 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/onsi/gomega"
+	spokeClusterV1 "github.com/open-cluster-management/api/cluster/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestClusterRegistryCheck(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	req := httptest.NewRequest("GET", "/readyz", nil)
+
+	// the cluster registry API is not served
+	absent := fake.NewClientBuilder().WithScheme(runtime.NewScheme()).Build()
+	g.Expect(clusterRegistryCheck(absent)(req)).To(gomega.MatchError(gomega.ContainSubstring("cluster registry API is not available")))
+
+	scheme := runtime.NewScheme()
+	g.Expect(spokeClusterV1.AddToScheme(scheme)).To(gomega.Succeed())
+
+	served := fake.NewClientBuilder().WithScheme(scheme).Build()
+	g.Expect(clusterRegistryCheck(served)(req)).To(gomega.Succeed())
+}
@@ -15,16 +15,26 @@
 package exec
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/stolostron/multicloud-operators-deployable/pkg/apis"
 	"github.com/stolostron/multicloud-operators-deployable/pkg/controller"
+	"github.com/stolostron/multicloud-operators-deployable/pkg/controller/deployable"
+	dplutils "github.com/stolostron/multicloud-operators-deployable/pkg/utils"
+	"github.com/stolostron/multicloud-operators-deployable/pkg/webhook"
 	"github.com/stolostron/multicloud-operators-placementrule/pkg/utils"
 
+	"go.uber.org/zap/zapcore"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/rest"
 	"k8s.io/klog"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
 )
 
@@ -35,8 +45,100 @@ var (
 	operatorMetricsPort int = 8688
 )
 
+// setupLogger sets the structured logger of the controllers in the format of --log-format, at the -v verbosity.
+func setupLogger() error {
+	verbosity := 0
+
+	if v := flag.Lookup("v"); v != nil {
+		verbosity, _ = strconv.Atoi(v.Value.String())
+	}
+
+	opts := []zap.Opts{zap.Level(zapcore.Level(-verbosity))}
+
+	switch options.LogFormat {
+	case "json":
+		opts = append(opts, zap.JSONEncoder())
+	case "text":
+		opts = append(opts, zap.ConsoleEncoder())
+	default:
+		return fmt.Errorf("unsupported log format %q, expected text or json", options.LogFormat)
+	}
+
+	ctrl.SetLogger(zap.New(opts...))
+
+	return nil
+}
+
+// setTemplateKindsPolicy sets the template kinds policy of the flags and its ConfigMap.
+func setTemplateKindsPolicy() error {
+	flags := dplutils.TemplateKindsPolicy{
+		TemplateKindRules: dplutils.TemplateKindRules{Allowed: options.AllowedTemplateKinds, Denied: options.DeniedTemplateKinds},
+	}
+
+	if options.TemplateKindsConfigMap == "" {
+		dplutils.SetTemplateKindsPolicy(flags, nil)
+
+		return nil
+	}
+
+	parts := strings.Split(options.TemplateKindsConfigMap, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("invalid template kinds configmap %q, expected namespace/name", options.TemplateKindsConfigMap)
+	}
+
+	dplutils.SetTemplateKindsPolicy(flags, &types.NamespacedName{Namespace: parts[0], Name: parts[1]})
+
+	return nil
+}
+
+// controllerUser returns the user of the controller, its service account given by the environment if not set.
+func controllerUser() string {
+	if options.ControllerUser != "" {
+		return options.ControllerUser
+	}
+
+	namespace, serviceAccount := os.Getenv("POD_NAMESPACE"), os.Getenv("SERVICE_ACCOUNT")
+	if namespace == "" || serviceAccount == "" {
+		klog.Info("The user of the controller is unknown, set --controller-user so that the admission webhook ignores it")
+
+		return ""
+	}
+
+	return "system:serviceaccount:" + namespace + ":" + serviceAccount
+}
+
 // RunManager starts the actual manager
 func RunManager() {
+	if err := setupLogger(); err != nil {
+		klog.Error(err, "")
+		os.Exit(1)
+	}
+
+	if err := deployable.SetDefaultBackend(options.DefaultBackend); err != nil {
+		klog.Error(err, "")
+		os.Exit(1)
+	}
+
+	deployable.SetFileSinkDir(options.FileSinkDir)
+	dplutils.SetHelmChartSources(options.HelmChartPaths, options.HelmChartRegistries)
+
+	if err := deployable.SetDefaultLocalClusterNaming(options.LocalClusterNaming, options.LocalClusterNamingValue, options.LocalClusterNamingKinds); err != nil {
+		klog.Error(err, "")
+		os.Exit(1)
+	}
+
+	if options.AuthorizePropagation && !options.EnableWebhook {
+		klog.Error("--authorize-propagation requires --enable-webhook to record the user of the deployables")
+		os.Exit(1)
+	}
+
+	deployable.SetAuthorizePropagation(options.AuthorizePropagation)
+
+	if err := setTemplateKindsPolicy(); err != nil {
+		klog.Error(err, "")
+		os.Exit(1)
+	}
+
 	enableLeaderElection := false
 
 	if _, err := rest.InClusterConfig(); err == nil {
@@ -54,6 +156,8 @@ func RunManager() {
 		LeaderElection:          enableLeaderElection,
 		LeaderElectionID:        "multicloud-operators-deployable-leader.open-cluster-management.io",
 		LeaderElectionNamespace: "kube-system",
+		HealthProbeBindAddress:  options.HealthProbeAddr,
+		CertDir:                 options.WebhookCertDir,
 	})
 
 	if err != nil {
@@ -75,11 +179,41 @@ func RunManager() {
 		os.Exit(1)
 	}
 
+	if options.EnableWebhook {
+		webhook.SetControllerUser(controllerUser())
+
+		if err := webhook.AddToManager(mgr); err != nil {
+			klog.Error(err, "")
+			os.Exit(1)
+		}
+	}
+
+	if err := addHealthChecks(mgr); err != nil {
+		klog.Error(err, "")
+		os.Exit(1)
+	}
+
 	sig := signals.SetupSignalHandler()
 
+	if options.TracingEndpoint != "" {
+		shutdown, err := dplutils.InitTracing(sig, options.TracingEndpoint, options.TracingInsecure)
+		if err != nil {
+			klog.Error(err, "")
+			os.Exit(1)
+		}
+
+		defer func() {
+			if err := shutdown(context.Background()); err != nil {
+				klog.Error("Failed to flush traces with error: ", err)
+			}
+		}()
+	}
+
 	klog.Info("Detecting ACM cluster API service...")
 	utils.DetectClusterRegistry(sig, mgr.GetAPIReader())
 
+	watchLeaderElection(sig, mgr)
+
 	klog.Info("Starting the Cmd.")
 
 	// Start the Cmd
@@ -20,11 +20,47 @@ import (
 
 // PlacementRuleCMDOptions for command line flag parsing
 type PlacementRuleCMDOptions struct {
-	MetricsAddr string
+	MetricsAddr             string
+	HealthProbeAddr         string
+	TracingEndpoint         string
+	TracingInsecure         bool
+	LogFormat               string
+	DefaultBackend          string
+	FileSinkDir             string
+	HelmChartPaths          []string
+	HelmChartRegistries     []string
+	LocalClusterNaming      string
+	LocalClusterNamingValue string
+	LocalClusterNamingKinds []string
+	EnableWebhook           bool
+	WebhookCertDir          string
+	ControllerUser          string
+	AuthorizePropagation    bool
+	AllowedTemplateKinds    []string
+	DeniedTemplateKinds     []string
+	TemplateKindsConfigMap  string
 }
 
 var options = PlacementRuleCMDOptions{
-	MetricsAddr: "",
+	MetricsAddr:             "",
+	HealthProbeAddr:         ":8081",
+	TracingEndpoint:         "",
+	TracingInsecure:         false,
+	LogFormat:               "text",
+	DefaultBackend:          "Deployable",
+	FileSinkDir:             "",
+	HelmChartPaths:          nil,
+	HelmChartRegistries:     nil,
+	LocalClusterNaming:      "Suffix",
+	LocalClusterNamingValue: "",
+	LocalClusterNamingKinds: nil,
+	EnableWebhook:           false,
+	WebhookCertDir:          "",
+	ControllerUser:          "",
+	AuthorizePropagation:    false,
+	AllowedTemplateKinds:    nil,
+	DeniedTemplateKinds:     nil,
+	TemplateKindsConfigMap:  "",
 }
 
 // ProcessFlags parses command line parameters into options
@@ -37,4 +73,133 @@ func ProcessFlags() {
 		options.MetricsAddr,
 		"The address the metric endpoint binds to.",
 	)
+
+	flag.StringVar(
+		&options.HealthProbeAddr,
+		"health-probe-addr",
+		options.HealthProbeAddr,
+		"The address the liveness /healthz and readiness /readyz endpoints bind to. Probes are disabled if empty.",
+	)
+
+	flag.StringVar(
+		&options.TracingEndpoint,
+		"tracing-endpoint",
+		options.TracingEndpoint,
+		"The OTLP gRPC collector endpoint to export reconcile traces to, like localhost:4317. Tracing is disabled if empty.",
+	)
+
+	flag.BoolVar(
+		&options.TracingInsecure,
+		"tracing-insecure",
+		options.TracingInsecure,
+		"Connect to the tracing collector without TLS.",
+	)
+
+	flag.StringVar(
+		&options.LogFormat,
+		"log-format",
+		options.LogFormat,
+		"The format of the controller logs, text or json. The verbosity is set by -v.",
+	)
+
+	flag.StringVar(
+		&options.DefaultBackend,
+		"default-backend",
+		options.DefaultBackend,
+		"The propagation backend of the deployables that do not set spec.backend, Deployable, ManifestWork, Local or File.",
+	)
+
+	flag.StringVar(
+		&options.FileSinkDir,
+		"file-sink-dir",
+		options.FileSinkDir,
+		"The directory the File backend writes the child deployable of each cluster to, a directory per cluster namespace.",
+	)
+
+	flag.StringSliceVar(
+		&options.HelmChartPaths,
+		"helm-chart-paths",
+		options.HelmChartPaths,
+		"The directories the HelmChart templates may read a chart path from. No chart path may be read if empty.",
+	)
+
+	flag.StringSliceVar(
+		&options.HelmChartRegistries,
+		"helm-chart-registries",
+		options.HelmChartRegistries,
+		"The registry hosts, as host or host:port, the HelmChart templates may pull an oci chart from. No registry may be used if empty.",
+	)
+
+	flag.StringVar(
+		&options.LocalClusterNaming,
+		"local-cluster-naming",
+		options.LocalClusterNaming,
+		"How the deployables that do not set spec.localClusterNaming rename their template for the local cluster, Suffix, Prefix, Hash or None.",
+	)
+
+	flag.StringVar(
+		&options.LocalClusterNamingValue,
+		"local-cluster-naming-value",
+		options.LocalClusterNamingValue,
+		"The suffix or the prefix of --local-cluster-naming, -local or local- if empty.",
+	)
+
+	flag.StringSliceVar(
+		&options.LocalClusterNamingKinds,
+		"local-cluster-naming-kinds",
+		options.LocalClusterNamingKinds,
+		"The template kinds renamed by --local-cluster-naming, every kind if empty.",
+	)
+
+	flag.BoolVar(
+		&options.EnableWebhook,
+		"enable-webhook",
+		options.EnableWebhook,
+		"Serve the admission webhook recording the user that last changed what the deployables propagate.",
+	)
+
+	flag.StringVar(
+		&options.WebhookCertDir,
+		"webhook-cert-dir",
+		options.WebhookCertDir,
+		"The directory of the tls.crt and tls.key of the admission webhook, the controller-runtime default if empty.",
+	)
+
+	flag.StringVar(
+		&options.ControllerUser,
+		"controller-user",
+		options.ControllerUser,
+		"The user of the controller, whose requests the admission webhook never records nor checks, "+
+			"system:serviceaccount:$POD_NAMESPACE:$SERVICE_ACCOUNT if empty.",
+	)
+
+	flag.BoolVar(
+		&options.AuthorizePropagation,
+		"authorize-propagation",
+		options.AuthorizePropagation,
+		"Review with SubjectAccessReview that the user that last changed a deployable may create its template in each cluster namespace. "+
+			"Requires --enable-webhook.",
+	)
+
+	flag.StringSliceVar(
+		&options.AllowedTemplateKinds,
+		"allowed-template-kinds",
+		options.AllowedTemplateKinds,
+		"The template kinds the deployables may propagate, as Kind or Kind.group, * or *.group for every kind. Every kind if empty.",
+	)
+
+	flag.StringSliceVar(
+		&options.DeniedTemplateKinds,
+		"denied-template-kinds",
+		options.DeniedTemplateKinds,
+		"The template kinds the deployables may not propagate, even if allowed, as Kind or Kind.group, * or *.group for every kind.",
+	)
+
+	flag.StringVar(
+		&options.TemplateKindsConfigMap,
+		"template-kinds-configmap",
+		options.TemplateKindsConfigMap,
+		"The namespace/name of the ConfigMap whose policy.yaml replaces --allowed-template-kinds and --denied-template-kinds when found, "+
+			"with rules per namespace.",
+	)
 }
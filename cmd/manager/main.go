@@ -17,6 +17,9 @@ package main
 import (
 	"flag"
 
+	// maintenance window time zones do not depend on the image zoneinfo
+	_ "time/tzdata"
+
 	"github.com/spf13/pflag"
 
 	"k8s.io/klog"
@@ -16,6 +16,7 @@ package apis
 
 import (
 	spokeClusterV1 "github.com/open-cluster-management/api/cluster/v1"
+	workv1 "github.com/open-cluster-management/api/work/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/klog"
 
@@ -33,6 +34,12 @@ func AddToScheme(s *runtime.Scheme) error {
 		return err
 	}
 
+	// add manifestwork scheme
+	if err := workv1.AddToScheme(s); err != nil {
+		klog.Error("unable add manifestwork to scheme", err)
+		return err
+	}
+
 	// add placementrule scheme
 	if err := placementruleapis.AddToScheme(s); err != nil {
 		klog.Error("unable add cluster to scheme", err)
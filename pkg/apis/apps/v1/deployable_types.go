@@ -24,6 +24,19 @@ import (
 var (
 	// AnnotationRollingUpdateMaxUnavailable defines max un available clusters during rolling update.
 	AnnotationRollingUpdateMaxUnavailable = SchemeGroupVersion.Group + "/rollingupdate-maxunavaialble"
+	// AnnotationRollingUpdateMaxFailure defines the failure budget of a rolling update, as a count or percentage of clusters.
+	AnnotationRollingUpdateMaxFailure = SchemeGroupVersion.Group + "/rollingupdate-maxfailure"
+	// AnnotationRollingUpdateProgressDeadline defines how long an updated cluster may stay undeployed before it counts as failed.
+	AnnotationRollingUpdateProgressDeadline = SchemeGroupVersion.Group + "/rollingupdate-progressdeadline"
+	// AnnotationRollingUpdateFailurePolicy defines what to do when the failure budget is exceeded, Halt or Rollback.
+	AnnotationRollingUpdateFailurePolicy = SchemeGroupVersion.Group + "/rollingupdate-failurepolicy"
+	// AnnotationRollingUpdateApprovedBy approves the rollout stage waiting for approval. Its value is informational, the
+	// approver is the user recorded in AnnotationRollingUpdateApprover. It is removed once the approval is recorded in status.
+	AnnotationRollingUpdateApprovedBy = SchemeGroupVersion.Group + "/rollingupdate-approved-by"
+	// AnnotationRollingUpdateApprover is the user that set AnnotationRollingUpdateApprovedBy, recorded by the admission webhook.
+	AnnotationRollingUpdateApprover = SchemeGroupVersion.Group + "/rollingupdate-approver"
+	// AnnotationRollingUpdateApprovedStage optionally names the stage approved by AnnotationRollingUpdateApprovedBy.
+	AnnotationRollingUpdateApprovedStage = SchemeGroupVersion.Group + "/rollingupdate-approved-stage"
 	// AnnotationRollingUpdateTarget target deployable to rolling update to.
 	AnnotationRollingUpdateTarget = SchemeGroupVersion.Group + "/rollingupdate-target"
 	// AnnotationDeployableVersion sits in deployable resource to identify if it is local deployable.
@@ -43,8 +56,30 @@ var (
 	AnnotationSubscription = SchemeGroupVersion.Group + "/hosting-subscription"
 	// AnnotationIsGenerated tells if the deployable is generated by controller or not.
 	AnnotationIsGenerated = SchemeGroupVersion.Group + "/is-generated"
+	// AnnotationTemplateHash sits in child deployables, giving the hash of the template rendered for the cluster.
+	// A child whose template no longer matches the hash was edited out of band.
+	AnnotationTemplateHash = SchemeGroupVersion.Group + "/template-hash"
+	// AnnotationHandedOver sits in child deployables handed over to the ManifestWork backend, giving when their resources
+	// were marked with AnnotationDoNotDelete.
+	AnnotationHandedOver = SchemeGroupVersion.Group + "/handed-over"
+	// AnnotationDoNotDelete sits in the resources of a child deployable, telling the subscription agent of the managed
+	// cluster to leave them in place when the child is deleted.
+	AnnotationDoNotDelete = SchemeGroupVersion.Group + "/do-not-delete"
+	// AnnotationChannel sits in the deployables published into a channel, giving the NamespacedName of the channel.
+	AnnotationChannel = SchemeGroupVersion.Group + "/channel"
+	// LabelChannelSource sits in the deployables published into a channel, giving the name of the published deployable.
+	LabelChannelSource = SchemeGroupVersion.Group + "/channel-source"
+	// LabelLocalInventory sits in the ConfigMaps the Local backend keeps in the cluster namespaces, listing the resources
+	// it applied to the hub for the hosting deployable.
+	LabelLocalInventory = SchemeGroupVersion.Group + "/local-inventory"
+	// AnnotationUserIdentity sits in hub deployables, giving the user that last changed what they propagate, recorded at admission.
+	AnnotationUserIdentity = SchemeGroupVersion.Group + "/user-identity"
+	// AnnotationUserGroups sits in hub deployables, giving the comma separated groups of AnnotationUserIdentity.
+	AnnotationUserGroups = SchemeGroupVersion.Group + "/user-groups"
 	// LabelSubscriptionPause sits in deployable label to identify if the deployable is paused.
 	LabelSubscriptionPause = "subscription-pause"
+	// LabelSuspendDeployables sits in namespace label to suspend all deployables in the namespace.
+	LabelSuspendDeployables = SchemeGroupVersion.Group + "/suspend-deployables"
 )
 
 const (
@@ -52,6 +87,179 @@ const (
 	DefaultRollingUpdateMaxUnavailablePercentage = 25
 )
 
+// RollingUpdateFailurePolicy tells the controller what to do when a rolling update exceeds its failure budget.
+type RollingUpdateFailurePolicy string
+
+const (
+	// RollingUpdateFailurePolicyHalt stops rolling more clusters and leaves the updated ones as they are.
+	RollingUpdateFailurePolicyHalt RollingUpdateFailurePolicy = "Halt"
+	// RollingUpdateFailurePolicyRollback restores the template and overrides from before the rolling update.
+	RollingUpdateFailurePolicyRollback RollingUpdateFailurePolicy = "Rollback"
+)
+
+// DriftPolicy tells the controller what to do with child deployables edited out of band.
+type DriftPolicy string
+
+const (
+	// DriftPolicyCorrect restores the rendered template in the drifted children.
+	DriftPolicyCorrect DriftPolicy = "Correct"
+	// DriftPolicyReport leaves the drifted children as they are until the hub template changes, and only reports them.
+	DriftPolicyReport DriftPolicy = "Report"
+)
+
+// PropagationBackend is how a hub deployable is propagated to its clusters.
+type PropagationBackend string
+
+const (
+	// PropagationBackendDeployable creates a child deployable in each cluster namespace.
+	PropagationBackendDeployable PropagationBackend = "Deployable"
+	// PropagationBackendManifestWork creates a ManifestWork with the rendered template in each cluster namespace, for the work agent.
+	PropagationBackendManifestWork PropagationBackend = "ManifestWork"
+	// PropagationBackendLocal applies the rendered template directly to the hub, for the cluster labeled local-cluster=true.
+	PropagationBackendLocal PropagationBackend = "Local"
+	// PropagationBackendFile writes the rendered child deployable of each cluster to a file under the --file-sink-dir of the manager.
+	PropagationBackendFile PropagationBackend = "File"
+)
+
+// LocalClusterNamingPolicy is how the template is renamed for the clusters labeled local-cluster=true, which share
+// the hub with the deployable.
+type LocalClusterNamingPolicy string
+
+const (
+	// LocalClusterNamingSuffix appends the value, -local if not set, to the name.
+	LocalClusterNamingSuffix LocalClusterNamingPolicy = "Suffix"
+	// LocalClusterNamingPrefix prepends the value, local- if not set, to the name.
+	LocalClusterNamingPrefix LocalClusterNamingPolicy = "Prefix"
+	// LocalClusterNamingHash appends a short hash of the cluster name to the name.
+	LocalClusterNamingHash LocalClusterNamingPolicy = "Hash"
+	// LocalClusterNamingNone leaves the name alone.
+	LocalClusterNamingNone LocalClusterNamingPolicy = "None"
+)
+
+// LocalClusterNaming renames the template for the local cluster, to avoid name collisions on the hub.
+type LocalClusterNaming struct {
+	// Policy is how the name is changed. Suffix if not set.
+	//+kubebuilder:validation:Enum=Suffix;Prefix;Hash;None
+	Policy LocalClusterNamingPolicy `json:"policy,omitempty"`
+	// Value is the suffix or the prefix of the name.
+	Value string `json:"value,omitempty"`
+	// Kinds limits the renaming to the templates of these kinds, or to the items of these kinds of a List template.
+	// Every kind is renamed if empty.
+	Kinds []string `json:"kinds,omitempty"`
+}
+
+const (
+	// ConditionProgressing reports the progress of the latest rolling update.
+	ConditionProgressing = "Progressing"
+
+	// ReasonRollingUpdateProgressing means the rolling update is still updating clusters.
+	ReasonRollingUpdateProgressing = "RollingUpdateProgressing"
+	// ReasonRollingUpdateCompleted means all clusters are updated and deployed.
+	ReasonRollingUpdateCompleted = "RollingUpdateCompleted"
+	// ReasonRollingUpdateHalted means the failure budget is exceeded and no more clusters are updated.
+	ReasonRollingUpdateHalted = "FailureBudgetExceeded"
+	// ReasonRollingUpdateRolledBack means the failure budget is exceeded and the previous template is restored.
+	ReasonRollingUpdateRolledBack = "RolledBack"
+
+	// ConditionInvalidFailureBudget reports a rollingupdate-maxfailure annotation that is rejected, the rolling update
+	// then has no failure budget.
+	ConditionInvalidFailureBudget = "InvalidFailureBudget"
+
+	// ReasonFailureBudgetRejected means the failure budget is not a count or a percentage between 0 and 100.
+	ReasonFailureBudgetRejected = "FailureBudgetRejected"
+
+	// ConditionWaitingForApproval reports a rollout stage held until it is approved.
+	ConditionWaitingForApproval = "WaitingForApproval"
+
+	// ReasonApprovalRequired means a rollout stage is waiting for approval.
+	ReasonApprovalRequired = "ApprovalRequired"
+	// ReasonApproved means the rollout stage waiting for approval is approved.
+	ReasonApproved = "Approved"
+
+	// ConditionSuspended reports if propagation, rollout and cleanup are suspended.
+	ConditionSuspended = "Suspended"
+
+	// ReasonDeployableSuspended means spec.suspend is set on the deployable.
+	ReasonDeployableSuspended = "DeployableSuspended"
+	// ReasonNamespaceSuspended means the namespace of the deployable has the suspend-deployables label.
+	ReasonNamespaceSuspended = "NamespaceSuspended"
+	// ReasonResumed means the deployable is no longer suspended.
+	ReasonResumed = "Resumed"
+
+	// ConditionInvalidMaintenanceWindow reports a maintenance window that can not be parsed. The clusters it may apply to
+	// are deferred until it is fixed.
+	ConditionInvalidMaintenanceWindow = "InvalidMaintenanceWindow"
+
+	// ReasonMaintenanceWindowRejected means the schedule, time zone or cluster selector of a maintenance window is invalid.
+	ReasonMaintenanceWindowRejected = "MaintenanceWindowRejected"
+
+	// ConditionBackendHandover reports the clusters that keep the units of the previous propagation backend until the
+	// unit of the new backend is available.
+	ConditionBackendHandover = "BackendHandover"
+
+	// ReasonWaitingForAvailable means the units of the new backend are not available yet in some clusters.
+	ReasonWaitingForAvailable = "WaitingForAvailable"
+
+	// ConditionDrifted reports, in the status of a target cluster, if its child deployable was edited out of band.
+	ConditionDrifted = "Drifted"
+
+	// ReasonDriftDetected means the child deployable no longer matches the template rendered for the cluster.
+	ReasonDriftDetected = "DriftDetected"
+	// ReasonDriftCorrected means the rendered template was restored in the drifted child deployable.
+	ReasonDriftCorrected = "DriftCorrected"
+
+	// ReasonChannelNotFound means the channel of spec.channels does not exist.
+	ReasonChannelNotFound = "ChannelNotFound"
+	// ReasonChannelTypeUnsupported means the channel is not a Namespace channel, deployables are not published into it.
+	ReasonChannelTypeUnsupported = "ChannelTypeUnsupported"
+	// ReasonSourceNamespaceNotAllowed means the namespace of the deployable is not a source namespace of the channel.
+	ReasonSourceNamespaceNotAllowed = "SourceNamespaceNotAllowed"
+	// ReasonGateNotPassed means the deployable does not have the annotations or labels the gates of the channel require.
+	ReasonGateNotPassed = "GateNotPassed"
+)
+
+// Reasons of the events recorded on hub deployables by the deployable controller.
+const (
+	// EventReasonPropagated means children were created or updated in target clusters.
+	EventReasonPropagated = "Propagated"
+	// EventReasonPropagationFailed means children failed to be created or updated in target clusters.
+	EventReasonPropagationFailed = "PropagationFailed"
+	// EventReasonPropagationDenied means the user of the deployable may not create the template in target clusters.
+	EventReasonPropagationDenied = "PropagationDenied"
+	// EventReasonChildDeleted means children were deleted from clusters no longer targeted.
+	EventReasonChildDeleted = "ChildDeleted"
+	// EventReasonChildDeleteFailed means children failed to be deleted from clusters no longer targeted.
+	EventReasonChildDeleteFailed = "ChildDeleteFailed"
+	// EventReasonRolloutStarted means a rolling update to a new template started.
+	EventReasonRolloutStarted = "RolloutStarted"
+	// EventReasonRolloutStageStarted means a rollout stage started to update its clusters.
+	EventReasonRolloutStageStarted = "RolloutStageStarted"
+	// EventReasonRolloutStageApproved means a rollout stage waiting for approval was approved.
+	EventReasonRolloutStageApproved = "RolloutStageApproved"
+	// EventReasonRolloutStageCompleted means all clusters of a rollout stage are deployed and baked.
+	EventReasonRolloutStageCompleted = "RolloutStageCompleted"
+	// EventReasonRolloutCompleted means all clusters are updated and deployed.
+	EventReasonRolloutCompleted = "RolloutCompleted"
+	// EventReasonRolloutHalted means the rolling update exceeded its failure budget and stopped.
+	EventReasonRolloutHalted = "RolloutHalted"
+	// EventReasonRolloutRolledBack means the rolling update exceeded its failure budget and the previous template was restored.
+	EventReasonRolloutRolledBack = "RolloutRolledBack"
+	// EventReasonSuspended means propagation, rolling update and cleanup were suspended.
+	EventReasonSuspended = "Suspended"
+	// EventReasonResumed means the deployable is no longer suspended.
+	EventReasonResumed = "Resumed"
+	// EventReasonDriftDetected means children were edited out of band.
+	EventReasonDriftDetected = "DriftDetected"
+	// EventReasonDriftCorrected means the rendered template was restored in drifted children.
+	EventReasonDriftCorrected = "DriftCorrected"
+	// EventReasonPublished means the deployable was published into a channel.
+	EventReasonPublished = "Published"
+	// EventReasonPublishFailed means the deployable failed to be published into or removed from a channel.
+	EventReasonPublishFailed = "PublishFailed"
+	// EventReasonUnpublished means the deployable was removed from a channel it is no longer published into.
+	EventReasonUnpublished = "Unpublished"
+)
+
 var (
 	// PropertyHostingDeployable tells NamespacedName of the hosting deployable of the dependency.
 	PropertyHostingDeployable = "hosting-deployable"
@@ -77,11 +285,91 @@ type ClusterOverride struct {
 	runtime.RawExtension `json:",inline"`
 }
 
+// KustomizeImage changes the name, tag or digest of the images named Name, like the images of a kustomization.
+type KustomizeImage struct {
+	Name    string `json:"name"`
+	NewName string `json:"newName,omitempty"`
+	NewTag  string `json:"newTag,omitempty"`
+	Digest  string `json:"digest,omitempty"`
+}
+
+// KustomizePatchTarget selects the resources a patch applies to, like the target of a kustomization patch.
+type KustomizePatchTarget struct {
+	Group              string `json:"group,omitempty"`
+	Version            string `json:"version,omitempty"`
+	Kind               string `json:"kind,omitempty"`
+	Name               string `json:"name,omitempty"`
+	Namespace          string `json:"namespace,omitempty"`
+	LabelSelector      string `json:"labelSelector,omitempty"`
+	AnnotationSelector string `json:"annotationSelector,omitempty"`
+}
+
+// KustomizePatch is a strategic merge patch or a JSON 6902 patch. A patch without target applies to the resources it names.
+type KustomizePatch struct {
+	Patch  string                `json:"patch"`
+	Target *KustomizePatchTarget `json:"target,omitempty"`
+}
+
+// KustomizeOverlay is a kustomize overlay applied to the rendered template, with the fields of a kustomization.
+type KustomizeOverlay struct {
+	NamePrefix string           `json:"namePrefix,omitempty"`
+	NameSuffix string           `json:"nameSuffix,omitempty"`
+	Patches    []KustomizePatch `json:"patches,omitempty"`
+	Images     []KustomizeImage `json:"images,omitempty"`
+}
+
 // Overrides field in deployable.
 type Overrides struct {
-	ClusterName string `json:"clusterName"`
+	// ClusterName is the cluster the overrides apply to, required unless ClusterSelector is set.
+	ClusterName string `json:"clusterName,omitempty"`
+	// ClusterSelector applies the cluster overrides and the kustomize overlay to a group of clusters instead of the
+	// cluster named ClusterName. The cluster overrides of the named cluster are applied after those of its groups.
+	ClusterSelector *metav1.LabelSelector `json:"clusterSelector,omitempty"`
 	//+kubebuilder:validation:MinItems=1
-	ClusterOverrides []ClusterOverride `json:"clusterOverrides"` // To be added
+	ClusterOverrides []ClusterOverride `json:"clusterOverrides,omitempty"` // To be added
+	// Kustomize is applied to the template rendered for the clusters, after the cluster overrides.
+	Kustomize *KustomizeOverlay `json:"kustomize,omitempty"`
+}
+
+// RolloutStage is a group of clusters rolled together in a rolling update.
+type RolloutStage struct {
+	Name string `json:"name"`
+	// ClusterSelector picks the stage clusters among those not taken by earlier stages. Empty selects all of them.
+	ClusterSelector *metav1.LabelSelector `json:"clusterSelector,omitempty"`
+	// Percentage of the selected clusters included in the stage, 100 by default.
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:validation:Maximum=100
+	Percentage *int `json:"percentage,omitempty"`
+	// MaxUnavailable is the percentage of the stage clusters updated at a time.
+	// The rollingupdate-maxunavaialble annotation is used if not set.
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:validation:Maximum=100
+	MaxUnavailable *int `json:"maxUnavailable,omitempty"`
+	// BakeTime is how long to wait after all stage clusters are deployed before the next stage starts.
+	BakeTime *metav1.Duration `json:"bakeTime,omitempty"`
+	// RequireApproval holds the stage until it is approved.
+	RequireApproval bool `json:"requireApproval,omitempty"`
+}
+
+// RollingUpdate defines how a rolling update moves through the target clusters.
+type RollingUpdate struct {
+	// Stages are rolled in order. Clusters not taken by any stage are rolled last.
+	Stages []RolloutStage `json:"stages,omitempty"`
+	// RequireApproval holds the clusters not taken by any stage until they are approved.
+	// Without stages, it holds the whole rolling update.
+	RequireApproval bool `json:"requireApproval,omitempty"`
+}
+
+// MaintenanceWindow is a recurring time range in which children may be created or updated in the selected clusters.
+type MaintenanceWindow struct {
+	// ClusterSelector picks the clusters the window applies to. Empty applies to all clusters.
+	ClusterSelector *metav1.LabelSelector `json:"clusterSelector,omitempty"`
+	// Schedule is a cron expression for the start of the window, like "0 22 * * 6".
+	Schedule string `json:"schedule"`
+	// Duration is how long the window stays open.
+	Duration metav1.Duration `json:"duration"`
+	// TimeZone of the schedule, like "Europe/Paris". UTC if not set.
+	TimeZone string `json:"timeZone,omitempty"`
 }
 
 // DeployableSpec defines the desired state of Deployable.
@@ -93,9 +381,43 @@ type DeployableSpec struct {
 	Dependencies []Dependency                 `json:"dependencies,omitempty"`
 	Placement    *placementv1alpha1.Placement `json:"placement,omitempty"`
 	Overrides    []Overrides                  `json:"overrides,omitempty"`
-	Channels     []string                     `json:"channels,omitempty"`
+	// Channels publishes the deployable into the namespace of each channel, as namespace/name or as the name of a channel
+	// in the namespace of the deployable, when it passes the gates of the channel.
+	Channels      []string       `json:"channels,omitempty"`
+	RollingUpdate *RollingUpdate `json:"rollingUpdate,omitempty"`
+	// Suspend freezes propagation, rolling update and cleanup of children. Status is still refreshed.
+	Suspend bool `json:"suspend,omitempty"`
+	// MaintenanceWindows restrict when children may be created or updated. A cluster selected by any window
+	// is only changed while one of its windows is open. Clusters selected by no window are not restricted.
+	MaintenanceWindows []MaintenanceWindow `json:"maintenanceWindows,omitempty"`
+	// ClusterStatus tells how the status of the target clusters is kept in the hub deployable. Full if not set.
+	//+kubebuilder:validation:Enum=Full;Summary
+	ClusterStatus ClusterStatusMode `json:"clusterStatus,omitempty"`
+	// DriftPolicy tells what to do with child deployables edited out of band. Correct if not set.
+	//+kubebuilder:validation:Enum=Correct;Report
+	DriftPolicy DriftPolicy `json:"driftPolicy,omitempty"`
+	// DryRun computes what the next propagation would do into status.plan, without creating, updating or deleting any child.
+	DryRun bool `json:"dryRun,omitempty"`
+	// Backend tells how the deployable is propagated to its clusters. The --default-backend of the manager if not set.
+	// Dependencies are only propagated by the Deployable backend.
+	//+kubebuilder:validation:Enum=Deployable;ManifestWork;Local;File
+	Backend PropagationBackend `json:"backend,omitempty"`
+	// LocalClusterNaming renames the template for the local cluster. The --local-cluster-naming of the manager if not set.
+	// The dependencies without their own policy are renamed like the deployable.
+	LocalClusterNaming *LocalClusterNaming `json:"localClusterNaming,omitempty"`
 }
 
+// ClusterStatusMode tells how the status of the target clusters is kept in the hub deployable.
+type ClusterStatusMode string
+
+const (
+	// ClusterStatusModeFull keeps the status of every target cluster in status.targetClusters.
+	ClusterStatusModeFull ClusterStatusMode = "Full"
+	// ClusterStatusModeSummary keeps the counts of the target clusters by phase and the failing clusters only.
+	// The full status of a cluster stays in its child deployable, in the cluster namespace.
+	ClusterStatusModeSummary ClusterStatusMode = "Summary"
+)
+
 // DeployablePhase indicate the phase of a deployable.
 type DeployablePhase string
 
@@ -118,6 +440,117 @@ type ResourceUnitStatus struct {
 	LastUpdateTime *metav1.Time    `json:"lastUpdateTime,omitempty"`
 
 	ResourceStatus *runtime.RawExtension `json:"resourceStatus,omitempty"`
+	Conditions     []metav1.Condition    `json:"conditions,omitempty"`
+	// DeferredUntil tells when the next maintenance window opens, if changes to the cluster are on hold.
+	DeferredUntil *metav1.Time `json:"deferredUntil,omitempty"`
+}
+
+// RolloutStagePhase indicate the phase of a rollout stage.
+type RolloutStagePhase string
+
+const (
+	// RolloutStagePending means an earlier stage is not completed yet.
+	RolloutStagePending RolloutStagePhase = "Pending"
+	// RolloutStageWaitingForApproval means the stage is held until it is approved.
+	RolloutStageWaitingForApproval RolloutStagePhase = "WaitingForApproval"
+	// RolloutStageProgressing means the stage clusters are being updated.
+	RolloutStageProgressing RolloutStagePhase = "Progressing"
+	// RolloutStageBaking means all stage clusters are deployed and the bake time is not over yet.
+	RolloutStageBaking RolloutStagePhase = "Baking"
+	// RolloutStageCompleted means the stage is done.
+	RolloutStageCompleted RolloutStagePhase = "Completed"
+)
+
+// RolloutStageStatus reports the progress of a rollout stage.
+type RolloutStageStatus struct {
+	Name            string            `json:"name"`
+	Phase           RolloutStagePhase `json:"phase,omitempty"`
+	Clusters        []string          `json:"clusters,omitempty"`
+	UpdatedClusters int               `json:"updatedClusters,omitempty"`
+	StartTime       *metav1.Time      `json:"startTime,omitempty"`
+	DeployedTime    *metav1.Time      `json:"deployedTime,omitempty"`
+	CompletionTime  *metav1.Time      `json:"completionTime,omitempty"`
+}
+
+// RolloutApproval records who approved a rollout stage and when.
+type RolloutApproval struct {
+	Stage        string       `json:"stage"`
+	Approver     string       `json:"approver"`
+	ApprovedTime *metav1.Time `json:"approvedTime,omitempty"`
+}
+
+// RollingUpdateStatus records the latest rolling update of a deployable.
+type RollingUpdateStatus struct {
+	Target    string       `json:"target,omitempty"`
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// PreviousTemplate and PreviousOverrides are restored when the rolling update is rolled back.
+	PreviousTemplate  *runtime.RawExtension `json:"previousTemplate,omitempty"`
+	PreviousOverrides []Overrides           `json:"previousOverrides,omitempty"`
+	FailedClusters    []string              `json:"failedClusters,omitempty"`
+	Stages            []RolloutStageStatus  `json:"stages,omitempty"`
+	Approvals         []RolloutApproval     `json:"approvals,omitempty"`
+	// SuspendedTime is when the deployable was suspended during the rolling update, cleared when it is resumed.
+	SuspendedTime *metav1.Time `json:"suspendedTime,omitempty"`
+	// ResumedTime is when the rolling update was last resumed, the progress deadline of the clusters restarts then.
+	ResumedTime *metav1.Time `json:"resumedTime,omitempty"`
+}
+
+// DeployablePlan is what the next propagation of a deployable in dry run would do.
+type DeployablePlan struct {
+	// ObservedGeneration is the generation of the deployable the plan was computed for.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Clusters are the clusters selected by the placement.
+	Clusters []string `json:"clusters,omitempty"`
+	Create   []string `json:"create,omitempty"`
+	Update   []string `json:"update,omitempty"`
+	Delete   []string `json:"delete,omitempty"`
+	// Deferred are the clusters whose changes wait for a maintenance window.
+	Deferred []string `json:"deferred,omitempty"`
+	// RolloutBatch are the clusters moved to the rolling update target by the next propagation.
+	RolloutBatch []string `json:"rolloutBatch,omitempty"`
+}
+
+// ClusterStatusSummary counts the target clusters by phase.
+type ClusterStatusSummary struct {
+	Total    int `json:"total"`
+	Deployed int `json:"deployed,omitempty"`
+	Failed   int `json:"failed,omitempty"`
+	// Pending counts the clusters neither deployed nor failed yet.
+	Pending int `json:"pending,omitempty"`
+	// Deferred counts the clusters whose changes wait for a maintenance window, whatever their phase.
+	Deferred int `json:"deferred,omitempty"`
+	// Drifted counts the clusters whose child deployable was edited out of band, whatever their phase.
+	Drifted int `json:"drifted,omitempty"`
+}
+
+// ChannelPhase is the phase of the publication of a deployable into a channel.
+type ChannelPhase string
+
+const (
+	// ChannelPublished means the deployable is published in the channel namespace.
+	ChannelPublished ChannelPhase = "Published"
+	// ChannelGated means the deployable does not pass the gates of the channel yet, it is not published.
+	ChannelGated ChannelPhase = "Gated"
+	// ChannelFailed means the channel can not be published to.
+	ChannelFailed ChannelPhase = "Failed"
+)
+
+// ChannelStatus reports the publication of the deployable into a channel.
+type ChannelStatus struct {
+	Phase   ChannelPhase `json:"phase"`
+	Reason  string       `json:"reason,omitempty"`
+	Message string       `json:"message,omitempty"`
+	// Deployable is the NamespacedName of the deployable published in the channel namespace.
+	Deployable string `json:"deployable,omitempty"`
+}
+
+// ChartStatus tells the Helm chart a HelmChart template was rendered from.
+type ChartStatus struct {
+	Name    string `json:"name,omitempty"`
+	Version string `json:"version,omitempty"`
+	// RenderedHash is the hash of the chart rendered with the values of the template, before the cluster overrides.
+	// The hash rendered for each cluster is the template-hash annotation of its child.
+	RenderedHash string `json:"renderedHash,omitempty"`
 }
 
 // DeployableStatus defines the observed state of Deployable.
@@ -126,6 +559,16 @@ type DeployableStatus struct {
 	// Important: Run "make" to regenerate code after modifying this file
 	ResourceUnitStatus `json:",inline"`
 	PropagatedStatus   map[string]*ResourceUnitStatus `json:"targetClusters,omitempty"`
+	// ClusterSummary is set in place of the status of every target cluster when spec.clusterStatus is Summary.
+	// targetClusters then only has the first failing clusters, without their resource status.
+	ClusterSummary *ClusterStatusSummary `json:"clusterSummary,omitempty"`
+	RollingUpdate  *RollingUpdateStatus  `json:"rollingUpdate,omitempty"`
+	// Plan is set when spec.dryRun is set.
+	Plan *DeployablePlan `json:"plan,omitempty"`
+	// Chart is set when the template is a HelmChart.
+	Chart *ChartStatus `json:"chart,omitempty"`
+	// Channels reports the publication into each channel of spec.channels, by channel NamespacedName.
+	Channels map[string]*ChannelStatus `json:"channels,omitempty"`
 }
 
 // +genclient
@@ -0,0 +1,268 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployable
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	authv1 "k8s.io/api/authorization/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+	"github.com/stolostron/multicloud-operators-deployable/pkg/utils"
+)
+
+const (
+	// ReasonPropagationDenied is the propagated status reason of a cluster the user of the deployable may not propagate to.
+	ReasonPropagationDenied = "PropagationDenied"
+	// ReasonKindNotAllowed is the propagated status reason of a cluster whose rendered template has a kind the template
+	// kinds policy does not allow.
+	ReasonKindNotAllowed = "KindNotAllowed"
+)
+
+// authorizePropagation checks the user of the hub deployables may create their template in the cluster namespaces.
+var authorizePropagation = false
+
+// SetAuthorizePropagation turns on or off the SubjectAccessReview of the user of the hub deployables before propagating.
+// The user that last changed what they propagate is recorded by the admission webhook.
+func SetAuthorizePropagation(enabled bool) {
+	authorizePropagation = enabled
+}
+
+// reviewCluster checks the resources of the template rendered for the cluster, and of its dependencies, against the
+// template kinds policy, and reviews if the user of the deployable may create them. It returns the reason and the
+// message of the denial, empty if the deployable may be propagated to the cluster.
+func (r *ReconcileDeployable) reviewCluster(ctx context.Context, cluster types.NamespacedName,
+	instance, rendered *appv1alpha1.Deployable) (string, string, error) {
+	policy, err := utils.GetTemplateKindsPolicy(ctx, r.policyReader())
+	if err != nil {
+		return "", "", err
+	}
+
+	if policy.IsEmpty() && !authorizePropagation {
+		return "", "", nil
+	}
+
+	objs, err := r.renderedObjects(ctx, cluster, instance, rendered, sets.NewString())
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := policy.Check(instance.GetNamespace(), objs); err != nil {
+		return ReasonKindNotAllowed, "The template has a " + err.Error(), nil
+	}
+
+	if !authorizePropagation {
+		return "", "", nil
+	}
+
+	msg, err := r.authorizeObjects(ctx, cluster, instance, objs)
+	if msg == "" || err != nil {
+		return "", "", err
+	}
+
+	return ReasonPropagationDenied, msg, nil
+}
+
+// renderedObjects returns the resources of the template rendered for the cluster, and those of the dependencies
+// propagated with it.
+func (r *ReconcileDeployable) renderedObjects(ctx context.Context, cluster types.NamespacedName, instance, rendered *appv1alpha1.Deployable,
+	seen sets.String) ([]*unstructured.Unstructured, error) {
+	objs, err := utils.TemplateObjects(rendered.Spec.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	// only the Deployable backend propagates the dependencies
+	if backendFor(instance) != appv1alpha1.PropagationBackendDeployable {
+		return objs, nil
+	}
+
+	for _, dependency := range instance.Spec.Dependencies {
+		if dependency.Kind != instance.Kind && dependency.Kind != "" {
+			continue
+		}
+
+		key := types.NamespacedName{Name: dependency.Name, Namespace: dependency.Namespace}
+		if key.Namespace == "" {
+			key.Namespace = instance.GetNamespace()
+		}
+
+		if seen.Has(key.String()) {
+			continue
+		}
+
+		seen.Insert(key.String())
+
+		depobj := &appv1alpha1.Deployable{}
+		if err := r.Get(ctx, key, depobj); err != nil {
+			return nil, err
+		}
+
+		// a dependency that does not render is not propagated
+		deprendered, err := r.renderLocalDeployable(ctx, cluster, types.NamespacedName{Name: instance.GetName(), Namespace: instance.GetNamespace()}, depobj)
+		if err != nil {
+			continue
+		}
+
+		depobjs, err := r.renderedObjects(ctx, cluster, depobj, deprendered, seen)
+		if err != nil {
+			return nil, err
+		}
+
+		objs = append(objs, depobjs...)
+	}
+
+	return objs, nil
+}
+
+// recordedUser is a user recorded in a deployable by the admission webhook, and its groups.
+type recordedUser struct {
+	name   string
+	groups []string
+}
+
+// recordedUsers returns the user of the deployable and, while it rolls out to a target, the user of the target whose
+// template it takes, or why they can not be reviewed.
+func (r *ReconcileDeployable) recordedUsers(ctx context.Context, instance *appv1alpha1.Deployable) ([]recordedUser, string, error) {
+	user, groups := utils.GetRecordedUser(instance)
+	if user == "" {
+		return nil, "The user of the deployable is not recorded, update the deployable to record it", nil
+	}
+
+	users := []recordedUser{{name: user, groups: groups}}
+
+	target := instance.GetAnnotations()[appv1alpha1.AnnotationRollingUpdateTarget]
+	if target == "" {
+		return users, "", nil
+	}
+
+	targetdpl := &appv1alpha1.Deployable{}
+	if err := r.Get(ctx, types.NamespacedName{Name: target, Namespace: instance.GetNamespace()}, targetdpl); err != nil {
+		if kerrors.IsNotFound(err) {
+			return users, "", nil
+		}
+
+		return nil, "", err
+	}
+
+	user, groups = utils.GetRecordedUser(targetdpl)
+	if user == "" {
+		return nil, "The user of the rolling update target " + target + " is not recorded, update the target to record it", nil
+	}
+
+	return append(users, recordedUser{name: user, groups: groups}), "", nil
+}
+
+// authorizeObjects reviews if the users of the deployable may create each resource in the cluster namespace,
+// and returns why not, empty if they may.
+func (r *ReconcileDeployable) authorizeObjects(ctx context.Context, cluster types.NamespacedName, instance *appv1alpha1.Deployable,
+	objs []*unstructured.Unstructured) (string, error) {
+	users, msg, err := r.recordedUsers(ctx, instance)
+	if msg != "" || err != nil {
+		return msg, err
+	}
+
+	for _, user := range users {
+		for _, obj := range objs {
+			gvk := obj.GroupVersionKind()
+			resource, namespaced := r.resourceFor(gvk)
+
+			attributes := &authv1.ResourceAttributes{Verb: "create", Group: gvk.Group, Version: gvk.Version, Resource: resource.Resource}
+			if namespaced {
+				attributes.Namespace = cluster.Namespace
+			}
+
+			review := &authv1.SubjectAccessReview{Spec: authv1.SubjectAccessReviewSpec{
+				User:               user.name,
+				Groups:             user.groups,
+				ResourceAttributes: attributes,
+			}}
+
+			review, err := r.authClient.AuthorizationV1().SubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+			if err != nil {
+				return "", err
+			}
+
+			logf.FromContext(ctx).V(logLevelTrace).Info("Reviewed user access", "user", user.name, "resource", resource.GroupResource().String(),
+				"allowed", review.Status.Allowed)
+
+			if !review.Status.Allowed {
+				msg := fmt.Sprintf("User %s may not create %s in namespace %s", user.name, resource.GroupResource(), cluster.Namespace)
+				if !namespaced {
+					msg = fmt.Sprintf("User %s may not create %s", user.name, resource.GroupResource())
+				}
+
+				if review.Status.Reason != "" {
+					msg += ": " + review.Status.Reason
+				}
+
+				return msg, nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// policyReader reads the ConfigMap of the template kinds policy, uncached when the manager provides a reader.
+func (r *ReconcileDeployable) policyReader() client.Reader {
+	if r.apiReader != nil {
+		return r.apiReader
+	}
+
+	return r.Client
+}
+
+// resourceFor maps the kind to its resource with the RESTMapper of the hub, or guesses a namespaced resource
+// for the kinds only known to the managed clusters.
+func (r *ReconcileDeployable) resourceFor(gvk schema.GroupVersionKind) (schema.GroupVersionResource, bool) {
+	if mapper := r.RESTMapper(); mapper != nil {
+		if mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version); err == nil {
+			return mapping.Resource, mapping.Scope.Name() != apimeta.RESTScopeNameRoot
+		}
+	}
+
+	resource, _ := apimeta.UnsafeGuessKindToResource(gvk)
+
+	return resource, true
+}
+
+// denyCluster marks the cluster as denied in the status of the hub deployable.
+func (r *ReconcileDeployable) denyCluster(ctx context.Context, cluster types.NamespacedName, instance *appv1alpha1.Deployable, reason, msg string) {
+	logf.FromContext(ctx).Info("Denied propagation to cluster", "reason", reason, "message", msg)
+
+	if instance.Status.PropagatedStatus == nil {
+		instance.Status.PropagatedStatus = make(map[string]*appv1alpha1.ResourceUnitStatus)
+	}
+
+	instance.Status.PropagatedStatus[cluster.Name] = &appv1alpha1.ResourceUnitStatus{
+		Phase:   appv1alpha1.DeployableFailed,
+		Reason:  reason,
+		Message: msg,
+	}
+
+	r.recordClusterEvent(ctx, instance, appv1alpha1.EventReasonPropagationDenied, cluster.Name, errors.New(msg))
+}
@@ -0,0 +1,350 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployable
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+	"github.com/stolostron/multicloud-operators-deployable/pkg/utils"
+)
+
+// propagationBackend propagates the template of a hub deployable, rendered once for each cluster by renderLocalDeployable,
+// to the managed clusters. The units are the objects the backend keeps on the hub for the clusters, like child deployables.
+type propagationBackend interface {
+	// list returns the units propagated for the hub deployable.
+	list(ctx context.Context, instance *appv1alpha1.Deployable) ([]client.Object, error)
+	// pending tells if the units of the cluster differ from the child deployable rendered for the cluster.
+	pending(ctx context.Context, cluster types.NamespacedName, instance, rendered *appv1alpha1.Deployable, units []client.Object) bool
+	// apply creates or updates the units of the cluster from the rendered child deployable, and returns the existing units it keeps.
+	apply(ctx context.Context, cluster types.NamespacedName, instance, rendered *appv1alpha1.Deployable, units []client.Object) ([]client.Object, error)
+	// delete deletes a unit of a cluster no longer targeted.
+	delete(ctx context.Context, instance *appv1alpha1.Deployable, unit client.Object) error
+	// status reads the cluster status from a unit, nil if the unit does not report one.
+	status(unit client.Object) *appv1alpha1.ResourceUnitStatus
+}
+
+// backends builds the propagation backends of a reconciler by name.
+var backends = map[appv1alpha1.PropagationBackend]func(r *ReconcileDeployable) propagationBackend{
+	appv1alpha1.PropagationBackendDeployable:   func(r *ReconcileDeployable) propagationBackend { return &deployableBackend{r} },
+	appv1alpha1.PropagationBackendManifestWork: func(r *ReconcileDeployable) propagationBackend { return &manifestWorkBackend{r} },
+	appv1alpha1.PropagationBackendLocal:        func(r *ReconcileDeployable) propagationBackend { return &localBackend{r} },
+	appv1alpha1.PropagationBackendFile:         func(r *ReconcileDeployable) propagationBackend { return &fileBackend{r} },
+}
+
+// defaultBackend propagates the hub deployables that do not set spec.backend.
+var defaultBackend = appv1alpha1.PropagationBackendDeployable
+
+// SetDefaultBackend sets the propagation backend of the hub deployables that do not set spec.backend.
+func SetDefaultBackend(name string) error {
+	backend := appv1alpha1.PropagationBackend(name)
+	if _, ok := backends[backend]; !ok {
+		return fmt.Errorf("unsupported propagation backend %q, expected one of %v", name, backendNames())
+	}
+
+	defaultBackend = backend
+
+	return nil
+}
+
+func backendNames() []string {
+	var names []string
+	for name := range backends {
+		names = append(names, string(name))
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// backendFor returns the name of the propagation backend of the hub deployable.
+func backendFor(instance *appv1alpha1.Deployable) appv1alpha1.PropagationBackend {
+	if _, ok := backends[instance.Spec.Backend]; ok {
+		return instance.Spec.Backend
+	}
+
+	return defaultBackend
+}
+
+// listUnits returns the units propagated for the hub deployable by each backend, so that switching the backend
+// deletes the units of the previous one.
+func (r *ReconcileDeployable) listUnits(ctx context.Context, instance *appv1alpha1.Deployable) (map[appv1alpha1.PropagationBackend][]client.Object, error) {
+	units := make(map[appv1alpha1.PropagationBackend][]client.Object)
+
+	for name, newBackend := range backends {
+		listed, err := newBackend(r).list(ctx, instance)
+		if err != nil {
+			return nil, err
+		}
+
+		units[name] = listed
+	}
+
+	return units, nil
+}
+
+// backendHandoverInterval is how often the clusters handing over to a new backend are checked again.
+const backendHandoverInterval = 10 * time.Second
+
+// handOverUnits returns the units of the other backends to delete. The units of a cluster targeted by the backend are
+// kept until the unit of the backend in the cluster is available, so that switching the backend does not remove the
+// resources of the cluster before the new backend deploys them. The child deployables handed over to ManifestWorks are also
+// kept until their resources are deployed marked to be left in place. The clusters left are reported in the BackendHandover condition.
+func handOverUnits(instance *appv1alpha1.Deployable, name appv1alpha1.PropagationBackend, backend propagationBackend,
+	clusters []types.NamespacedName, allunits map[appv1alpha1.PropagationBackend][]client.Object) map[appv1alpha1.PropagationBackend][]client.Object {
+	targeted := sets.NewString()
+	for _, cluster := range clusters {
+		targeted.Insert(cluster.Namespace)
+	}
+
+	available := sets.NewString()
+
+	for _, unit := range allunits[name] {
+		if status := backend.status(unit); status != nil && status.Phase == appv1alpha1.DeployableDeployed {
+			available.Insert(unit.GetNamespace())
+		}
+	}
+
+	expired := make(map[appv1alpha1.PropagationBackend][]client.Object)
+	waiting := sets.NewString()
+
+	for other, listed := range allunits {
+		if other == name {
+			continue
+		}
+
+		for _, unit := range listed {
+			if targeted.Has(unit.GetNamespace()) && (!available.Has(unit.GetNamespace()) ||
+				name == appv1alpha1.PropagationBackendManifestWork && !handedOver(unit)) {
+				waiting.Insert(unitCluster(unit))
+				continue
+			}
+
+			expired[other] = append(expired[other], unit)
+		}
+	}
+
+	if waiting.Len() == 0 {
+		meta.RemoveStatusCondition(&instance.Status.Conditions, appv1alpha1.ConditionBackendHandover)
+		return expired
+	}
+
+	meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+		Type:   appv1alpha1.ConditionBackendHandover,
+		Status: metav1.ConditionTrue,
+		Reason: appv1alpha1.ReasonWaitingForAvailable,
+		Message: fmt.Sprintf("%d clusters keep the units of the previous backend until the %s backend is available in them: %s",
+			waiting.Len(), name, strings.Join(waiting.List(), ", ")),
+		ObservedGeneration: instance.GetGeneration(),
+	})
+
+	return expired
+}
+
+// keepHandedOverResources marks the resources of the child deployables in the clusters targeted by the ManifestWork
+// backend with AnnotationDoNotDelete, so that the subscription agents leave them in place for the work agents to adopt
+// when the children are deleted. It returns the units with the marked children.
+func (r *ReconcileDeployable) keepHandedOverResources(ctx context.Context, clusters []types.NamespacedName, units []client.Object) []client.Object {
+	log := logf.FromContext(ctx)
+
+	targeted := sets.NewString()
+	for _, cluster := range clusters {
+		targeted.Insert(cluster.Namespace)
+	}
+
+	marked := make([]client.Object, 0, len(units))
+
+	for _, unit := range units {
+		dpl, ok := unit.(*appv1alpha1.Deployable)
+		if !ok || !targeted.Has(dpl.GetNamespace()) || dpl.GetAnnotations()[appv1alpha1.AnnotationHandedOver] != "" {
+			marked = append(marked, unit)
+			continue
+		}
+
+		tpl, err := utils.AnnotateTemplateObjects(dpl.Spec.Template, appv1alpha1.AnnotationDoNotDelete, "true")
+		if err != nil {
+			log.Error(err, "Failed to mark the resources of child, skipping", "child", dpl.GetNamespace()+"/"+dpl.GetName())
+			marked = append(marked, unit)
+
+			continue
+		}
+
+		child := dpl.DeepCopy()
+		child.Spec.Template = tpl
+
+		annotations := child.GetAnnotations()
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+
+		annotations[appv1alpha1.AnnotationHandedOver] = time.Now().UTC().Format(time.RFC3339)
+		annotations[appv1alpha1.AnnotationTemplateHash] = utils.TemplateHash(tpl)
+		child.SetAnnotations(annotations)
+
+		err = r.Update(ctx, child)
+		recordChildOperation(childOperationUpdate, err)
+
+		if err != nil {
+			log.Error(err, "Failed to mark the resources of child, skipping", "child", dpl.GetNamespace()+"/"+dpl.GetName())
+			marked = append(marked, unit)
+
+			continue
+		}
+
+		marked = append(marked, child)
+	}
+
+	return marked
+}
+
+// handedOver tells if the subscription agent deployed the resources of the child deployable marked to be left in place
+// since it was marked. The units of the other backends are handed over once deleted.
+func handedOver(unit client.Object) bool {
+	dpl, ok := unit.(*appv1alpha1.Deployable)
+	if !ok {
+		return true
+	}
+
+	since, err := time.Parse(time.RFC3339, dpl.GetAnnotations()[appv1alpha1.AnnotationHandedOver])
+	if err != nil {
+		return false
+	}
+
+	status := dpl.Status.ResourceUnitStatus
+
+	return status.Phase == appv1alpha1.DeployableDeployed && status.LastUpdateTime != nil && !status.LastUpdateTime.Time.Before(since)
+}
+
+// backendHandoverRequeueAfter returns when the clusters handing over to a new backend are checked again, never while
+// suspended.
+func backendHandoverRequeueAfter(instance *appv1alpha1.Deployable) time.Duration {
+	if meta.IsStatusConditionTrue(instance.Status.Conditions, appv1alpha1.ConditionBackendHandover) && !isSuspended(instance) {
+		return backendHandoverInterval
+	}
+
+	return 0
+}
+
+// unitCluster returns the name of the cluster of a unit, its namespace if it does not tell.
+func unitCluster(unit client.Object) string {
+	if cluster := utils.GetClusterFromResourceObject(unit); cluster != nil && cluster.Name != "" {
+		return cluster.Name
+	}
+
+	return unit.GetNamespace()
+}
+
+// unitsByNamespace groups the units by cluster namespace.
+func unitsByNamespace(units []client.Object) map[string][]client.Object {
+	grouped := make(map[string][]client.Object)
+	for _, unit := range units {
+		grouped[unit.GetNamespace()] = append(grouped[unit.GetNamespace()], unit)
+	}
+
+	return grouped
+}
+
+// deployableBackend creates a child deployable in each cluster namespace, with the children of the dependencies.
+type deployableBackend struct {
+	r *ReconcileDeployable
+}
+
+func (b *deployableBackend) list(ctx context.Context, instance *appv1alpha1.Deployable) ([]client.Object, error) {
+	children, err := b.r.getDeployableFamily(ctx, instance)
+	if err != nil {
+		return nil, err
+	}
+
+	var units []client.Object
+
+	for _, dpl := range children {
+		// instance itself does not expire anyway
+		if getDeployableTrueKey(dpl) != getDeployableTrueKey(instance) {
+			units = append(units, dpl)
+		}
+	}
+
+	return units, nil
+}
+
+func (b *deployableBackend) pending(ctx context.Context, cluster types.NamespacedName, instance, rendered *appv1alpha1.Deployable,
+	units []client.Object) bool {
+	truekey := types.NamespacedName{Name: instance.GetName() + "-", Namespace: cluster.Namespace}.String()
+
+	existing, ok := deployableFamilyMap(units)[truekey]
+	if !ok {
+		return true
+	}
+
+	desired := mergeLocalDeployable(existing, rendered)
+	applyDriftPolicy(instance, existing, desired)
+
+	return !utils.CompareDeployable(existing, desired)
+}
+
+func (b *deployableBackend) apply(ctx context.Context, cluster types.NamespacedName, instance, rendered *appv1alpha1.Deployable,
+	units []client.Object) ([]client.Object, error) {
+	// createManagedDeployable removes the children it keeps from the family map
+	familymap, err := b.r.createManagedDeployable(ctx, cluster, instance, rendered, deployableFamilyMap(units))
+	if err != nil {
+		return nil, err
+	}
+
+	var kept []client.Object
+
+	for _, unit := range units {
+		if _, ok := familymap[getDeployableTrueKey(unit.(*appv1alpha1.Deployable))]; !ok {
+			kept = append(kept, unit)
+		}
+	}
+
+	return kept, nil
+}
+
+func (b *deployableBackend) delete(ctx context.Context, instance *appv1alpha1.Deployable, unit client.Object) error {
+	err := b.r.Delete(ctx, unit)
+	recordChildOperation(childOperationDelete, err)
+	b.r.recordChildDeleted(ctx, instance, unit, err)
+
+	return err
+}
+
+func (b *deployableBackend) status(unit client.Object) *appv1alpha1.ResourceUnitStatus {
+	return unit.(*appv1alpha1.Deployable).Status.ResourceUnitStatus.DeepCopy()
+}
+
+// deployableFamilyMap indexes the child deployables by true key.
+func deployableFamilyMap(units []client.Object) map[string]*appv1alpha1.Deployable {
+	familymap := make(map[string]*appv1alpha1.Deployable)
+
+	for _, unit := range units {
+		dpl := unit.(*appv1alpha1.Deployable)
+		familymap[getDeployableTrueKey(dpl)] = dpl
+	}
+
+	return familymap
+}
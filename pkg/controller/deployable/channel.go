@@ -0,0 +1,350 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployable
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+	"github.com/stolostron/multicloud-operators-deployable/pkg/utils"
+)
+
+// channelGVK is the kind of the channels of spec.channels, read as unstructured objects.
+var channelGVK = schema.GroupVersionKind{Group: appv1alpha1.SchemeGroupVersion.Group, Version: "v1", Kind: "Channel"}
+
+const (
+	// channelTypeNamespace is the type of the channels whose namespace holds the published deployables.
+	channelTypeNamespace = "namespace"
+	// mapperTimeout bounds how long the channel mapper lists the deployables publishing into a channel.
+	mapperTimeout = 10 * time.Second
+)
+
+// channelKey returns the NamespacedName of a channel of spec.channels.
+func channelKey(instance *appv1alpha1.Deployable, ref string) types.NamespacedName {
+	if parts := strings.SplitN(ref, "/", 2); len(parts) == 2 {
+		return types.NamespacedName{Namespace: parts[0], Name: parts[1]}
+	}
+
+	return types.NamespacedName{Namespace: instance.GetNamespace(), Name: ref}
+}
+
+// publishChannels publishes the deployable into the channels of spec.channels it passes the gates of, removes it from
+// the other channels, and reports the publication into each channel in status.
+func (r *ReconcileDeployable) publishChannels(ctx context.Context, instance *appv1alpha1.Deployable) error {
+	published, err := r.listPublished(ctx, instance)
+	if err != nil {
+		return err
+	}
+
+	kept := make(map[string]bool)
+	statuses := make(map[string]*appv1alpha1.ChannelStatus)
+
+	// a deployable being deleted leaves all its channels
+	if len(instance.GetFinalizers()) == 0 {
+		for _, ref := range instance.Spec.Channels {
+			key := channelKey(instance, ref)
+			status, copykey := r.publishChannel(ctx, instance, key, published)
+
+			statuses[key.String()] = status
+			kept[copykey] = true
+		}
+	}
+
+	var lasterr error
+
+	for _, dpl := range published {
+		copykey := types.NamespacedName{Namespace: dpl.GetNamespace(), Name: dpl.GetName()}.String()
+		if kept[copykey] {
+			continue
+		}
+
+		err := r.Delete(ctx, dpl)
+		if errors.IsNotFound(err) {
+			err = nil
+		}
+
+		reason := appv1alpha1.EventReasonUnpublished
+		if err != nil {
+			reason = appv1alpha1.EventReasonPublishFailed
+			lasterr = err
+		}
+
+		r.eventRecorder.RecordEvent(instance, reason, "Removed from channel "+dpl.GetAnnotations()[appv1alpha1.AnnotationChannel], err)
+	}
+
+	instance.Status.Channels = statuses
+	if len(statuses) == 0 {
+		instance.Status.Channels = nil
+	}
+
+	return lasterr
+}
+
+// publishChannel publishes the deployable into the channel if it may be, and returns its status with the
+// NamespacedName of the published deployable, empty if it is not published.
+func (r *ReconcileDeployable) publishChannel(ctx context.Context, instance *appv1alpha1.Deployable, key types.NamespacedName,
+	published []*appv1alpha1.Deployable) (*appv1alpha1.ChannelStatus, string) {
+	log := logf.FromContext(ctx).WithValues("channel", key.String())
+
+	channel := &unstructured.Unstructured{}
+	channel.SetGroupVersionKind(channelGVK)
+
+	if err := r.Get(ctx, key, channel); err != nil {
+		if errors.IsNotFound(err) {
+			return &appv1alpha1.ChannelStatus{Phase: appv1alpha1.ChannelFailed, Reason: appv1alpha1.ReasonChannelNotFound,
+				Message: "channel " + key.String() + " not found"}, ""
+		}
+
+		return &appv1alpha1.ChannelStatus{Phase: appv1alpha1.ChannelFailed, Message: err.Error()}, ""
+	}
+
+	if status := checkChannel(instance, channel); status != nil {
+		return status, ""
+	}
+
+	copykey := channelDeployableKey(instance, channel)
+
+	desired, err := newChannelDeployable(ctx, instance, channel)
+	if err != nil {
+		log.Error(err, "Failed to render deployable published into channel")
+		r.eventRecorder.RecordEvent(instance, appv1alpha1.EventReasonPublishFailed, "Failed to publish into channel "+key.String(), err)
+
+		// keep a deployable published before rather than removing it from the channel
+		return &appv1alpha1.ChannelStatus{Phase: appv1alpha1.ChannelFailed, Message: err.Error()}, copykey.String()
+	}
+
+	var existing *appv1alpha1.Deployable
+
+	for _, dpl := range published {
+		if dpl.GetNamespace() == copykey.Namespace && dpl.GetName() == copykey.Name {
+			existing = dpl
+		}
+	}
+
+	switch {
+	case existing == nil:
+		log.Info("Publishing deployable into channel", "deployable", copykey.String())
+		err = r.Create(ctx, desired)
+	case !utils.CompareDeployable(existing, desired):
+		log.Info("Updating deployable published into channel", "deployable", copykey.String())
+		existing.SetLabels(desired.GetLabels())
+		existing.SetAnnotations(desired.GetAnnotations())
+		existing.Spec = desired.Spec
+		err = r.Update(ctx, existing)
+	default:
+		return &appv1alpha1.ChannelStatus{Phase: appv1alpha1.ChannelPublished, Deployable: copykey.String()}, copykey.String()
+	}
+
+	if err != nil {
+		log.Error(err, "Failed to publish deployable into channel")
+		r.eventRecorder.RecordEvent(instance, appv1alpha1.EventReasonPublishFailed, "Failed to publish into channel "+key.String(), err)
+
+		// keep a deployable published before rather than removing it from the channel
+		return &appv1alpha1.ChannelStatus{Phase: appv1alpha1.ChannelFailed, Message: err.Error()}, copykey.String()
+	}
+
+	r.eventRecorder.RecordEvent(instance, appv1alpha1.EventReasonPublished, "Published into channel "+key.String(), nil)
+
+	return &appv1alpha1.ChannelStatus{Phase: appv1alpha1.ChannelPublished, Deployable: copykey.String()}, copykey.String()
+}
+
+// checkChannel checks the type, the source namespaces and the gates of the channel, and returns the status of the
+// deployable in the channel if it may not be published into it.
+func checkChannel(instance *appv1alpha1.Deployable, channel *unstructured.Unstructured) *appv1alpha1.ChannelStatus {
+	chtype, _, _ := unstructured.NestedString(channel.Object, "spec", "type")
+	if !strings.EqualFold(chtype, channelTypeNamespace) {
+		return &appv1alpha1.ChannelStatus{Phase: appv1alpha1.ChannelFailed, Reason: appv1alpha1.ReasonChannelTypeUnsupported,
+			Message: fmt.Sprintf("deployables are only published into Namespace channels, not %s channels", chtype)}
+	}
+
+	// a channel without source namespaces only takes the deployables of its own namespace
+	sources, _, _ := unstructured.NestedStringSlice(channel.Object, "spec", "sourceNamespaces")
+	if len(sources) == 0 {
+		sources = []string{channel.GetNamespace()}
+	}
+
+	if !sets.NewString(sources...).Has(instance.GetNamespace()) {
+		return &appv1alpha1.ChannelStatus{Phase: appv1alpha1.ChannelFailed, Reason: appv1alpha1.ReasonSourceNamespaceNotAllowed,
+			Message: "namespace " + instance.GetNamespace() + " is not a source namespace of the channel"}
+	}
+
+	gates, _, _ := unstructured.NestedStringMap(channel.Object, "spec", "gates", "annotations")
+	for _, key := range sets.StringKeySet(gates).List() {
+		if value := gates[key]; instance.GetAnnotations()[key] != value {
+			return &appv1alpha1.ChannelStatus{Phase: appv1alpha1.ChannelGated, Reason: appv1alpha1.ReasonGateNotPassed,
+				Message: fmt.Sprintf("the channel requires the annotation %s=%s", key, value)}
+		}
+	}
+
+	if raw, ok, _ := unstructured.NestedMap(channel.Object, "spec", "gates", "labelSelector"); ok {
+		labelSelector := &metav1.LabelSelector{}
+		selector := labels.Nothing()
+
+		err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, labelSelector)
+		if err == nil {
+			selector, err = utils.ConvertLabels(labelSelector)
+		}
+
+		if err != nil || !selector.Matches(labels.Set(instance.GetLabels())) {
+			return &appv1alpha1.ChannelStatus{Phase: appv1alpha1.ChannelGated, Reason: appv1alpha1.ReasonGateNotPassed,
+				Message: "the labels do not match the label selector of the channel gates"}
+		}
+	}
+
+	return nil
+}
+
+// channelDeployableKey returns the NamespacedName of the deployable published into the namespace of the channel.
+func channelDeployableKey(instance *appv1alpha1.Deployable, channel *unstructured.Unstructured) types.NamespacedName {
+	namespace, _, _ := unstructured.NestedString(channel.Object, "spec", "pathname")
+	if namespace == "" {
+		namespace = channel.GetNamespace()
+	}
+
+	return types.NamespacedName{Namespace: namespace, Name: hostedName(instance)}
+}
+
+// newChannelDeployable returns the deployable published into the namespace of the channel, or why its chart does not render.
+func newChannelDeployable(ctx context.Context, instance *appv1alpha1.Deployable, channel *unstructured.Unstructured) (*appv1alpha1.Deployable, error) {
+	key := channelDeployableKey(instance, channel)
+
+	dpl := &appv1alpha1.Deployable{}
+	dpl.SetName(key.Name)
+	dpl.SetNamespace(key.Namespace)
+
+	annotations := make(map[string]string)
+	for k, v := range instance.GetAnnotations() {
+		annotations[k] = v
+	}
+
+	delete(annotations, appv1alpha1.AnnotationRollingUpdateTarget)
+
+	annotations[appv1alpha1.AnnotationHosting] = types.NamespacedName{Namespace: instance.GetNamespace(), Name: instance.GetName()}.String()
+	annotations[appv1alpha1.AnnotationChannel] = types.NamespacedName{Namespace: channel.GetNamespace(), Name: channel.GetName()}.String()
+	annotations[appv1alpha1.AnnotationIsGenerated] = "true"
+	dpl.SetAnnotations(annotations)
+
+	labels := make(map[string]string)
+	for k, v := range instance.GetLabels() {
+		labels[k] = v
+	}
+
+	labels[appv1alpha1.LabelChannelSource] = instance.GetName()
+	dpl.SetLabels(labels)
+
+	dpl.Spec.Template = instance.Spec.Template.DeepCopy()
+	dpl.Spec.Dependencies = instance.Spec.Dependencies
+
+	// the subscriptions of the channel get the rendered chart
+	if utils.IsHelmChartTemplate(dpl.Spec.Template) {
+		rendered, _, err := utils.RenderHelmChart(ctx, dpl.Spec.Template)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render the helm chart: %w", err)
+		}
+
+		dpl.Spec.Template = rendered
+	}
+
+	return dpl, nil
+}
+
+// listPublished returns the deployables published into channels for the deployable.
+func (r *ReconcileDeployable) listPublished(ctx context.Context, instance *appv1alpha1.Deployable) ([]*appv1alpha1.Deployable, error) {
+	dpllist := &appv1alpha1.DeployableList{}
+	if err := r.List(ctx, dpllist, client.MatchingLabels{appv1alpha1.LabelChannelSource: instance.GetName()}); err != nil {
+		logf.FromContext(ctx).Error(err, "Failed to list deployables published into channels")
+		return nil, err
+	}
+
+	hosting := types.NamespacedName{Namespace: instance.GetNamespace(), Name: instance.GetName()}.String()
+
+	var published []*appv1alpha1.Deployable
+
+	for i := range dpllist.Items {
+		if dpllist.Items[i].GetAnnotations()[appv1alpha1.AnnotationHosting] == hosting {
+			published = append(published, &dpllist.Items[i])
+		}
+	}
+
+	return published, nil
+}
+
+// channelsIndexField indexes the deployables by the NamespacedName of the channels of spec.channels.
+const channelsIndexField = "spec.channels"
+
+// indexChannels returns the NamespacedNames of the channels of spec.channels of the deployable.
+func indexChannels(obj client.Object) []string {
+	dpl, ok := obj.(*appv1alpha1.Deployable)
+	if !ok {
+		return nil
+	}
+
+	keys := make([]string, 0, len(dpl.Spec.Channels))
+	for _, ref := range dpl.Spec.Channels {
+		keys = append(keys, channelKey(dpl, ref).String())
+	}
+
+	return keys
+}
+
+// channelMapper enqueues the deployables publishing into a channel when the channel changes.
+type channelMapper struct {
+	client.Client
+	log logr.Logger
+}
+
+func (mapper *channelMapper) Map(obj client.Object) []reconcile.Request {
+	key := types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}
+
+	ctx, cancel := context.WithTimeout(logf.IntoContext(context.Background(), mapper.log), mapperTimeout)
+	defer cancel()
+
+	dpllist := &appv1alpha1.DeployableList{}
+	if err := mapper.List(ctx, dpllist, client.MatchingFields{channelsIndexField: key.String()}); err != nil {
+		mapper.log.Error(err, "Failed to list deployables for channel mapper", "channel", key.String())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(dpllist.Items))
+	for _, dpl := range dpllist.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: dpl.GetNamespace(), Name: dpl.GetName()}})
+	}
+
+	return requests
+}
+
+// isChannelAPIReady tells if the hub serves the Channel API.
+func isChannelAPIReady(reader client.Reader) bool {
+	channels := &unstructured.UnstructuredList{}
+	channels.SetGroupVersionKind(channelGVK.GroupVersion().WithKind(channelGVK.Kind + "List"))
+
+	return reader.List(context.TODO(), channels, client.Limit(1)) == nil
+}
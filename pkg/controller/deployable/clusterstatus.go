@@ -0,0 +1,91 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployable
+
+import (
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+)
+
+// maxSummarizedFailedClusters caps the failing and drifted clusters kept in a summarized status.
+const maxSummarizedFailedClusters = 20
+
+// summarizeClusterStatus counts the target clusters by phase and returns the status of the first failing or drifted
+// clusters, sorted by name and without their resource status. The returned map is nil if no cluster failed or drifted.
+func summarizeClusterStatus(propagated map[string]*appv1alpha1.ResourceUnitStatus) (*appv1alpha1.ClusterStatusSummary,
+	map[string]*appv1alpha1.ResourceUnitStatus) {
+	summary := &appv1alpha1.ClusterStatusSummary{Total: len(propagated)}
+
+	var failed []string
+
+	for cluster, cs := range propagated {
+		if cs == nil {
+			summary.Pending++
+			continue
+		}
+
+		switch cs.Phase {
+		case appv1alpha1.DeployableDeployed:
+			summary.Deployed++
+		case appv1alpha1.DeployableFailed:
+			summary.Failed++
+
+			failed = append(failed, cluster)
+		default:
+			summary.Pending++
+		}
+
+		if cs.DeferredUntil != nil || cs.Reason == ReasonDeferred {
+			summary.Deferred++
+		}
+
+		if meta.IsStatusConditionTrue(cs.Conditions, appv1alpha1.ConditionDrifted) {
+			summary.Drifted++
+
+			if cs.Phase != appv1alpha1.DeployableFailed {
+				failed = append(failed, cluster)
+			}
+		}
+	}
+
+	if len(failed) == 0 {
+		return summary, nil
+	}
+
+	sort.Strings(failed)
+
+	if len(failed) > maxSummarizedFailedClusters {
+		failed = failed[:maxSummarizedFailedClusters]
+	}
+
+	failing := make(map[string]*appv1alpha1.ResourceUnitStatus, len(failed))
+
+	for _, cluster := range failed {
+		cs := propagated[cluster]
+		failing[cluster] = &appv1alpha1.ResourceUnitStatus{
+			Phase:          cs.Phase,
+			Reason:         cs.Reason,
+			Message:        cs.Message,
+			LastUpdateTime: cs.LastUpdateTime,
+			Conditions:     append([]metav1.Condition(nil), cs.Conditions...),
+		}
+	}
+
+	return summary, failing
+}
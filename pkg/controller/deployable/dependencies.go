@@ -19,28 +19,22 @@ import (
 
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/klog"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
 
 	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
-	"github.com/stolostron/multicloud-operators-deployable/pkg/utils"
 )
 
-func (r *ReconcileDeployable) createManagedDependencies(cluster types.NamespacedName, instance *appv1alpha1.Deployable,
+func (r *ReconcileDeployable) createManagedDependencies(ctx context.Context, cluster types.NamespacedName, instance *appv1alpha1.Deployable,
 	familymap map[string]*appv1alpha1.Deployable) (map[string]*appv1alpha1.Deployable, error) {
-	if klog.V(utils.QuiteLogLel) {
-		fnName := utils.GetFnName()
-		klog.Infof("Entering: %v()", fnName)
-
-		defer klog.Infof("Exiting: %v()", fnName)
-	}
+	log := logf.FromContext(ctx)
 
 	var err error
 
 	hosting := types.NamespacedName{Name: instance.GetName(), Namespace: instance.GetNamespace()}
 	// create or update child deployable
 	for _, dependency := range instance.Spec.Dependencies {
-		klog.V(10).Info("handling dependency:", dependency, "in cluster:", cluster)
+		log.V(logLevelTrace).Info("Handling dependency", "kind", dependency.Kind, "dependency", dependency.Namespace+"/"+dependency.Name)
 		// This controller does not handle non-deployable kind objets
 		// Handle deployable kind
 		if dependency.Kind == instance.Kind || dependency.Kind == "" {
@@ -54,7 +48,7 @@ func (r *ReconcileDeployable) createManagedDependencies(cluster types.Namespaced
 				depobjkey.Namespace = instance.Namespace
 			}
 
-			err = r.Get(context.TODO(), depobjkey, depobj)
+			err = r.Get(ctx, depobjkey, depobj)
 
 			if err != nil {
 				return familymap, err
@@ -84,12 +78,16 @@ func (r *ReconcileDeployable) createManagedDependencies(cluster types.Namespaced
 
 			depobj.SetLabels(objlbl)
 
+			// a dependency without its own naming policy is renamed like the deployable
+			if depobj.Spec.LocalClusterNaming == nil {
+				depobj.Spec.LocalClusterNaming = localClusterNamingFor(instance)
+			}
+
 			if objann[appv1alpha1.AnnotationShared] == "true" {
-				shareddeplist, err := r.getDeployableFamily(depobj)
-				klog.Info("Got shared objs:", shareddeplist)
+				shareddeplist, err := r.getDeployableFamily(ctx, depobj)
 
 				if err != nil && !errors.IsNotFound(err) {
-					klog.Error("failed to get shared dependency")
+					log.Error(err, "Failed to get shared dependency")
 				}
 
 				for _, dpl := range shareddeplist {
@@ -99,8 +97,17 @@ func (r *ReconcileDeployable) createManagedDependencies(cluster types.Namespaced
 				}
 			}
 
-			familymap, err = r.createManagedDeployable(cluster, hosting, depobj, familymap)
+			rendered, err := r.renderLocalDeployable(ctx, cluster, hosting, depobj)
+			if err != nil {
+				// the cluster keeps the child of the dependency until its template renders again
+				log.Error(err, "Failed to render dependency, keeping what the cluster has")
+				delete(familymap, types.NamespacedName{Name: depobj.GetName() + "-", Namespace: cluster.Namespace}.String())
+				r.recordClusterEvent(ctx, instance, appv1alpha1.EventReasonPropagationFailed, cluster.Name, err)
+
+				continue
+			}
 
+			familymap, err = r.createManagedDeployable(ctx, cluster, depobj, rendered, familymap)
 			if err != nil {
 				return familymap, err
 			}
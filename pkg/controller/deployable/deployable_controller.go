@@ -17,14 +17,18 @@ package deployable
 import (
 	"context"
 	"reflect"
+	"time"
 
+	"github.com/go-logr/logr"
 	spokeClusterV1 "github.com/open-cluster-management/api/cluster/v1"
+	workv1 "github.com/open-cluster-management/api/work/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/klog"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
@@ -58,27 +62,20 @@ func newReconciler(mgr manager.Manager) reconcile.Reconciler {
 
 	return &ReconcileDeployable{
 		Client:        mgr.GetClient(),
+		apiReader:     mgr.GetAPIReader(),
 		scheme:        mgr.GetScheme(),
 		authClient:    authClient,
 		eventRecorder: erecorder,
+		log:           mgr.GetLogger().WithName("deployable"),
 	}
 }
 
 type placementruleMapper struct {
 	client.Client
+	log logr.Logger
 }
 
 func (mapper *placementruleMapper) Map(obj client.Object) []reconcile.Request {
-	if klog.V(utils.QuiteLogLel) {
-		fnName := utils.GetFnName()
-		klog.Infof("Entering: %v()", fnName)
-
-		defer klog.Infof("Exiting: %v()", fnName)
-	}
-
-	cname := obj.GetName()
-	klog.V(5).Info("In placement Mapper:", cname)
-
 	var requests []reconcile.Request
 
 	dplList := &appv1alpha1.DeployableList{}
@@ -86,7 +83,7 @@ func (mapper *placementruleMapper) Map(obj client.Object) []reconcile.Request {
 	err := mapper.List(context.TODO(), dplList, listopts)
 
 	if err != nil {
-		klog.Error("Failed to list deployables for placementrule mapper with error:", err)
+		mapper.log.Error(err, "Failed to list deployables for placementrule mapper", "placementrule", obj.GetName())
 		return requests
 	}
 
@@ -109,18 +106,12 @@ func (mapper *placementruleMapper) Map(obj client.Object) []reconcile.Request {
 
 type clusterMapper struct {
 	client.Client
+	log logr.Logger
 }
 
 func (mapper *clusterMapper) Map(obj client.Object) []reconcile.Request {
-	if klog.V(utils.QuiteLogLel) {
-		fnName := utils.GetFnName()
-		klog.Infof("Entering: %v()", fnName)
-
-		defer klog.Infof("Exiting: %v()", fnName)
-	}
-
 	cname := obj.GetName()
-	klog.V(5).Info("In cluster Mapper for ", cname)
+	mapper.log.V(logLevelTrace).Info("Mapping cluster to deployables", "cluster", cname)
 
 	var requests []reconcile.Request
 
@@ -130,7 +121,7 @@ func (mapper *clusterMapper) Map(obj client.Object) []reconcile.Request {
 	err := mapper.List(context.TODO(), dplList, listopts)
 
 	if err != nil {
-		klog.Error("Failed to list deployables for cluster mapper with error:", err)
+		mapper.log.Error(err, "Failed to list deployables for cluster mapper", "cluster", cname)
 		return requests
 	}
 
@@ -167,14 +158,21 @@ func (mapper *clusterMapper) Map(obj client.Object) []reconcile.Request {
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
 func add(mgr manager.Manager, r reconcile.Reconciler) error {
+	log := mgr.GetLogger().WithName("deployable")
+
 	// Create a new controller
 	c, err := controller.New("deployable-controller", mgr, controller.Options{Reconciler: r})
 	if err != nil {
 		return err
 	}
 
+	// fail liveness on a stuck reconcile
+	if err := mgr.AddHealthzCheck("deployable-reconcile", watchdog.check); err != nil {
+		return err
+	}
+
 	// Watch for changes to primary resource Deployable
-	dMapper := &deployableMapper{mgr.GetClient()}
+	dMapper := &deployableMapper{mgr.GetClient(), log}
 	err = c.Watch(
 		&source.Kind{Type: &appv1alpha1.Deployable{}},
 		handler.EnqueueRequestsFromMapFunc(dMapper.Map),
@@ -185,7 +183,7 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 	}
 
 	// watch for placementrule changes
-	pMapper := &placementruleMapper{mgr.GetClient()}
+	pMapper := &placementruleMapper{mgr.GetClient(), log}
 	err = c.Watch(&source.Kind{Type: &placementv1alpha1.PlacementRule{}},
 		handler.EnqueueRequestsFromMapFunc(pMapper.Map),
 		predicate.Funcs{
@@ -201,9 +199,19 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	// watch for namespace suspend switch
+	nsMapper := &namespaceMapper{mgr.GetClient(), log}
+	err = c.Watch(&source.Kind{Type: &corev1.Namespace{}},
+		handler.EnqueueRequestsFromMapFunc(nsMapper.Map),
+		namespacePredicateFunc)
+
+	if err != nil {
+		return err
+	}
+
 	// watch for cluster change excluding heartbeat
 	if placementutils.IsReadyACMClusterRegistry(mgr.GetAPIReader()) {
-		cMapper := &clusterMapper{mgr.GetClient()}
+		cMapper := &clusterMapper{mgr.GetClient(), log}
 		err = c.Watch(
 			&source.Kind{Type: &spokeClusterV1.ManagedCluster{}},
 			handler.EnqueueRequestsFromMapFunc(cMapper.Map),
@@ -215,21 +223,56 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		}
 	}
 
+	// watch for channel changes, like their gates
+	if isChannelAPIReady(mgr.GetAPIReader()) {
+		channel := &unstructured.Unstructured{}
+		channel.SetGroupVersionKind(channelGVK)
+
+		err = mgr.GetFieldIndexer().IndexField(context.TODO(), &appv1alpha1.Deployable{}, channelsIndexField, indexChannels)
+		if err != nil {
+			return err
+		}
+
+		chMapper := &channelMapper{mgr.GetClient(), log}
+
+		err = c.Watch(&source.Kind{Type: channel}, handler.EnqueueRequestsFromMapFunc(chMapper.Map))
+		if err != nil {
+			return err
+		}
+	}
+
+	// watch for manifestwork status changes of the ManifestWork backend
+	manifestWorkAPIReady = isManifestWorkAPIReady(mgr.GetAPIReader())
+	if manifestWorkAPIReady {
+		err = c.Watch(
+			&source.Kind{Type: &workv1.ManifestWork{}},
+			handler.EnqueueRequestsFromMapFunc(manifestWorkMapper),
+		)
+
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// manifestWorkMapper enqueues the hub deployable of a ManifestWork.
+func manifestWorkMapper(obj client.Object) []reconcile.Request {
+	hosting := utils.GetHostDeployableFromObject(obj)
+	if hosting == nil {
+		return nil
+	}
+
+	return []reconcile.Request{{NamespacedName: *hosting}}
+}
+
 type deployableMapper struct {
 	client.Client
+	log logr.Logger
 }
 
 func (mapper *deployableMapper) Map(obj client.Object) []reconcile.Request {
-	if klog.V(utils.QuiteLogLel) {
-		fnName := utils.GetFnName()
-		klog.Infof("Entering: %v()", fnName)
-
-		defer klog.Infof("Exiting: %v()", fnName)
-	}
-
 	// rolling target deployable changed, need to update the rolling deployable
 	var requests []reconcile.Request
 
@@ -249,7 +292,7 @@ func (mapper *deployableMapper) Map(obj client.Object) []reconcile.Request {
 	err := mapper.List(context.TODO(), dplList, listopts)
 
 	if err != nil {
-		klog.Error("Listing deployables in mapper and got error:", err)
+		mapper.log.Error(err, "Failed to list deployables for deployable mapper", "namespace", obj.GetNamespace())
 	}
 
 	for _, dpl := range dplList.Items {
@@ -280,7 +323,7 @@ func (mapper *deployableMapper) Map(obj client.Object) []reconcile.Request {
 		requests = append(requests, reconcile.Request{NamespacedName: *hdplkey})
 	}
 
-	klog.V(5).Info("Out deployable mapper with requests:", requests)
+	mapper.log.V(logLevelTrace).Info("Mapped deployable", "namespace", obj.GetNamespace(), "name", obj.GetName(), "requests", requests)
 
 	return requests
 }
@@ -293,32 +336,44 @@ type ReconcileDeployable struct {
 	// This client, initialized using mgr.Client() above, is a split client
 	// that reads objects from the cache and writes to the apiserver
 	client.Client
+	// apiReader reads the objects the manager does not cache
+	apiReader  client.Reader
 	authClient kubernetes.Interface
 	scheme     *runtime.Scheme
 
 	eventRecorder *utils.EventRecorder
+	log           logr.Logger
 }
 
 // Reconcile reads that state of the cluster for a Deployable object and makes changes based on the state read
 // and what is in the Deployable.Spec
 func (r *ReconcileDeployable) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	ctx, log := r.reconcileContext(ctx, request)
+
+	defer watchdog.begin(request.NamespacedName)()
+
+	ctx, span := startReconcileSpan(ctx, request)
+	defer span.End()
+
+	log.V(logLevelDebug).Info("Reconciling deployable")
+
 	// Fetch the Deployable instance
 	instance := &appv1alpha1.Deployable{}
-	err := r.Get(context.TODO(), request.NamespacedName, instance)
-	klog.Info("Reconciling:", request.NamespacedName, " with Get err:", err)
+	err := r.Get(ctx, request.NamespacedName, instance)
 
 	if err != nil {
 		if errors.IsNotFound(err) {
 			// Object not found, return.  Created objects are automatically garbage collected.
 			// validate all deployables, remove the deployables whose hosting deployables are gone
-			err = r.validateDeployables()
+			deployableMetrics.forget(request.NamespacedName)
+			err = r.validateDeployables(ctx)
 
-			klog.Info("Reconciling - finished.", request.NamespacedName, " with Get err:", err)
+			log.V(logLevelDebug).Info("Deployable is gone, validated orphan deployables", "error", err)
 
 			return reconcile.Result{}, err
 		}
 		// Error reading the object - requeue the request.
-		klog.Info("Reconciling - finished.", request.NamespacedName, " with Get err:", err)
+		log.Error(err, "Failed to get deployable")
 
 		return reconcile.Result{}, err
 	}
@@ -326,11 +381,15 @@ func (r *ReconcileDeployable) Reconcile(ctx context.Context, request reconcile.R
 	savedStatus := instance.Status.DeepCopy()
 
 	// try if it is a hub deployable
-	huberr := r.handleDeployable(instance)
+	huberr := r.handleDeployable(ctx, instance)
 
 	newStatus := instance.Status.DeepCopy()
+	result := reconcile.Result{RequeueAfter: requeueAfter(instance)}
 
 	if huberr != nil {
+		log.Error(huberr, "Failed to handle hub deployable")
+		span.RecordError(huberr)
+
 		newStatus.Phase = appv1alpha1.DeployableFailed
 		newStatus.PropagatedStatus = nil
 		newStatus.Reason = huberr.Error()
@@ -342,12 +401,26 @@ func (r *ReconcileDeployable) Reconcile(ctx context.Context, request reconcile.R
 		newPropagatedStatus[k] = v
 	}
 
-	// only update hub deployable. no need to update propagated deployable.
-	if instance.Spec.Placement != nil {
-		err = r.Update(context.TODO(), instance)
+	// the metrics count every target cluster, even if the hub deployable only keeps their summary
+	observedStatus := *newStatus
+	observedStatus.PropagatedStatus = newPropagatedStatus
+
+	newStatus.ClusterSummary = nil
+
+	if instance.Spec.ClusterStatus == appv1alpha1.ClusterStatusModeSummary {
+		newStatus.ClusterSummary, newPropagatedStatus = summarizeClusterStatus(newPropagatedStatus)
+		newStatus.PropagatedStatus = newPropagatedStatus
+	}
+
+	// only update hub deployable, or the deployable publishing into channels. no need to update propagated deployable.
+	if instance.Spec.Placement != nil || len(instance.Spec.Channels) > 0 || savedStatus.Channels != nil {
+		spanctx, statusSpan := startSpan(ctx, "updateStatus", instance)
+		err = r.Update(spanctx, instance)
 
 		if err != nil {
-			klog.Error("Error returned when updating instance:", err, "instance:", instance)
+			log.Error(err, "Failed to update deployable")
+			endSpan(statusSpan, err)
+
 			return reconcile.Result{}, err
 		}
 
@@ -360,25 +433,47 @@ func (r *ReconcileDeployable) Reconcile(ctx context.Context, request reconcile.R
 
 				newStatus.PropagatedStatus = newPropagatedStatus
 
-				klog.V(5).Infof("instance: %v/%v, Update status: %#v",
-					instance.GetNamespace(), instance.GetName(),
-					newStatus)
-
-				utils.PrintPropagatedStatus(newStatus.PropagatedStatus, "New Propagated Status: ")
+				log.V(logLevelTrace).Info("Updating deployable status", "phase", newStatus.Phase, "reason", newStatus.Reason,
+					"clusters", propagatedPhases(newStatus.PropagatedStatus))
 
 				instance.Status = *newStatus
 
-				err = r.Status().Update(context.TODO(), instance)
+				err = r.Status().Update(spanctx, instance)
 
 				if err != nil {
-					klog.Error("Error returned when updating status:", err, "instance:", instance)
+					log.Error(err, "Failed to update deployable status")
+					endSpan(statusSpan, err)
+
 					return reconcile.Result{}, err
 				}
 			}
 		}
+
+		statusSpan.End()
 	}
 
-	klog.Info("Reconciling - finished.", request.NamespacedName, " with Get err:", err)
+	deployableMetrics.observe(instance, &observedStatus)
+
+	log.V(logLevelDebug).Info("Reconciled deployable", "phase", newStatus.Phase, "requeueAfter", result.RequeueAfter)
+
+	return result, nil
+}
+
+// requeueAfter returns when the deployable needs to be reconciled again for its timers, none while suspended.
+func requeueAfter(instance *appv1alpha1.Deployable) time.Duration {
+	return minRequeueAfter(rollingUpdateRequeueAfter(instance), rolloutStageRequeueAfter(instance),
+		maintenanceWindowRequeueAfter(instance), backendHandoverRequeueAfter(instance))
+}
+
+// minRequeueAfter returns the shortest of the given requeue delays, ignoring the ones not set.
+func minRequeueAfter(delays ...time.Duration) time.Duration {
+	var next time.Duration
+
+	for _, d := range delays {
+		if d > 0 && (next == 0 || d < next) {
+			next = d
+		}
+	}
 
-	return reconcile.Result{}, nil
+	return next
 }
@@ -16,6 +16,8 @@ package deployable
 
 import (
 	"encoding/json"
+	"errors"
+	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
@@ -24,17 +26,31 @@ import (
 
 	"github.com/onsi/gomega"
 	spokeClusterV1 "github.com/open-cluster-management/api/cluster/v1"
+	workv1 "github.com/open-cluster-management/api/work/v1"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"golang.org/x/net/context"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	authv1 "k8s.io/api/authorization/v1"
 	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
+	"github.com/stolostron/multicloud-operators-deployable/pkg/apis"
 	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
 	"github.com/stolostron/multicloud-operators-deployable/pkg/utils"
 	placementrulev1alpha1 "github.com/stolostron/multicloud-operators-placementrule/pkg/apis/apps/v1"
@@ -1076,3 +1092,1448 @@ func getMapkey(dplMap map[string]appv1alpha1.Deployable) string {
 
 	return strings.Join(keys, ",")
 }
+
+func TestRollingUpdateFailureBudget(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	budget, err := parseFailureBudget("2", 10)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(budget).To(gomega.Equal(2))
+
+	budget, err = parseFailureBudget("25%", 10)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(budget).To(gomega.Equal(2))
+
+	_, err = parseFailureBudget("two", 10)
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	_, err = parseFailureBudget("-1", 10)
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	_, err = parseFailureBudget("-10%", 10)
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	_, err = parseFailureBudget("150%", 10)
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	start := metav1.NewTime(time.Now().Add(-time.Hour))
+
+	failed := &appv1alpha1.ResourceUnitStatus{Phase: appv1alpha1.DeployableFailed}
+	g.Expect(isRollingUpdateClusterFailed(failed, 0, &start)).To(gomega.BeTrue())
+
+	stuck := &appv1alpha1.ResourceUnitStatus{Phase: appv1alpha1.DeployablePropagated}
+	g.Expect(isRollingUpdateClusterFailed(stuck, 0, &start)).To(gomega.BeFalse())
+	g.Expect(isRollingUpdateClusterFailed(stuck, time.Minute, &start)).To(gomega.BeTrue())
+	g.Expect(isRollingUpdateClusterFailed(stuck, 2*time.Hour, &start)).To(gomega.BeFalse())
+
+	deployed := &appv1alpha1.ResourceUnitStatus{Phase: appv1alpha1.DeployableDeployed}
+	g.Expect(isRollingUpdateClusterFailed(deployed, time.Minute, &start)).To(gomega.BeFalse())
+}
+
+func TestRollingUpdateFailure(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(apis.AddToScheme(scheme)).To(gomega.Succeed())
+
+	template := func(value string) *runtime.RawExtension {
+		return &runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cm"},"data":{"v":"` + value + `"}}`)}
+	}
+
+	previousov := appv1alpha1.Overrides{
+		ClusterName:      "east",
+		ClusterOverrides: []appv1alpha1.ClusterOverride{{RawExtension: runtime.RawExtension{Raw: []byte(`{"path":"data","value":{"v":"east"}}`)}}},
+	}
+
+	target := &appv1alpha1.Deployable{
+		ObjectMeta: metav1.ObjectMeta{Name: "target", Namespace: dplns},
+		Spec:       appv1alpha1.DeployableSpec{Template: template("v2")},
+	}
+
+	newInstance := func(budget, policy string) *appv1alpha1.Deployable {
+		return &appv1alpha1.Deployable{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      dplname,
+				Namespace: dplns,
+				Annotations: map[string]string{
+					appv1alpha1.AnnotationRollingUpdateTarget:         target.GetName(),
+					appv1alpha1.AnnotationRollingUpdateMaxUnavailable: "100",
+					appv1alpha1.AnnotationRollingUpdateMaxFailure:     budget,
+					appv1alpha1.AnnotationRollingUpdateFailurePolicy:  policy,
+				},
+			},
+			Spec: appv1alpha1.DeployableSpec{Template: template("v1"), Overrides: []appv1alpha1.Overrides{previousov}},
+			Status: appv1alpha1.DeployableStatus{
+				PropagatedStatus: map[string]*appv1alpha1.ResourceUnitStatus{
+					"east": {Phase: appv1alpha1.DeployableDeployed},
+					"west": {Phase: appv1alpha1.DeployableDeployed},
+				},
+			},
+		}
+	}
+
+	r := &ReconcileDeployable{
+		Client:        fake.NewClientBuilder().WithScheme(scheme).WithObjects(target).Build(),
+		eventRecorder: &utils.EventRecorder{EventRecorder: record.NewFakeRecorder(100)},
+	}
+
+	// rolls both clusters, the existing override takes one of the first round
+	rollAll := func(instance *appv1alpha1.Deployable) {
+		g.Expect(r.rollingUpdate(context.TODO(), instance)).To(gomega.Succeed())
+		g.Expect(r.rollingUpdate(context.TODO(), instance)).To(gomega.Succeed())
+		g.Expect(getRollingUpdatePendingClusters(instance, target)).To(gomega.BeEmpty())
+	}
+
+	// both clusters are rolled, then east fails and the rolling update halts
+	instance := newInstance("0", "")
+	rollAll(instance)
+	g.Expect(instance.Spec.Template).To(gomega.Equal(target.Spec.Template))
+
+	instance.Status.PropagatedStatus["east"].Phase = appv1alpha1.DeployableFailed
+	g.Expect(r.rollingUpdate(context.TODO(), instance)).To(gomega.Succeed())
+
+	cond := apimeta.FindStatusCondition(instance.Status.Conditions, appv1alpha1.ConditionProgressing)
+	g.Expect(cond).NotTo(gomega.BeNil())
+	g.Expect(cond.Reason).To(gomega.Equal(appv1alpha1.ReasonRollingUpdateHalted))
+	g.Expect(instance.Status.RollingUpdate.FailedClusters).To(gomega.Equal([]string{"east"}))
+	g.Expect(instance.Spec.Template).To(gomega.Equal(target.Spec.Template))
+
+	// the same failure rolls back the template and overrides with the Rollback policy
+	instance = newInstance("0", string(appv1alpha1.RollingUpdateFailurePolicyRollback))
+	rollAll(instance)
+
+	instance.Status.PropagatedStatus["east"].Phase = appv1alpha1.DeployableFailed
+	g.Expect(r.rollingUpdate(context.TODO(), instance)).To(gomega.Succeed())
+
+	cond = apimeta.FindStatusCondition(instance.Status.Conditions, appv1alpha1.ConditionProgressing)
+	g.Expect(cond).NotTo(gomega.BeNil())
+	g.Expect(cond.Reason).To(gomega.Equal(appv1alpha1.ReasonRollingUpdateRolledBack))
+	g.Expect(instance.Spec.Template).To(gomega.Equal(template("v1")))
+	g.Expect(instance.Spec.Overrides).To(gomega.Equal([]appv1alpha1.Overrides{previousov}))
+	g.Expect(instance.GetAnnotations()).NotTo(gomega.HaveKey(appv1alpha1.AnnotationRollingUpdateTarget))
+
+	// an invalid budget is reported and does not halt
+	instance = newInstance("-1", "")
+	rollAll(instance)
+
+	instance.Status.PropagatedStatus["east"].Phase = appv1alpha1.DeployableFailed
+	g.Expect(r.rollingUpdate(context.TODO(), instance)).To(gomega.Succeed())
+	g.Expect(apimeta.FindStatusCondition(instance.Status.Conditions, appv1alpha1.ConditionProgressing).Reason).NotTo(
+		gomega.Equal(appv1alpha1.ReasonRollingUpdateHalted))
+
+	cond = apimeta.FindStatusCondition(instance.Status.Conditions, appv1alpha1.ConditionInvalidFailureBudget)
+	g.Expect(cond).NotTo(gomega.BeNil())
+	g.Expect(cond.Reason).To(gomega.Equal(appv1alpha1.ReasonFailureBudgetRejected))
+
+	// a new target in the middle of the rolling update restarts from the previous template and overrides
+	instance = newInstance("", "")
+	instance.Status.PropagatedStatus["west"].Phase = appv1alpha1.DeployablePropagated
+	instance.Annotations[appv1alpha1.AnnotationRollingUpdateMaxUnavailable] = "50"
+	g.Expect(r.rollingUpdate(context.TODO(), instance)).To(gomega.Succeed())
+	g.Expect(isRollingUpdateInProgress(instance)).To(gomega.BeTrue())
+
+	newtarget := target.DeepCopy()
+	newtarget.SetName("newtarget")
+	newtarget.SetResourceVersion("")
+	newtarget.Spec.Template = template("v3")
+	g.Expect(r.Create(context.TODO(), newtarget)).To(gomega.Succeed())
+
+	instance.Annotations[appv1alpha1.AnnotationRollingUpdateTarget] = newtarget.GetName()
+	g.Expect(r.rollingUpdate(context.TODO(), instance)).To(gomega.Succeed())
+	g.Expect(instance.Status.RollingUpdate.Target).To(gomega.Equal(newtarget.GetName()))
+	g.Expect(instance.Status.RollingUpdate.PreviousTemplate).To(gomega.Equal(template("v1")))
+	g.Expect(instance.Status.RollingUpdate.PreviousOverrides).To(gomega.Equal([]appv1alpha1.Overrides{previousov}))
+	g.Expect(instance.Spec.Template).To(gomega.Equal(newtarget.Spec.Template))
+
+	// the progress deadline is checked again once ahead, and not again once passed
+	instance.Annotations[appv1alpha1.AnnotationRollingUpdateProgressDeadline] = "1m"
+	g.Expect(rollingUpdateRequeueAfter(instance)).To(gomega.BeNumerically("~", time.Minute+time.Second, time.Second))
+
+	past := metav1.NewTime(time.Now().Add(-2 * time.Minute))
+	instance.Status.RollingUpdate.StartTime = &past
+	instance.Status.PropagatedStatus["west"].LastUpdateTime = &past
+	g.Expect(rollingUpdateRequeueAfter(instance)).To(gomega.BeZero())
+}
+
+func TestAdvanceRolloutStages(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	r := &ReconcileDeployable{eventRecorder: &utils.EventRecorder{EventRecorder: record.NewFakeRecorder(100)}}
+
+	maxunav := 50
+	instance := &appv1alpha1.Deployable{
+		Spec: appv1alpha1.DeployableSpec{
+			RollingUpdate: &appv1alpha1.RollingUpdate{
+				Stages: []appv1alpha1.RolloutStage{
+					{Name: "canary"},
+					{Name: "prod", MaxUnavailable: &maxunav, RequireApproval: true},
+				},
+			},
+		},
+		Status: appv1alpha1.DeployableStatus{
+			PropagatedStatus: map[string]*appv1alpha1.ResourceUnitStatus{
+				"canary1": {Phase: appv1alpha1.DeployableDeployed},
+				"prod1":   {Phase: appv1alpha1.DeployableDeployed},
+				"prod2":   {Phase: appv1alpha1.DeployableDeployed},
+			},
+			RollingUpdate: &appv1alpha1.RollingUpdateStatus{
+				Stages: []appv1alpha1.RolloutStageStatus{
+					{Name: "canary", Phase: appv1alpha1.RolloutStagePending, Clusters: []string{"canary1"}},
+					{Name: "prod", Phase: appv1alpha1.RolloutStagePending, Clusters: []string{"prod1", "prod2"}},
+				},
+			},
+		},
+	}
+
+	pending := map[string]struct{}{"canary1": {}, "prod1": {}, "prod2": {}}
+
+	rollable := r.advanceRolloutStages(context.TODO(), instance, pending)
+	g.Expect(rollable).To(gomega.HaveLen(1))
+	g.Expect(rollable).To(gomega.HaveKey("canary1"))
+
+	// canary is updated and deployed, prod waits for approval
+	delete(pending, "canary1")
+
+	rollable = r.advanceRolloutStages(context.TODO(), instance, pending)
+	g.Expect(rollable).To(gomega.BeEmpty())
+	g.Expect(instance.Status.RollingUpdate.Stages[0].Phase).To(gomega.Equal(appv1alpha1.RolloutStageCompleted))
+	g.Expect(instance.Status.RollingUpdate.Stages[1].Phase).To(gomega.Equal(appv1alpha1.RolloutStageWaitingForApproval))
+
+	// the approver given in the approval is not trusted without the user recorded by the webhook
+	instance.SetAnnotations(map[string]string{
+		appv1alpha1.AnnotationRollingUpdateApprovedBy:    "alice",
+		appv1alpha1.AnnotationRollingUpdateApprovedStage: "prod",
+	})
+
+	rollable = r.advanceRolloutStages(context.TODO(), instance, pending)
+	g.Expect(rollable).To(gomega.BeEmpty())
+	g.Expect(instance.Status.RollingUpdate.Approvals).To(gomega.BeEmpty())
+
+	instance.Annotations[appv1alpha1.AnnotationRollingUpdateApprovedBy] = "mallory"
+	instance.Annotations[appv1alpha1.AnnotationRollingUpdateApprover] = "alice"
+
+	rollable = r.advanceRolloutStages(context.TODO(), instance, pending)
+	g.Expect(rollable).To(gomega.HaveLen(1))
+	g.Expect(rollable).To(gomega.HaveKey("prod1"))
+	g.Expect(instance.Status.RollingUpdate.Stages[1].Phase).To(gomega.Equal(appv1alpha1.RolloutStageProgressing))
+	g.Expect(instance.Status.RollingUpdate.Approvals).To(gomega.HaveLen(1))
+	g.Expect(instance.Status.RollingUpdate.Approvals[0].Approver).To(gomega.Equal("alice"))
+	g.Expect(instance.GetAnnotations()).NotTo(gomega.HaveKey(appv1alpha1.AnnotationRollingUpdateApprovedBy))
+	g.Expect(instance.GetAnnotations()).NotTo(gomega.HaveKey(appv1alpha1.AnnotationRollingUpdateApprover))
+}
+
+func TestCheckSuspended(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "suspended-ns",
+			Labels: map[string]string{appv1alpha1.LabelSuspendDeployables: "true"},
+		},
+	}
+
+	r := &ReconcileDeployable{
+		Client:        fake.NewClientBuilder().WithObjects(ns).Build(),
+		eventRecorder: &utils.EventRecorder{EventRecorder: record.NewFakeRecorder(100)},
+	}
+
+	instance := &appv1alpha1.Deployable{
+		ObjectMeta: metav1.ObjectMeta{Name: dplname, Namespace: dplns},
+		Spec:       appv1alpha1.DeployableSpec{Suspend: true},
+	}
+
+	g.Expect(r.checkSuspended(context.TODO(), instance)).To(gomega.BeTrue())
+	cond := apimeta.FindStatusCondition(instance.Status.Conditions, appv1alpha1.ConditionSuspended)
+	g.Expect(cond).NotTo(gomega.BeNil())
+	g.Expect(cond.Reason).To(gomega.Equal(appv1alpha1.ReasonDeployableSuspended))
+
+	instance.Spec.Suspend = false
+	g.Expect(r.checkSuspended(context.TODO(), instance)).To(gomega.BeFalse())
+	g.Expect(apimeta.IsStatusConditionFalse(instance.Status.Conditions, appv1alpha1.ConditionSuspended)).To(gomega.BeTrue())
+
+	instance.Namespace = ns.GetName()
+	g.Expect(r.checkSuspended(context.TODO(), instance)).To(gomega.BeTrue())
+	cond = apimeta.FindStatusCondition(instance.Status.Conditions, appv1alpha1.ConditionSuspended)
+	g.Expect(cond.Reason).To(gomega.Equal(appv1alpha1.ReasonNamespaceSuspended))
+
+	// the timers of a rolling update do not run while it is suspended
+	start := metav1.NewTime(time.Now().Add(-2 * time.Hour))
+	deployed := metav1.NewTime(time.Now().Add(-time.Hour))
+	instance = &appv1alpha1.Deployable{
+		ObjectMeta: metav1.ObjectMeta{Name: dplname, Namespace: dplns},
+		Spec:       appv1alpha1.DeployableSpec{Suspend: true},
+		Status: appv1alpha1.DeployableStatus{
+			RollingUpdate: &appv1alpha1.RollingUpdateStatus{
+				StartTime: &start,
+				Stages:    []appv1alpha1.RolloutStageStatus{{Name: "canary", Phase: appv1alpha1.RolloutStageBaking, DeployedTime: &deployed}},
+			},
+		},
+	}
+
+	g.Expect(r.checkSuspended(context.TODO(), instance)).To(gomega.BeTrue())
+	g.Expect(instance.Status.RollingUpdate.SuspendedTime).NotTo(gomega.BeNil())
+
+	suspended := metav1.NewTime(time.Now().Add(-30 * time.Minute))
+	instance.Status.RollingUpdate.SuspendedTime = &suspended
+
+	instance.Spec.Suspend = false
+	g.Expect(r.checkSuspended(context.TODO(), instance)).To(gomega.BeFalse())
+
+	rus := instance.Status.RollingUpdate
+	g.Expect(rus.SuspendedTime).To(gomega.BeNil())
+	g.Expect(rus.Stages[0].DeployedTime.Time).To(gomega.BeTemporally("~", deployed.Add(30*time.Minute), time.Second))
+	g.Expect(rollingUpdateProgressStart(rus)).To(gomega.Equal(rus.ResumedTime))
+	g.Expect(rus.ResumedTime.Time).To(gomega.BeTemporally("~", time.Now(), time.Second))
+
+	// a suspended rolling update past its bake time and progress deadline is not requeued
+	instance = &appv1alpha1.Deployable{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        dplname,
+			Namespace:   dplns,
+			Annotations: map[string]string{appv1alpha1.AnnotationRollingUpdateProgressDeadline: "1m"},
+		},
+		Spec: appv1alpha1.DeployableSpec{
+			RollingUpdate: &appv1alpha1.RollingUpdate{
+				Stages: []appv1alpha1.RolloutStage{{Name: "canary", BakeTime: &metav1.Duration{Duration: 10 * time.Minute}}},
+			},
+		},
+		Status: appv1alpha1.DeployableStatus{
+			PropagatedStatus: map[string]*appv1alpha1.ResourceUnitStatus{
+				"west": {Phase: appv1alpha1.DeployablePropagated, LastUpdateTime: &start},
+			},
+			RollingUpdate: &appv1alpha1.RollingUpdateStatus{
+				StartTime: &start,
+				Stages:    []appv1alpha1.RolloutStageStatus{{Name: "canary", Phase: appv1alpha1.RolloutStageBaking, DeployedTime: &deployed}},
+			},
+		},
+	}
+	setRollingUpdateCondition(instance, metav1.ConditionTrue, appv1alpha1.ReasonRollingUpdateProgressing, "")
+	g.Expect(requeueAfter(instance)).To(gomega.Equal(time.Second))
+
+	instance.Spec.Suspend = true
+	g.Expect(r.checkSuspended(context.TODO(), instance)).To(gomega.BeTrue())
+	g.Expect(requeueAfter(instance)).To(gomega.BeZero())
+}
+
+func TestPropagationMetrics(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	tracker := &propagationTracker{
+		phases:        make(map[types.NamespacedName]appv1alpha1.DeployablePhase),
+		clusterPhases: make(map[types.NamespacedName]map[appv1alpha1.DeployablePhase]int),
+		generations:   make(map[types.NamespacedName]*propagationGeneration),
+	}
+
+	instance := &appv1alpha1.Deployable{
+		ObjectMeta: metav1.ObjectMeta{Name: "metrics-dpl", Namespace: dplns, Generation: 1},
+		Spec:       appv1alpha1.DeployableSpec{Placement: &placementrulev1alpha1.Placement{}},
+	}
+
+	status := &appv1alpha1.DeployableStatus{
+		ResourceUnitStatus: appv1alpha1.ResourceUnitStatus{Phase: appv1alpha1.DeployablePropagated},
+		PropagatedStatus: map[string]*appv1alpha1.ResourceUnitStatus{
+			"cluster1": {Phase: appv1alpha1.DeployableDeployed},
+			"cluster2": {Phase: appv1alpha1.DeployableFailed},
+		},
+	}
+
+	key := types.NamespacedName{Name: instance.GetName(), Namespace: instance.GetNamespace()}
+
+	tracker.observe(instance, status)
+	g.Expect(tracker.phases).To(gomega.HaveKeyWithValue(key, appv1alpha1.DeployablePropagated))
+	g.Expect(tracker.clusterPhases[key]).To(gomega.HaveKeyWithValue(appv1alpha1.DeployableFailed, 1))
+	g.Expect(tracker.generations[key].deployed).To(gomega.BeFalse())
+
+	status.PropagatedStatus["cluster2"].Phase = appv1alpha1.DeployableDeployed
+
+	tracker.observe(instance, status)
+	g.Expect(tracker.clusterPhases[key]).To(gomega.HaveKeyWithValue(appv1alpha1.DeployableDeployed, 2))
+	g.Expect(tracker.generations[key].deployed).To(gomega.BeTrue())
+
+	// a spec change starts a new propagation
+	instance.SetGeneration(2)
+	status.PropagatedStatus["cluster2"].Phase = appv1alpha1.DeployablePropagated
+
+	tracker.observe(instance, status)
+	g.Expect(tracker.generations[key].generation).To(gomega.Equal(int64(2)))
+	g.Expect(tracker.generations[key].deployed).To(gomega.BeFalse())
+
+	tracker.forget(key)
+	g.Expect(tracker.phases).NotTo(gomega.HaveKey(key))
+	g.Expect(tracker.generations).NotTo(gomega.HaveKey(key))
+}
+
+func TestTracingSpans(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(sdktrace.NewTracerProvider())
+
+	instance := &appv1alpha1.Deployable{ObjectMeta: metav1.ObjectMeta{Name: dplname, Namespace: dplns}}
+	cluster := types.NamespacedName{Name: "cluster1", Namespace: "cluster1-ns"}
+
+	ctx, span := startReconcileSpan(context.TODO(), reconcile.Request{NamespacedName: dplkey})
+
+	_, child := startSpan(ctx, "createManagedDeployable", instance, clusterAttributes(cluster)...)
+	endSpan(child, errors.New("failed to create"))
+	endSpan(span, nil)
+
+	spans := recorder.Ended()
+	g.Expect(spans).To(gomega.HaveLen(2))
+	g.Expect(spans[0].Name()).To(gomega.Equal("createManagedDeployable"))
+	g.Expect(spans[0].Parent().SpanID()).To(gomega.Equal(spans[1].SpanContext().SpanID()))
+	g.Expect(spans[0].Status().Code).To(gomega.Equal(codes.Error))
+	g.Expect(spans[0].Attributes()).To(gomega.ContainElements(clusterAttributes(cluster)))
+	g.Expect(spans[1].Name()).To(gomega.Equal("Reconcile"))
+	g.Expect(spans[1].Status().Code).To(gomega.Equal(codes.Unset))
+}
+
+func TestSummarizeClusterStatus(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	deferred := metav1.Now()
+	propagated := map[string]*appv1alpha1.ResourceUnitStatus{
+		"cluster1": {Phase: appv1alpha1.DeployableDeployed, DeferredUntil: &deferred},
+		"cluster2": {Phase: appv1alpha1.DeployablePropagated},
+		"cluster3": nil,
+	}
+
+	summary, failing := summarizeClusterStatus(propagated)
+	g.Expect(*summary).To(gomega.Equal(appv1alpha1.ClusterStatusSummary{Total: 3, Deployed: 1, Pending: 2, Deferred: 1}))
+	g.Expect(failing).To(gomega.BeNil())
+
+	// a drifted cluster is counted and listed with its drift condition
+	drifted := metav1.Condition{Type: appv1alpha1.ConditionDrifted, Status: metav1.ConditionTrue, Reason: appv1alpha1.ReasonDriftDetected}
+	propagated["cluster4"] = &appv1alpha1.ResourceUnitStatus{Phase: appv1alpha1.DeployableDeployed, Conditions: []metav1.Condition{drifted}}
+
+	summary, failing = summarizeClusterStatus(propagated)
+	g.Expect(*summary).To(gomega.Equal(appv1alpha1.ClusterStatusSummary{Total: 4, Deployed: 2, Pending: 2, Deferred: 1, Drifted: 1}))
+	g.Expect(failing).To(gomega.HaveLen(1))
+	g.Expect(failing).To(gomega.HaveKeyWithValue("cluster4",
+		&appv1alpha1.ResourceUnitStatus{Phase: appv1alpha1.DeployableDeployed, Conditions: []metav1.Condition{drifted}}))
+
+	for i := 0; i < maxSummarizedFailedClusters+5; i++ {
+		propagated["failed"+strconv.Itoa(100+i)] = &appv1alpha1.ResourceUnitStatus{
+			Phase:          appv1alpha1.DeployableFailed,
+			Reason:         "ApplyFailed",
+			ResourceStatus: &runtime.RawExtension{Raw: []byte(`{"big":"status"}`)},
+		}
+	}
+
+	summary, failing = summarizeClusterStatus(propagated)
+	g.Expect(summary.Total).To(gomega.Equal(maxSummarizedFailedClusters + 9))
+	g.Expect(summary.Failed).To(gomega.Equal(maxSummarizedFailedClusters + 5))
+	g.Expect(failing).To(gomega.HaveLen(maxSummarizedFailedClusters))
+	g.Expect(failing).To(gomega.HaveKeyWithValue("failed100",
+		&appv1alpha1.ResourceUnitStatus{Phase: appv1alpha1.DeployableFailed, Reason: "ApplyFailed"}))
+	g.Expect(failing).To(gomega.HaveKey("cluster4"))
+	g.Expect(failing).NotTo(gomega.HaveKey("failed124"))
+}
+
+func TestApplyDriftPolicy(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	rendered := &runtime.RawExtension{Raw: []byte(`{"kind":"ConfigMap","data":{"a":"1"}}`)}
+	edited := &runtime.RawExtension{Raw: []byte(`{"kind":"ConfigMap","data":{"a":"2"}}`)}
+
+	instance := &appv1alpha1.Deployable{Spec: appv1alpha1.DeployableSpec{DriftPolicy: appv1alpha1.DriftPolicyReport}}
+	existing := setTemplateHash(&appv1alpha1.Deployable{Spec: appv1alpha1.DeployableSpec{Template: rendered.DeepCopy()}})
+	desired := setTemplateHash(existing.DeepCopy())
+
+	g.Expect(applyDriftPolicy(instance, existing, desired)).To(gomega.BeFalse())
+
+	// out of band edit is kept when only reported
+	existing.Spec.Template = edited.DeepCopy()
+	g.Expect(applyDriftPolicy(instance, existing, desired)).To(gomega.BeTrue())
+	g.Expect(desired.Spec.Template).To(gomega.Equal(edited))
+	g.Expect(utils.IsDeployableDrifted(desired)).To(gomega.BeTrue())
+
+	// and corrected when the policy says so
+	instance.Spec.DriftPolicy = appv1alpha1.DriftPolicyCorrect
+	desired = setTemplateHash(&appv1alpha1.Deployable{Spec: appv1alpha1.DeployableSpec{Template: rendered.DeepCopy()}})
+	g.Expect(applyDriftPolicy(instance, existing, desired)).To(gomega.BeTrue())
+	g.Expect(desired.Spec.Template).To(gomega.Equal(rendered))
+	g.Expect(utils.IsDeployableDrifted(desired)).To(gomega.BeFalse())
+
+	// a new hub template is propagated even if the drift is only reported
+	instance.Spec.DriftPolicy = appv1alpha1.DriftPolicyReport
+	desired = setTemplateHash(&appv1alpha1.Deployable{Spec: appv1alpha1.DeployableSpec{
+		Template: &runtime.RawExtension{Raw: []byte(`{"kind":"ConfigMap","data":{"a":"3"}}`)}}})
+	g.Expect(applyDriftPolicy(instance, existing, desired)).To(gomega.BeTrue())
+	g.Expect(utils.IsDeployableDrifted(desired)).To(gomega.BeFalse())
+}
+
+func TestClusterEvents(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	recorder := record.NewFakeRecorder(100)
+	r := &ReconcileDeployable{eventRecorder: &utils.EventRecorder{EventRecorder: recorder}}
+	instance := &appv1alpha1.Deployable{ObjectMeta: metav1.ObjectMeta{Name: "events-dpl", Namespace: dplns}}
+
+	ctx := withClusterEvents(context.TODO())
+
+	for i := 0; i < maxEventClusters+2; i++ {
+		r.recordClusterEvent(ctx, instance, appv1alpha1.EventReasonPropagated, "cluster"+strconv.Itoa(10+i), nil)
+	}
+
+	// dependencies of the same cluster are counted once
+	r.recordClusterEvent(ctx, instance, appv1alpha1.EventReasonPropagated, "cluster10", nil)
+	r.recordClusterEvent(ctx, instance, appv1alpha1.EventReasonChildDeleteFailed, "cluster99", errors.New("forbidden"))
+
+	g.Expect(recorder.Events).To(gomega.BeEmpty())
+
+	r.flushClusterEvents(ctx, instance)
+
+	g.Expect(recorder.Events).To(gomega.HaveLen(2))
+	g.Expect(<-recorder.Events).To(gomega.Equal("Normal Propagated Propagated to 12 clusters: " +
+		"cluster10, cluster11, cluster12, cluster13, cluster14, cluster15, cluster16, cluster17, cluster18, cluster19 and 2 more"))
+	g.Expect(<-recorder.Events).To(gomega.Equal("Warning ChildDeleteFailed Failed to delete children from 1 cluster: cluster99, first error: forbidden"))
+
+	r.flushClusterEvents(ctx, instance)
+	g.Expect(recorder.Events).To(gomega.BeEmpty())
+}
+
+func TestReconcileWatchdog(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	w := &reconcileWatchdog{inProgress: make(map[types.NamespacedName]time.Time)}
+	key := types.NamespacedName{Name: "watchdog-dpl", Namespace: dplns}
+
+	end := w.begin(key)
+	g.Expect(w.check(nil)).To(gomega.Succeed())
+
+	w.inProgress[key] = time.Now().Add(-reconcileStuckAfter - time.Minute)
+	g.Expect(w.check(nil)).To(gomega.MatchError(gomega.ContainSubstring("watchdog-dpl is stuck")))
+
+	end()
+	g.Expect(w.check(nil)).To(gomega.Succeed())
+}
+
+func TestRenderChildren(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(apis.AddToScheme(scheme)).To(gomega.Succeed())
+
+	clusters := []client.Object{
+		&spokeClusterV1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "west", Labels: map[string]string{"env": "prod"}}},
+		&spokeClusterV1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "east", Labels: map[string]string{"env": "prod"}}},
+		&spokeClusterV1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "dev", Labels: map[string]string{"env": "dev"}}},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(clusters...).Build()
+
+	instance := &appv1alpha1.Deployable{
+		ObjectMeta: metav1.ObjectMeta{Name: "render-dpl", Namespace: dplns},
+		Spec: appv1alpha1.DeployableSpec{
+			Template: &runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cm"},"data":{"a":"1"}}`)},
+			Placement: &placementrulev1alpha1.Placement{GenericPlacementFields: placementrulev1alpha1.GenericPlacementFields{
+				ClusterSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+			}},
+			Overrides: []appv1alpha1.Overrides{{
+				ClusterName:      "west",
+				ClusterOverrides: []appv1alpha1.ClusterOverride{{RawExtension: runtime.RawExtension{Raw: []byte(`{"path":"data","value":{"a":"2"}}`)}}},
+			}},
+		},
+	}
+
+	children, err := RenderChildren(context.TODO(), c, instance)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(children).To(gomega.HaveLen(2))
+
+	g.Expect(children[0].GetNamespace()).To(gomega.Equal("east"))
+	g.Expect(children[0].GetAnnotations()).To(gomega.HaveKeyWithValue(appv1alpha1.AnnotationHosting, dplns+"/render-dpl"))
+	g.Expect(children[0].GetLabels()).To(gomega.HaveKeyWithValue(appv1alpha1.LabelSubscriptionPause, "false"))
+	g.Expect(string(children[0].Spec.Template.Raw)).To(gomega.ContainSubstring(`"a":"1"`))
+
+	g.Expect(children[1].GetNamespace()).To(gomega.Equal("west"))
+	g.Expect(string(children[1].Spec.Template.Raw)).To(gomega.ContainSubstring(`"a":"2"`))
+	g.Expect(utils.IsDeployableDrifted(children[1])).To(gomega.BeFalse())
+}
+
+func TestPlanDeployable(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(apis.AddToScheme(scheme)).To(gomega.Succeed())
+
+	instance := &appv1alpha1.Deployable{
+		ObjectMeta: metav1.ObjectMeta{Name: "plan-dpl", Namespace: dplns, Generation: 2},
+		Spec: appv1alpha1.DeployableSpec{
+			Template: &runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cm"},"data":{"a":"1"}}`)},
+			Placement: &placementrulev1alpha1.Placement{GenericPlacementFields: placementrulev1alpha1.GenericPlacementFields{
+				ClusterSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+			}},
+			DryRun: true,
+		},
+	}
+
+	objs := []client.Object{
+		&spokeClusterV1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "east", Labels: map[string]string{"env": "prod"}}},
+		&spokeClusterV1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "west", Labels: map[string]string{"env": "prod"}}},
+		&spokeClusterV1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "north", Labels: map[string]string{"env": "prod"}}},
+		&spokeClusterV1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "dev", Labels: map[string]string{"env": "dev"}}},
+	}
+	r := &ReconcileDeployable{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()}
+
+	// east is up to date, west has an older template and dev is no longer selected
+	var children []client.Object
+
+	for i, cluster := range []string{"east", "west", "dev"} {
+		key := types.NamespacedName{Name: cluster, Namespace: cluster}
+		child, err := r.renderLocalDeployable(context.TODO(), key, types.NamespacedName{Name: instance.Name, Namespace: dplns}, instance)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		child.SetName(child.GetGenerateName() + strconv.Itoa(i))
+
+		if cluster == "west" {
+			child.Spec.Template = &runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cm"},"data":{"a":"0"}}`)}
+			setTemplateHash(child)
+		}
+
+		children = append(children, child)
+	}
+
+	units := map[appv1alpha1.PropagationBackend][]client.Object{appv1alpha1.PropagationBackendDeployable: children}
+	g.Expect(r.planDeployable(context.TODO(), instance, appv1alpha1.PropagationBackendDeployable, units)).To(gomega.Succeed())
+	g.Expect(instance.Status.Plan).To(gomega.Equal(&appv1alpha1.DeployablePlan{
+		ObservedGeneration: 2,
+		Clusters:           []string{"east", "north", "west"},
+		Create:             []string{"north"},
+		Update:             []string{"west"},
+		Delete:             []string{"dev"},
+	}))
+
+	// nothing is written to the hub
+	dpls := &appv1alpha1.DeployableList{}
+	g.Expect(r.List(context.TODO(), dpls)).To(gomega.Succeed())
+	g.Expect(dpls.Items).To(gomega.BeEmpty())
+}
+
+func TestManifestWorkBackend(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(apis.AddToScheme(scheme)).To(gomega.Succeed())
+
+	defer func(ready bool) { manifestWorkAPIReady = ready }(manifestWorkAPIReady)
+
+	manifestWorkAPIReady = true
+
+	instance := &appv1alpha1.Deployable{
+		ObjectMeta: metav1.ObjectMeta{Name: "work-dpl", Namespace: dplns},
+		Spec: appv1alpha1.DeployableSpec{
+			Template: &runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cm"},"data":{"a":"1"}}`)},
+			Backend:  appv1alpha1.PropagationBackendManifestWork,
+		},
+		Status: appv1alpha1.DeployableStatus{PropagatedStatus: map[string]*appv1alpha1.ResourceUnitStatus{}},
+	}
+
+	r := &ReconcileDeployable{
+		Client:        fake.NewClientBuilder().WithScheme(scheme).Build(),
+		eventRecorder: &utils.EventRecorder{EventRecorder: record.NewFakeRecorder(100)},
+	}
+	backend := backends[backendFor(instance)](r)
+	clusters := []types.NamespacedName{{Name: "east", Namespace: "east"}, {Name: "west", Namespace: "west"}}
+
+	expired, err := r.propagateUnits(context.TODO(), backend, clusters, instance, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(expired).To(gomega.BeEmpty())
+
+	works, err := backend.list(context.TODO(), instance)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(works).To(gomega.HaveLen(2))
+
+	work := works[0].(*workv1.ManifestWork)
+	g.Expect(work.GetName()).To(gomega.Equal(dplns + ".work-dpl"))
+	g.Expect(work.Spec.Workload.Manifests[0].Raw).To(gomega.MatchJSON(instance.Spec.Template.Raw))
+
+	cluster := types.NamespacedName{Name: unitCluster(work), Namespace: work.GetNamespace()}
+	rendered, err := r.renderLocalDeployable(context.TODO(), cluster, types.NamespacedName{Name: instance.Name, Namespace: dplns}, instance)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(backend.pending(context.TODO(), cluster, instance, rendered, works)).To(gomega.BeFalse())
+
+	// the work agent reports the status of the cluster
+	now := metav1.Now()
+	work.Status.Conditions = []metav1.Condition{
+		{Type: workv1.WorkApplied, Status: metav1.ConditionTrue, Reason: "AppliedManifestWorkComplete", LastTransitionTime: now},
+		{Type: workv1.WorkAvailable, Status: metav1.ConditionTrue, Reason: "ResourcesAvailable", LastTransitionTime: now},
+	}
+	g.Expect(backend.status(work).Phase).To(gomega.Equal(appv1alpha1.DeployableDeployed))
+
+	work.Status.Conditions = []metav1.Condition{
+		{Type: workv1.WorkApplied, Status: metav1.ConditionFalse, Reason: "AppliedManifestWorkFailed", Message: "forbidden", LastTransitionTime: now},
+	}
+	g.Expect(backend.status(work)).To(gomega.Equal(&appv1alpha1.ResourceUnitStatus{
+		Phase: appv1alpha1.DeployableFailed, Reason: "AppliedManifestWorkFailed", Message: "forbidden", LastUpdateTime: &now,
+	}))
+
+	// only one cluster is still targeted
+	expired, err = r.propagateUnits(context.TODO(), backend, clusters[:1], instance, works)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(expired).To(gomega.HaveLen(1))
+	g.Expect(backend.delete(context.TODO(), instance, expired[0])).To(gomega.Succeed())
+
+	works, err = backend.list(context.TODO(), instance)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(works).To(gomega.HaveLen(1))
+
+	// a ManifestWork named before the current naming keeps its name
+	legacy := works[0].DeepCopyObject().(*workv1.ManifestWork)
+	g.Expect(r.Delete(context.TODO(), works[0])).To(gomega.Succeed())
+	legacy.SetName(dplns + "-work-dpl")
+	legacy.SetResourceVersion("")
+	g.Expect(r.Create(context.TODO(), legacy)).To(gomega.Succeed())
+
+	works, err = backend.list(context.TODO(), instance)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	instance.Spec.Template.Raw = []byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cm"},"data":{"a":"2"}}`)
+	expired, err = r.propagateUnits(context.TODO(), backend, clusters[:1], instance, works)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(expired).To(gomega.BeEmpty())
+
+	works, err = backend.list(context.TODO(), instance)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(works).To(gomega.HaveLen(1))
+	g.Expect(works[0].GetName()).To(gomega.Equal(dplns + "-work-dpl"))
+	g.Expect(works[0].(*workv1.ManifestWork).Spec.Workload.Manifests[0].Raw).To(gomega.MatchJSON(instance.Spec.Template.Raw))
+
+	// the ManifestWorks whose hub deployable is gone are swept
+	g.Expect(r.sweepManifestWorks(context.TODO(), map[string]*appv1alpha1.Deployable{dplns + "/work-dpl": instance})).To(gomega.Succeed())
+	g.Expect(backend.list(context.TODO(), instance)).To(gomega.HaveLen(1))
+	g.Expect(r.sweepManifestWorks(context.TODO(), map[string]*appv1alpha1.Deployable{})).To(gomega.Succeed())
+	g.Expect(backend.list(context.TODO(), instance)).To(gomega.BeEmpty())
+}
+
+func TestHandOverUnits(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(apis.AddToScheme(scheme)).To(gomega.Succeed())
+
+	instance := &appv1alpha1.Deployable{ObjectMeta: metav1.ObjectMeta{Name: "switch-dpl", Namespace: dplns}}
+
+	child := func(cluster string) *appv1alpha1.Deployable {
+		dpl := &appv1alpha1.Deployable{ObjectMeta: metav1.ObjectMeta{Name: "switch-dpl-x", Namespace: cluster}}
+		dpl.SetAnnotations(map[string]string{appv1alpha1.AnnotationManagedCluster: cluster + "/" + cluster})
+		dpl.Spec.Template = &runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"List","items":[` +
+			`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cm"}},{"apiVersion":"v1","kind":"Secret","metadata":{"name":"secret"}}]}`)}
+
+		return dpl
+	}
+
+	east, west, north := child("east"), child("west"), child("north")
+
+	r := &ReconcileDeployable{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(east, west, north).Build()}
+	backend := backends[appv1alpha1.PropagationBackendManifestWork](r)
+
+	available := &workv1.ManifestWork{ObjectMeta: metav1.ObjectMeta{Name: dplns + ".switch-dpl", Namespace: "east"}}
+	available.Status.Conditions = []metav1.Condition{
+		{Type: workv1.WorkApplied, Status: metav1.ConditionTrue, Reason: "AppliedManifestWorkComplete"},
+		{Type: workv1.WorkAvailable, Status: metav1.ConditionTrue, Reason: "ResourcesAvailable"},
+	}
+	applying := &workv1.ManifestWork{ObjectMeta: metav1.ObjectMeta{Name: dplns + ".switch-dpl", Namespace: "west"}}
+
+	allunits := map[appv1alpha1.PropagationBackend][]client.Object{
+		appv1alpha1.PropagationBackendDeployable:   {east, west, north},
+		appv1alpha1.PropagationBackendManifestWork: {available, applying},
+	}
+	clusters := []types.NamespacedName{{Name: "east", Namespace: "east"}, {Name: "west", Namespace: "west"}}
+
+	// the children of the targeted clusters are kept until their resources are marked to be left in place
+	expired := handOverUnits(instance, appv1alpha1.PropagationBackendManifestWork, backend, clusters, allunits)
+	g.Expect(expired[appv1alpha1.PropagationBackendDeployable]).To(gomega.ConsistOf(north))
+
+	// the children of the targeted clusters are marked once, north is no longer targeted and removes its resources
+	allunits[appv1alpha1.PropagationBackendDeployable] = r.keepHandedOverResources(context.TODO(), clusters, allunits[appv1alpha1.PropagationBackendDeployable])
+
+	for _, cluster := range []string{"east", "west", "north"} {
+		marked := &appv1alpha1.Deployable{}
+		g.Expect(r.Get(context.TODO(), types.NamespacedName{Name: "switch-dpl-x", Namespace: cluster}, marked)).To(gomega.Succeed())
+
+		objs, err := utils.TemplateObjects(marked.Spec.Template)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		g.Expect(objs).To(gomega.HaveLen(2))
+
+		for _, obj := range objs {
+			if cluster == "north" {
+				g.Expect(obj.GetAnnotations()).NotTo(gomega.HaveKey(appv1alpha1.AnnotationDoNotDelete))
+				continue
+			}
+
+			g.Expect(obj.GetAnnotations()).To(gomega.HaveKeyWithValue(appv1alpha1.AnnotationDoNotDelete, "true"))
+		}
+
+		if cluster != "north" {
+			g.Expect(marked.GetAnnotations()).To(gomega.HaveKey(appv1alpha1.AnnotationHandedOver))
+			g.Expect(utils.IsDeployableDrifted(marked)).To(gomega.BeFalse())
+		}
+	}
+
+	// the children wait for the subscription agents to deploy the marked resources
+	expired = handOverUnits(instance, appv1alpha1.PropagationBackendManifestWork, backend, clusters, allunits)
+	g.Expect(expired[appv1alpha1.PropagationBackendDeployable]).To(gomega.HaveLen(1))
+
+	deployed := metav1.NewTime(time.Now().Add(time.Second))
+	for _, unit := range allunits[appv1alpha1.PropagationBackendDeployable] {
+		unit.(*appv1alpha1.Deployable).Status.ResourceUnitStatus = appv1alpha1.ResourceUnitStatus{
+			Phase:          appv1alpha1.DeployableDeployed,
+			LastUpdateTime: &deployed,
+		}
+	}
+
+	// west keeps its child until its ManifestWork is available
+	expired = handOverUnits(instance, appv1alpha1.PropagationBackendManifestWork, backend, clusters, allunits)
+	g.Expect(expired[appv1alpha1.PropagationBackendDeployable]).To(gomega.HaveLen(2))
+	g.Expect(unitsByNamespace(expired[appv1alpha1.PropagationBackendDeployable])).To(gomega.HaveKey("east"))
+
+	cond := apimeta.FindStatusCondition(instance.Status.Conditions, appv1alpha1.ConditionBackendHandover)
+	g.Expect(cond).NotTo(gomega.BeNil())
+	g.Expect(cond.Message).To(gomega.ContainSubstring("west"))
+	g.Expect(backendHandoverRequeueAfter(instance)).To(gomega.Equal(backendHandoverInterval))
+
+	applying.Status.Conditions = available.Status.Conditions
+	expired = handOverUnits(instance, appv1alpha1.PropagationBackendManifestWork, backend, clusters, allunits)
+	g.Expect(expired[appv1alpha1.PropagationBackendDeployable]).To(gomega.HaveLen(3))
+	g.Expect(instance.Status.Conditions).To(gomega.BeEmpty())
+	g.Expect(backendHandoverRequeueAfter(instance)).To(gomega.BeZero())
+}
+
+func TestHostedName(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	dpl := func(namespace, name string) *appv1alpha1.Deployable {
+		return &appv1alpha1.Deployable{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+	}
+
+	g.Expect(hostedName(dpl("a-b", "c"))).NotTo(gomega.Equal(hostedName(dpl("a", "b-c"))))
+	g.Expect(hostedName(dpl("a", "b.c"))).To(gomega.Equal("a.b.c"))
+
+	long := hostedName(dpl("ns", strings.Repeat("x", 252)))
+	g.Expect(len(long)).To(gomega.BeNumerically("<=", 253))
+	g.Expect(long).NotTo(gomega.Equal(hostedName(dpl("ns", strings.Repeat("x", 251)+"y"))))
+}
+
+func TestLocalBackend(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(apis.AddToScheme(scheme)).To(gomega.Succeed())
+	g.Expect(corev1.AddToScheme(scheme)).To(gomega.Succeed())
+
+	local := &spokeClusterV1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "local-cluster", Labels: map[string]string{"local-cluster": "true"}}}
+	east := &spokeClusterV1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "east"}}
+
+	instance := &appv1alpha1.Deployable{
+		ObjectMeta: metav1.ObjectMeta{Name: "local-dpl", Namespace: dplns},
+		Spec: appv1alpha1.DeployableSpec{
+			Template:           &runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"Secret","metadata":{"name":"secret"},"data":{"a":"MQ=="}}`)},
+			Backend:            appv1alpha1.PropagationBackendLocal,
+			LocalClusterNaming: &appv1alpha1.LocalClusterNaming{Policy: appv1alpha1.LocalClusterNamingNone},
+		},
+		Status: appv1alpha1.DeployableStatus{PropagatedStatus: map[string]*appv1alpha1.ResourceUnitStatus{}},
+	}
+
+	r := &ReconcileDeployable{
+		Client:        fake.NewClientBuilder().WithScheme(scheme).WithObjects(local, east).Build(),
+		eventRecorder: &utils.EventRecorder{EventRecorder: record.NewFakeRecorder(100)},
+	}
+	backend := backends[backendFor(instance)](r)
+	clusters := []types.NamespacedName{{Name: "local-cluster", Namespace: "local-cluster"}, {Name: "east", Namespace: "east"}}
+
+	// the resources are applied in the namespace of the hub deployable, only for the local cluster
+	expired, err := r.propagateUnits(context.TODO(), backend, clusters, instance, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(expired).To(gomega.BeEmpty())
+	g.Expect(instance.Status.PropagatedStatus["east"].Reason).To(gomega.Equal(ReasonNotLocalCluster))
+
+	secret := &corev1.Secret{}
+	g.Expect(r.Get(context.TODO(), types.NamespacedName{Name: "secret", Namespace: dplns}, secret)).To(gomega.Succeed())
+	g.Expect(secret.Data).To(gomega.Equal(map[string][]byte{"a": []byte("1")}))
+
+	inventories, err := backend.list(context.TODO(), instance)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(inventories).To(gomega.HaveLen(1))
+	g.Expect(inventories[0].GetName()).To(gomega.Equal(dplns + ".local-dpl"))
+	g.Expect(unitCluster(inventories[0])).To(gomega.Equal("local-cluster"))
+	g.Expect(backend.status(inventories[0]).Phase).To(gomega.Equal(appv1alpha1.DeployableDeployed))
+
+	// a resource no longer rendered is deleted
+	instance.Spec.Template.Raw = []byte(`{"apiVersion":"v1","kind":"Service","metadata":{"name":"svc"}}`)
+	expired, err = r.propagateUnits(context.TODO(), backend, clusters[:1], instance, inventories)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(expired).To(gomega.BeEmpty())
+	g.Expect(r.Get(context.TODO(), types.NamespacedName{Name: "secret", Namespace: dplns}, secret)).NotTo(gomega.Succeed())
+	g.Expect(r.Get(context.TODO(), types.NamespacedName{Name: "svc", Namespace: dplns}, &corev1.Service{})).To(gomega.Succeed())
+
+	// deleting the inventory deletes what it lists
+	inventories, err = backend.list(context.TODO(), instance)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(backend.delete(context.TODO(), instance, inventories[0])).To(gomega.Succeed())
+	g.Expect(r.Get(context.TODO(), types.NamespacedName{Name: "svc", Namespace: dplns}, &corev1.Service{})).NotTo(gomega.Succeed())
+	g.Expect(backend.list(context.TODO(), instance)).To(gomega.BeEmpty())
+}
+
+func TestFileBackend(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(apis.AddToScheme(scheme)).To(gomega.Succeed())
+
+	defer SetFileSinkDir(fileSinkDir)
+
+	dir := t.TempDir()
+	SetFileSinkDir(dir)
+
+	instance := &appv1alpha1.Deployable{
+		ObjectMeta: metav1.ObjectMeta{Name: "file-dpl", Namespace: dplns},
+		Spec: appv1alpha1.DeployableSpec{
+			Template: &runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cm"},"data":{"a":"1"}}`)},
+			Backend:  appv1alpha1.PropagationBackendFile,
+		},
+		Status: appv1alpha1.DeployableStatus{PropagatedStatus: map[string]*appv1alpha1.ResourceUnitStatus{}},
+	}
+
+	r := &ReconcileDeployable{
+		Client:        fake.NewClientBuilder().WithScheme(scheme).Build(),
+		eventRecorder: &utils.EventRecorder{EventRecorder: record.NewFakeRecorder(100)},
+	}
+	backend := backends[backendFor(instance)](r)
+	clusters := []types.NamespacedName{{Name: "east", Namespace: "east"}, {Name: "west", Namespace: "west"}}
+
+	expired, err := r.propagateUnits(context.TODO(), backend, clusters, instance, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(expired).To(gomega.BeEmpty())
+	g.Expect(filepath.Join(dir, "east", dplns+".file-dpl.yaml")).To(gomega.BeAnExistingFile())
+
+	files, err := backend.list(context.TODO(), instance)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(files).To(gomega.HaveLen(2))
+
+	child := files[0].(*appv1alpha1.Deployable)
+	g.Expect(child.Spec.Template.Raw).To(gomega.MatchJSON(instance.Spec.Template.Raw))
+	g.Expect(unitCluster(child)).To(gomega.Equal("east"))
+
+	cluster := types.NamespacedName{Name: "east", Namespace: "east"}
+	rendered, err := r.renderLocalDeployable(context.TODO(), cluster, types.NamespacedName{Name: instance.Name, Namespace: dplns}, instance)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(backend.pending(context.TODO(), cluster, instance, rendered, files)).To(gomega.BeFalse())
+
+	// the file of a cluster no longer targeted is deleted
+	expired, err = r.propagateUnits(context.TODO(), backend, clusters[:1], instance, files)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(expired).To(gomega.HaveLen(1))
+	g.Expect(backend.delete(context.TODO(), instance, expired[0])).To(gomega.Succeed())
+	g.Expect(filepath.Join(dir, "west", dplns+".file-dpl.yaml")).NotTo(gomega.BeAnExistingFile())
+
+	// without a directory nothing can be written
+	SetFileSinkDir("")
+
+	_, err = r.propagateUnits(context.TODO(), backend, clusters, instance, nil)
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func TestHelmChartTemplate(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	dir := t.TempDir()
+
+	utils.SetHelmChartSources([]string{dir}, nil)
+	defer utils.SetHelmChartSources(nil, nil)
+	ch := &chart.Chart{
+		Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "web", Version: "1.0.0"},
+		Values:   map[string]interface{}{"replicas": 1},
+		Templates: []*chart.File{
+			{Name: "templates/service.yaml", Data: []byte(
+				"{{ if .Values.broken }}{{ fail \"broken values\" }}{{ end }}apiVersion: v1\nkind: Service\nmetadata:\n  name: {{ .Release.Name }}\n")},
+			{Name: "templates/deployment.yaml", Data: []byte(
+				"apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: {{ .Release.Name }}\nspec:\n  replicas: {{ .Values.replicas }}\n")},
+		},
+	}
+	g.Expect(chartutil.SaveDir(ch, dir)).To(gomega.Succeed())
+
+	scheme := runtime.NewScheme()
+	g.Expect(apis.AddToScheme(scheme)).To(gomega.Succeed())
+
+	instance := &appv1alpha1.Deployable{
+		ObjectMeta: metav1.ObjectMeta{Name: "chart-dpl", Namespace: dplns},
+		Spec: appv1alpha1.DeployableSpec{
+			Template: &runtime.RawExtension{Raw: []byte(`{"apiVersion":"apps.open-cluster-management.io/v1","kind":"HelmChart",` +
+				`"metadata":{"name":"web"},"spec":{"chart":{"path":"` + dir + `/web"}}}`)},
+			Overrides: []appv1alpha1.Overrides{{ClusterName: "east", ClusterOverrides: []appv1alpha1.ClusterOverride{
+				{RawExtension: runtime.RawExtension{Raw: []byte(`{"path":"spec.values.replicas","value":3}`)}},
+			}}},
+		},
+	}
+
+	g.Expect(setChartStatus(context.TODO(), instance)).To(gomega.Succeed())
+	g.Expect(instance.Status.Chart.Name).To(gomega.Equal("web"))
+	g.Expect(instance.Status.Chart.Version).To(gomega.Equal("1.0.0"))
+
+	r := &ReconcileDeployable{Client: fake.NewClientBuilder().WithScheme(scheme).Build()}
+	hosting := types.NamespacedName{Name: instance.GetName(), Namespace: instance.GetNamespace()}
+
+	// the values of the cluster overrides are rendered into the chart resources
+	east, err := r.renderLocalDeployable(context.TODO(), types.NamespacedName{Name: "east", Namespace: "east"}, hosting, instance)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	west, err := r.renderLocalDeployable(context.TODO(), types.NamespacedName{Name: "west", Namespace: "west"}, hosting, instance)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(west.GetAnnotations()[appv1alpha1.AnnotationTemplateHash]).To(gomega.Equal(instance.Status.Chart.RenderedHash))
+	g.Expect(east.GetAnnotations()[appv1alpha1.AnnotationTemplateHash]).NotTo(gomega.Equal(instance.Status.Chart.RenderedHash))
+
+	work := NewManifestWork(instance, east)
+	g.Expect(work.Spec.Workload.Manifests).To(gomega.HaveLen(2))
+	g.Expect(work.Spec.Workload.Manifests[0].Raw).To(gomega.ContainSubstring(`"kind":"Service"`))
+	g.Expect(work.Spec.Workload.Manifests[1].Raw).To(gomega.ContainSubstring(`"replicas":3`))
+
+	// the values of west break the chart, west keeps its unit and the other clusters are propagated
+	r.eventRecorder = &utils.EventRecorder{EventRecorder: record.NewFakeRecorder(100)}
+	backend := &memoryBackend{units: map[string]*appv1alpha1.Deployable{"west": west}}
+	instance.Spec.Overrides = append(instance.Spec.Overrides, appv1alpha1.Overrides{ClusterName: "west", ClusterOverrides: []appv1alpha1.ClusterOverride{
+		{RawExtension: runtime.RawExtension{Raw: []byte(`{"path":"spec.values.broken","value":true}`)}},
+	}})
+	instance.Status.PropagatedStatus = map[string]*appv1alpha1.ResourceUnitStatus{}
+
+	units, _ := backend.list(context.TODO(), instance)
+	clusters := []types.NamespacedName{{Name: "east", Namespace: "east"}, {Name: "west", Namespace: "west"}}
+	expired, err := r.propagateUnits(context.TODO(), backend, clusters, instance, units)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(expired).To(gomega.BeEmpty())
+	g.Expect(backend.units["west"]).To(gomega.BeIdenticalTo(west))
+	g.Expect(backend.units["east"].Spec.Template.Raw).To(gomega.ContainSubstring(`"replicas":3`))
+	g.Expect(instance.Status.PropagatedStatus["west"].Reason).To(gomega.Equal(ReasonRenderFailed))
+	g.Expect(instance.Status.PropagatedStatus["west"].Message).To(gomega.ContainSubstring("broken values"))
+
+	// a chart that does not render fails the propagation
+	instance.Spec.Template.Raw = []byte(`{"apiVersion":"apps.open-cluster-management.io/v1","kind":"HelmChart","spec":{"chart":{"path":"` + dir + `/none"}}}`)
+	g.Expect(setChartStatus(context.TODO(), instance)).NotTo(gomega.Succeed())
+}
+
+func TestClusterGroupOverlays(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(apis.AddToScheme(scheme)).To(gomega.Succeed())
+
+	prod := &spokeClusterV1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "east", Labels: map[string]string{"env": "prod"}}}
+	r := &ReconcileDeployable{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(prod).Build()}
+
+	instance := &appv1alpha1.Deployable{
+		ObjectMeta: metav1.ObjectMeta{Name: "overlay-dpl", Namespace: dplns},
+		Spec: appv1alpha1.DeployableSpec{
+			Template: &runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cm"},"data":{"a":"1"}}`)},
+			Overrides: []appv1alpha1.Overrides{
+				{
+					ClusterSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+					Kustomize:       &appv1alpha1.KustomizeOverlay{NamePrefix: "prod-"},
+				},
+				{
+					ClusterName:      "west",
+					ClusterOverrides: []appv1alpha1.ClusterOverride{{RawExtension: runtime.RawExtension{Raw: []byte(`{"path":"data.a","value":"2"}`)}}},
+				},
+			},
+		},
+		Status: appv1alpha1.DeployableStatus{PropagatedStatus: map[string]*appv1alpha1.ResourceUnitStatus{"west": {}}},
+	}
+
+	hosting := types.NamespacedName{Name: instance.GetName(), Namespace: instance.GetNamespace()}
+	east, err := r.renderLocalDeployable(context.TODO(), types.NamespacedName{Name: "east", Namespace: "east"}, hosting, instance)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(east.Spec.Template.Raw).To(gomega.MatchJSON(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"prod-cm"},"data":{"a":"1"}}`))
+
+	west, err := r.renderLocalDeployable(context.TODO(), types.NamespacedName{Name: "west", Namespace: "west"}, hosting, instance)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(west.Spec.Template.Raw).To(gomega.MatchJSON(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cm"},"data":{"a":"2"}}`))
+
+	// the overrides of cluster groups are neither pending in a rolling update nor removed with the clusters no longer propagated
+	g.Expect(getRollingUpdatePendingClusters(instance, &appv1alpha1.Deployable{})).To(gomega.Equal(map[string]struct{}{"west": {}}))
+
+	instance.Status.PropagatedStatus = nil
+	r.validateOverridesForRollingUpdate(context.TODO(), instance)
+	g.Expect(instance.Spec.Overrides).To(gomega.HaveLen(1))
+	g.Expect(instance.Spec.Overrides[0].Kustomize.NamePrefix).To(gomega.Equal("prod-"))
+
+	// the overlay of east fails, east keeps its unit and west is propagated
+	r.eventRecorder = &utils.EventRecorder{EventRecorder: record.NewFakeRecorder(100)}
+	backend := &memoryBackend{units: map[string]*appv1alpha1.Deployable{"east": east}}
+	instance.Spec.Overrides = append(instance.Spec.Overrides, appv1alpha1.Overrides{
+		ClusterName: "east",
+		Kustomize:   &appv1alpha1.KustomizeOverlay{Patches: []appv1alpha1.KustomizePatch{{Patch: "not a patch"}}},
+	})
+	instance.Status.PropagatedStatus = map[string]*appv1alpha1.ResourceUnitStatus{}
+
+	units, _ := backend.list(context.TODO(), instance)
+	clusters := []types.NamespacedName{{Name: "east", Namespace: "east"}, {Name: "west", Namespace: "west"}}
+	expired, err := r.propagateUnits(context.TODO(), backend, clusters, instance, units)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(expired).To(gomega.BeEmpty())
+	g.Expect(backend.units["east"]).To(gomega.BeIdenticalTo(east))
+	g.Expect(backend.units).To(gomega.HaveKey("west"))
+	g.Expect(instance.Status.PropagatedStatus["east"].Reason).To(gomega.Equal(ReasonRenderFailed))
+}
+
+func TestPublishChannels(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(apis.AddToScheme(scheme)).To(gomega.Succeed())
+
+	newChannel := func(name string, spec map[string]interface{}) *unstructured.Unstructured {
+		channel := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+		channel.SetGroupVersionKind(channelGVK)
+		channel.SetNamespace("ch-ns")
+		channel.SetName(name)
+
+		return channel
+	}
+
+	sources := []interface{}{dplns}
+	open := newChannel("open", map[string]interface{}{"type": "Namespace", "pathname": "ch-store", "sourceNamespaces": sources})
+	gated := newChannel("gated", map[string]interface{}{"type": "namespace", "sourceNamespaces": sources, "gates": map[string]interface{}{
+		"annotations": map[string]interface{}{"dev-ready": "true"},
+	}})
+	helm := newChannel("helm", map[string]interface{}{"type": "HelmRepo"})
+
+	instance := &appv1alpha1.Deployable{
+		ObjectMeta: metav1.ObjectMeta{Name: "pub-dpl", Namespace: dplns},
+		Spec: appv1alpha1.DeployableSpec{
+			Template: &runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cm"}}`)},
+			Channels: []string{"ch-ns/open", "ch-ns/gated", "ch-ns/helm", "missing"},
+		},
+	}
+
+	r := &ReconcileDeployable{
+		Client:        fake.NewClientBuilder().WithScheme(scheme).WithObjects(open, gated, helm).Build(),
+		eventRecorder: &utils.EventRecorder{EventRecorder: record.NewFakeRecorder(100)},
+	}
+
+	// the channel mapper finds the deployables by the index of their channels
+	g.Expect(indexChannels(instance)).To(gomega.Equal([]string{"ch-ns/open", "ch-ns/gated", "ch-ns/helm", dplns + "/missing"}))
+
+	g.Expect(r.publishChannels(context.TODO(), instance)).To(gomega.Succeed())
+	g.Expect(instance.Status.Channels).To(gomega.HaveLen(4))
+	g.Expect(instance.Status.Channels["ch-ns/open"]).To(gomega.Equal(&appv1alpha1.ChannelStatus{
+		Phase: appv1alpha1.ChannelPublished, Deployable: "ch-store/" + dplns + ".pub-dpl"}))
+	g.Expect(instance.Status.Channels["ch-ns/gated"].Phase).To(gomega.Equal(appv1alpha1.ChannelGated))
+	g.Expect(instance.Status.Channels["ch-ns/helm"].Reason).To(gomega.Equal(appv1alpha1.ReasonChannelTypeUnsupported))
+	g.Expect(instance.Status.Channels[dplns+"/missing"].Reason).To(gomega.Equal(appv1alpha1.ReasonChannelNotFound))
+
+	published, err := r.listPublished(context.TODO(), instance)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(published).To(gomega.HaveLen(1))
+	g.Expect(published[0].GetAnnotations()[appv1alpha1.AnnotationChannel]).To(gomega.Equal("ch-ns/open"))
+	g.Expect(published[0].Spec.Placement).To(gomega.BeNil())
+
+	// passing the gate publishes into the gated channel too
+	instance.SetAnnotations(map[string]string{"dev-ready": "true"})
+	g.Expect(r.publishChannels(context.TODO(), instance)).To(gomega.Succeed())
+	g.Expect(instance.Status.Channels["ch-ns/gated"].Phase).To(gomega.Equal(appv1alpha1.ChannelPublished))
+
+	published, err = r.listPublished(context.TODO(), instance)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(published).To(gomega.HaveLen(2))
+
+	// a chart that does not render fails the channels, and keeps what they have
+	template := instance.Spec.Template
+	instance.Spec.Template = &runtime.RawExtension{Raw: []byte(`{"apiVersion":"apps.open-cluster-management.io/v1","kind":"HelmChart",` +
+		`"metadata":{"name":"app"},"spec":{"chart":{"path":"/charts/app"}}}`)}
+	g.Expect(r.publishChannels(context.TODO(), instance)).To(gomega.Succeed())
+	g.Expect(instance.Status.Channels["ch-ns/open"].Phase).To(gomega.Equal(appv1alpha1.ChannelFailed))
+	g.Expect(instance.Status.Channels["ch-ns/open"].Message).To(gomega.ContainSubstring("failed to render the helm chart"))
+
+	published, err = r.listPublished(context.TODO(), instance)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(published).To(gomega.HaveLen(2))
+	g.Expect(published[0].Spec.Template).To(gomega.Equal(template))
+
+	instance.Spec.Template = template
+
+	// removing the channels removes the published deployables
+	instance.Spec.Channels = nil
+	g.Expect(r.publishChannels(context.TODO(), instance)).To(gomega.Succeed())
+	g.Expect(instance.Status.Channels).To(gomega.BeNil())
+
+	published, err = r.listPublished(context.TODO(), instance)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(published).To(gomega.BeEmpty())
+
+	// a channel without source namespaces only takes the deployables of its own namespace
+	closed := newChannel("closed", map[string]interface{}{"type": "Namespace"})
+	g.Expect(checkChannel(instance, closed).Reason).To(gomega.Equal(appv1alpha1.ReasonSourceNamespaceNotAllowed))
+
+	instance.SetNamespace("ch-ns")
+	g.Expect(checkChannel(instance, closed)).To(gomega.BeNil())
+}
+
+// memoryBackend is a propagation backend keeping the units in memory.
+type memoryBackend struct {
+	units map[string]*appv1alpha1.Deployable
+}
+
+func (b *memoryBackend) list(ctx context.Context, instance *appv1alpha1.Deployable) ([]client.Object, error) {
+	var units []client.Object
+	for _, unit := range b.units {
+		units = append(units, unit)
+	}
+
+	return units, nil
+}
+
+func (b *memoryBackend) pending(ctx context.Context, cluster types.NamespacedName, instance, rendered *appv1alpha1.Deployable,
+	units []client.Object) bool {
+	return len(units) == 0 || !reflect.DeepEqual(units[0].(*appv1alpha1.Deployable).Spec.Template, rendered.Spec.Template)
+}
+
+func (b *memoryBackend) apply(ctx context.Context, cluster types.NamespacedName, instance, rendered *appv1alpha1.Deployable,
+	units []client.Object) ([]client.Object, error) {
+	unit := &appv1alpha1.Deployable{ObjectMeta: metav1.ObjectMeta{Name: instance.Name, Namespace: cluster.Namespace}}
+	unit.Spec.Template = rendered.Spec.Template.DeepCopy()
+	b.units[cluster.Namespace] = unit
+
+	return units, nil
+}
+
+func (b *memoryBackend) delete(ctx context.Context, instance *appv1alpha1.Deployable, unit client.Object) error {
+	delete(b.units, unit.GetNamespace())
+	return nil
+}
+
+func (b *memoryBackend) status(unit client.Object) *appv1alpha1.ResourceUnitStatus {
+	return nil
+}
+
+func TestPropagateUnits(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(apis.AddToScheme(scheme)).To(gomega.Succeed())
+
+	objs := []client.Object{
+		&spokeClusterV1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "east", Labels: map[string]string{"maintenance": "weekend"}}},
+		&spokeClusterV1.ManagedCluster{ObjectMeta: metav1.ObjectMeta{Name: "west"}},
+	}
+	r := &ReconcileDeployable{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()}
+	backend := &memoryBackend{units: map[string]*appv1alpha1.Deployable{}}
+
+	instance := &appv1alpha1.Deployable{
+		ObjectMeta: metav1.ObjectMeta{Name: "memory-dpl", Namespace: dplns},
+		Spec: appv1alpha1.DeployableSpec{
+			Template: &runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cm"}}`)},
+		},
+		Status: appv1alpha1.DeployableStatus{PropagatedStatus: map[string]*appv1alpha1.ResourceUnitStatus{}},
+	}
+	clusters := []types.NamespacedName{{Name: "east", Namespace: "east"}, {Name: "west", Namespace: "west"}}
+
+	expired, err := r.propagateUnits(context.TODO(), backend, clusters, instance, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(expired).To(gomega.BeEmpty())
+	g.Expect(backend.units).To(gomega.HaveKey("east"))
+	g.Expect(backend.units).To(gomega.HaveKey("west"))
+
+	// the change waits for the maintenance window of east, west is no longer targeted
+	instance.Spec.Template = &runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cm2"}}`)}
+	instance.Spec.MaintenanceWindows = []appv1alpha1.MaintenanceWindow{{
+		Schedule:        "0 0 * * " + strconv.Itoa(int(time.Now().Add(48*time.Hour).Weekday())),
+		Duration:        metav1.Duration{Duration: time.Hour},
+		ClusterSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"maintenance": "weekend"}},
+	}}
+
+	units, _ := backend.list(context.TODO(), instance)
+	expired, err = r.propagateUnits(context.TODO(), backend, clusters[:1], instance, units)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(expired).To(gomega.HaveLen(1))
+	g.Expect(expired[0].GetNamespace()).To(gomega.Equal("west"))
+	g.Expect(instance.Status.PropagatedStatus["east"].Reason).To(gomega.Equal(ReasonDeferred))
+	g.Expect(backend.units["east"].Spec.Template.Raw).To(gomega.ContainSubstring(`"cm"`))
+	g.Expect(apimeta.FindStatusCondition(instance.Status.Conditions, appv1alpha1.ConditionInvalidMaintenanceWindow)).To(gomega.BeNil())
+
+	// an invalid window defers the change until it is fixed, and is reported in a condition
+	instance.Spec.MaintenanceWindows[0].Schedule = "every weekend"
+	expired, err = r.propagateUnits(context.TODO(), backend, clusters[:1], instance, units)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(expired).To(gomega.HaveLen(1))
+	g.Expect(instance.Status.PropagatedStatus["east"].Reason).To(gomega.Equal(ReasonDeferred))
+	g.Expect(instance.Status.PropagatedStatus["east"].DeferredUntil).To(gomega.BeNil())
+	g.Expect(backend.units["east"].Spec.Template.Raw).To(gomega.ContainSubstring(`"cm"`))
+
+	cond := apimeta.FindStatusCondition(instance.Status.Conditions, appv1alpha1.ConditionInvalidMaintenanceWindow)
+	g.Expect(cond).NotTo(gomega.BeNil())
+	g.Expect(cond.Reason).To(gomega.Equal(appv1alpha1.ReasonMaintenanceWindowRejected))
+	g.Expect(cond.Message).To(gomega.ContainSubstring(`"every weekend"`))
+}
+
+func TestLocalClusterNaming(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(apis.AddToScheme(scheme)).To(gomega.Succeed())
+
+	dependency := &appv1alpha1.Deployable{
+		ObjectMeta: metav1.ObjectMeta{Name: "dependency-dpl", Namespace: dplns},
+		Spec: appv1alpha1.DeployableSpec{
+			Template: &runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"Secret","metadata":{"name":"secret"}}`)},
+		},
+	}
+	r := &ReconcileDeployable{
+		Client:        fake.NewClientBuilder().WithScheme(scheme).WithObjects(&endpoint1, dependency).Build(),
+		eventRecorder: &utils.EventRecorder{EventRecorder: record.NewFakeRecorder(10)},
+	}
+
+	instance := &appv1alpha1.Deployable{
+		ObjectMeta: metav1.ObjectMeta{Name: "naming-dpl", Namespace: dplns},
+		Spec: appv1alpha1.DeployableSpec{
+			Template: &runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"List","items":[` +
+				`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cm"}},{"apiVersion":"v1","kind":"Secret","metadata":{"name":"secret"}}]}`)},
+			Dependencies: []appv1alpha1.Dependency{{ObjectReference: corev1.ObjectReference{Name: dependency.Name}}},
+		},
+	}
+	cluster := types.NamespacedName{Name: endpoint1.Name, Namespace: endpoint1.Name}
+	hosting := types.NamespacedName{Name: instance.GetName(), Namespace: instance.GetNamespace()}
+
+	// every kind is renamed with -local by default
+	local, err := r.renderLocalDeployable(context.TODO(), cluster, hosting, instance)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(local.Spec.Template.Raw).To(gomega.ContainSubstring(`"name":"cm-local"`))
+	g.Expect(local.Spec.Template.Raw).To(gomega.ContainSubstring(`"name":"secret-local"`))
+
+	// the policy of the deployable is limited to its kinds
+	instance.Spec.LocalClusterNaming = &appv1alpha1.LocalClusterNaming{Policy: appv1alpha1.LocalClusterNamingPrefix, Kinds: []string{"ConfigMap"}}
+	local, err = r.renderLocalDeployable(context.TODO(), cluster, hosting, instance)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(local.Spec.Template.Raw).To(gomega.ContainSubstring(`"name":"local-cm"`))
+	g.Expect(local.Spec.Template.Raw).To(gomega.ContainSubstring(`"name":"secret"`))
+	g.Expect(local.Spec.LocalClusterNaming).To(gomega.BeNil())
+
+	g.Expect(localName(&appv1alpha1.LocalClusterNaming{Policy: appv1alpha1.LocalClusterNamingHash}, "cm", "east")).To(gomega.MatchRegexp(`^cm-[0-9a-f]{8}$`))
+	g.Expect(localName(&appv1alpha1.LocalClusterNaming{Policy: appv1alpha1.LocalClusterNamingNone}, "cm", "east")).To(gomega.Equal("cm"))
+
+	// a dependency without its own policy is renamed like the deployable, so the Secret keeps its name
+	_, err = r.createManagedDependencies(context.TODO(), cluster, instance, map[string]*appv1alpha1.Deployable{})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	children := &appv1alpha1.DeployableList{}
+	g.Expect(r.List(context.TODO(), children, client.InNamespace(cluster.Namespace))).To(gomega.Succeed())
+	g.Expect(children.Items).To(gomega.HaveLen(1))
+	g.Expect(children.Items[0].Spec.Template.Raw).To(gomega.ContainSubstring(`"name":"secret"`))
+
+	g.Expect(SetDefaultLocalClusterNaming("Rename", "", nil)).NotTo(gomega.Succeed())
+}
+
+func TestAuthorizePropagation(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(apis.AddToScheme(scheme)).To(gomega.Succeed())
+
+	// alice may create configmaps in east only
+	authClient := kubefake.NewSimpleClientset()
+	authClient.PrependReactor("create", "subjectaccessreviews", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		review := action.(clienttesting.CreateAction).GetObject().(*authv1.SubjectAccessReview)
+		attributes := review.Spec.ResourceAttributes
+		review.Status.Allowed = review.Spec.User == "alice" && attributes.Resource == "configmaps" && attributes.Namespace == "east"
+
+		return true, review, nil
+	})
+
+	r := &ReconcileDeployable{
+		Client:        fake.NewClientBuilder().WithScheme(scheme).Build(),
+		authClient:    authClient,
+		eventRecorder: &utils.EventRecorder{EventRecorder: record.NewFakeRecorder(10)},
+	}
+	backend := &memoryBackend{units: map[string]*appv1alpha1.Deployable{}}
+
+	instance := &appv1alpha1.Deployable{
+		ObjectMeta: metav1.ObjectMeta{Name: "authorized-dpl", Namespace: dplns},
+		Spec: appv1alpha1.DeployableSpec{
+			Template: &runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cm"}}`)},
+		},
+		Status: appv1alpha1.DeployableStatus{PropagatedStatus: map[string]*appv1alpha1.ResourceUnitStatus{}},
+	}
+	utils.SetRecordedUser(instance, "alice", []string{"system:authenticated"})
+
+	SetAuthorizePropagation(true)
+	defer SetAuthorizePropagation(false)
+
+	clusters := []types.NamespacedName{{Name: "east", Namespace: "east"}, {Name: "west", Namespace: "west"}}
+	_, err := r.propagateUnits(context.TODO(), backend, clusters, instance, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(backend.units).To(gomega.HaveKey("east"))
+	g.Expect(backend.units).NotTo(gomega.HaveKey("west"))
+	g.Expect(instance.Status.PropagatedStatus["west"].Phase).To(gomega.Equal(appv1alpha1.DeployableFailed))
+	g.Expect(instance.Status.PropagatedStatus["west"].Reason).To(gomega.Equal(ReasonPropagationDenied))
+	g.Expect(instance.Status.PropagatedStatus["west"].Message).To(gomega.Equal("User alice may not create configmaps in namespace west"))
+
+	// a deployable without a recorded user is not propagated
+	instance.SetAnnotations(nil)
+	rendered, err := r.renderLocalDeployable(context.TODO(), clusters[0], types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}, instance)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	reason, _, err := r.reviewCluster(context.TODO(), clusters[0], instance, rendered)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(reason).To(gomega.Equal(ReasonPropagationDenied))
+
+	// the user of the rolling update target is reviewed too
+	target := &appv1alpha1.Deployable{
+		ObjectMeta: metav1.ObjectMeta{Name: "target-dpl", Namespace: dplns},
+		Spec:       appv1alpha1.DeployableSpec{Template: instance.Spec.Template.DeepCopy()},
+	}
+	utils.SetRecordedUser(target, "mallory", nil)
+	g.Expect(r.Create(context.TODO(), target)).To(gomega.Succeed())
+
+	utils.SetRecordedUser(instance, "alice", []string{"system:authenticated"})
+	instance.Annotations[appv1alpha1.AnnotationRollingUpdateTarget] = target.Name
+
+	reason, msg, err := r.reviewCluster(context.TODO(), clusters[0], instance, rendered)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(reason).To(gomega.Equal(ReasonPropagationDenied))
+	g.Expect(msg).To(gomega.Equal("User mallory may not create configmaps in namespace east"))
+}
+
+func TestTemplateKindsPolicy(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(apis.AddToScheme(scheme)).To(gomega.Succeed())
+
+	dependency := &appv1alpha1.Deployable{
+		ObjectMeta: metav1.ObjectMeta{Name: "binding-dpl", Namespace: dplns},
+		Spec: appv1alpha1.DeployableSpec{
+			Template: &runtime.RawExtension{Raw: []byte(`{"apiVersion":"rbac.authorization.k8s.io/v1","kind":"ClusterRoleBinding","metadata":{"name":"admin"}}`)},
+		},
+	}
+	r := &ReconcileDeployable{
+		Client:        fake.NewClientBuilder().WithScheme(scheme).WithObjects(dependency).Build(),
+		eventRecorder: &utils.EventRecorder{EventRecorder: record.NewFakeRecorder(10)},
+	}
+	backend := &memoryBackend{units: map[string]*appv1alpha1.Deployable{}}
+
+	instance := &appv1alpha1.Deployable{
+		ObjectMeta: metav1.ObjectMeta{Name: "kinds-dpl", Namespace: dplns},
+		Spec: appv1alpha1.DeployableSpec{
+			Template: &runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cm"}}`)},
+		},
+		Status: appv1alpha1.DeployableStatus{PropagatedStatus: map[string]*appv1alpha1.ResourceUnitStatus{}},
+	}
+
+	utils.SetTemplateKindsPolicy(utils.TemplateKindsPolicy{
+		TemplateKindRules: utils.TemplateKindRules{Denied: []string{"ClusterRoleBinding.rbac.authorization.k8s.io"}},
+	}, nil)
+	defer utils.SetTemplateKindsPolicy(utils.TemplateKindsPolicy{}, nil)
+
+	clusters := []types.NamespacedName{{Name: "east", Namespace: "east"}}
+	_, err := r.propagateUnits(context.TODO(), backend, clusters, instance, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(backend.units).To(gomega.HaveKey("east"))
+
+	// a dependency is checked like the template
+	instance.Spec.Dependencies = []appv1alpha1.Dependency{{ObjectReference: corev1.ObjectReference{Name: dependency.Name}}}
+	delete(backend.units, "east")
+
+	_, err = r.propagateUnits(context.TODO(), backend, clusters, instance, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(backend.units).NotTo(gomega.HaveKey("east"))
+	g.Expect(instance.Status.PropagatedStatus["east"].Reason).To(gomega.Equal(ReasonKindNotAllowed))
+	g.Expect(instance.Status.PropagatedStatus["east"].Message).To(gomega.ContainSubstring("ClusterRoleBinding.rbac.authorization.k8s.io is denied"))
+}
@@ -0,0 +1,84 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployable
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+	"github.com/stolostron/multicloud-operators-deployable/pkg/utils"
+)
+
+// applyDriftPolicy tells if the existing child was edited out of band. When the drift policy only reports drift and
+// the template rendered for the cluster did not change, the edited template is kept in the desired child.
+func applyDriftPolicy(instance, existing, desired *appv1alpha1.Deployable) bool {
+	if !utils.IsDeployableDrifted(existing) {
+		return false
+	}
+
+	if instance.Spec.DriftPolicy == appv1alpha1.DriftPolicyReport &&
+		existing.GetAnnotations()[appv1alpha1.AnnotationTemplateHash] == desired.GetAnnotations()[appv1alpha1.AnnotationTemplateHash] {
+		desired.Spec.Template = existing.Spec.Template.DeepCopy()
+	}
+
+	return true
+}
+
+// reportDrift records the Drifted condition in the status of the cluster, and an event when the drift is found or corrected.
+func (r *ReconcileDeployable) reportDrift(ctx context.Context, cluster types.NamespacedName, instance, child *appv1alpha1.Deployable) {
+	status := instance.Status.PropagatedStatus[cluster.Name]
+	if status == nil {
+		status = &appv1alpha1.ResourceUnitStatus{}
+		instance.Status.PropagatedStatus[cluster.Name] = status
+	}
+
+	cond := metav1.Condition{
+		Type:               appv1alpha1.ConditionDrifted,
+		Status:             metav1.ConditionTrue,
+		Reason:             appv1alpha1.ReasonDriftDetected,
+		Message:            "Child deployable " + child.GetNamespace() + "/" + child.GetName() + " was edited out of band",
+		ObservedGeneration: instance.GetGeneration(),
+	}
+
+	if !utils.IsDeployableDrifted(child) {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = appv1alpha1.ReasonDriftCorrected
+		cond.Message = "Rendered template restored in child deployable " + child.GetNamespace() + "/" + child.GetName()
+	}
+
+	if prev := meta.FindStatusCondition(status.Conditions, appv1alpha1.ConditionDrifted); prev != nil && prev.Reason == cond.Reason {
+		return
+	}
+
+	logf.FromContext(ctx).Info(cond.Message, "reason", cond.Reason)
+	meta.SetStatusCondition(&status.Conditions, cond)
+	r.recordClusterEvent(ctx, instance, cond.Reason, cluster.Name, nil)
+}
+
+// keepDriftedCondition carries the Drifted condition of the previous status of the cluster over to its refreshed status.
+func keepDriftedCondition(previous, refreshed *appv1alpha1.ResourceUnitStatus) {
+	if previous == nil {
+		return
+	}
+
+	if cond := meta.FindStatusCondition(previous.Conditions, appv1alpha1.ConditionDrifted); cond != nil {
+		meta.SetStatusCondition(&refreshed.Conditions, *cond)
+	}
+}
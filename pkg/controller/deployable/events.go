@@ -0,0 +1,132 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployable
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+)
+
+// maxEventClusters caps the clusters named in the message of an aggregated event.
+const maxEventClusters = 10
+
+// clusterEventActions describes the per-cluster event reasons in the aggregated event messages.
+var clusterEventActions = map[string]string{
+	appv1alpha1.EventReasonPropagated:        "Propagated to",
+	appv1alpha1.EventReasonPropagationFailed: "Failed to propagate to",
+	appv1alpha1.EventReasonPropagationDenied: "Denied to propagate to",
+	appv1alpha1.EventReasonChildDeleted:      "Deleted children from",
+	appv1alpha1.EventReasonChildDeleteFailed: "Failed to delete children from",
+	appv1alpha1.EventReasonDriftDetected:     "Children edited out of band in",
+	appv1alpha1.EventReasonDriftCorrected:    "Restored the rendered template in",
+}
+
+type clusterEventsKey struct{}
+
+// clusterEvents aggregates the per-cluster events of a reconcile into one event per reason,
+// so that updating many clusters does not flood the namespace with events.
+type clusterEvents struct {
+	reasons  []string
+	clusters map[string]sets.String
+	errs     map[string]error
+}
+
+// withClusterEvents starts aggregating the per-cluster events recorded with ctx.
+func withClusterEvents(ctx context.Context) context.Context {
+	return context.WithValue(ctx, clusterEventsKey{}, &clusterEvents{
+		clusters: make(map[string]sets.String),
+		errs:     make(map[string]error),
+	})
+}
+
+// recordClusterEvent adds the cluster to the aggregated event of the reason, keeping the first error.
+// The event is recorded right away if ctx does not aggregate events.
+func (r *ReconcileDeployable) recordClusterEvent(ctx context.Context, instance *appv1alpha1.Deployable, reason, cluster string, err error) {
+	events, ok := ctx.Value(clusterEventsKey{}).(*clusterEvents)
+	if !ok {
+		r.eventRecorder.RecordEvent(instance, reason, clusterEventMessage(reason, []string{cluster}, err), err)
+		return
+	}
+
+	if _, ok := events.clusters[reason]; !ok {
+		events.reasons = append(events.reasons, reason)
+		events.clusters[reason] = sets.NewString()
+	}
+
+	// a cluster with dependencies has several children
+	events.clusters[reason].Insert(cluster)
+
+	if err != nil && events.errs[reason] == nil {
+		events.errs[reason] = err
+	}
+}
+
+// flushClusterEvents records the aggregated events on the hub deployable.
+func (r *ReconcileDeployable) flushClusterEvents(ctx context.Context, instance *appv1alpha1.Deployable) {
+	events, ok := ctx.Value(clusterEventsKey{}).(*clusterEvents)
+	if !ok {
+		return
+	}
+
+	for _, reason := range events.reasons {
+		err := events.errs[reason]
+		r.eventRecorder.RecordEvent(instance, reason, clusterEventMessage(reason, events.clusters[reason].List(), err), err)
+	}
+
+	events.reasons = nil
+	events.clusters = make(map[string]sets.String)
+	events.errs = make(map[string]error)
+}
+
+// clusterEventMessage describes the event of the reason for the sorted clusters.
+func clusterEventMessage(reason string, clusters []string, err error) string {
+	named := clusters
+	if len(named) > maxEventClusters {
+		named = named[:maxEventClusters]
+	}
+
+	noun := "clusters"
+	if len(clusters) == 1 {
+		noun = "cluster"
+	}
+
+	msg := fmt.Sprintf("%s %d %s: %s", clusterEventActions[reason], len(clusters), noun, strings.Join(named, ", "))
+
+	if more := len(clusters) - len(named); more > 0 {
+		msg += fmt.Sprintf(" and %d more", more)
+	}
+
+	if err != nil {
+		msg += ", first error: " + err.Error()
+	}
+
+	return msg
+}
+
+// recordChildDeleted adds the cluster of the deleted child to the aggregated delete events.
+func (r *ReconcileDeployable) recordChildDeleted(ctx context.Context, instance *appv1alpha1.Deployable, child client.Object, err error) {
+	reason := appv1alpha1.EventReasonChildDeleted
+	if err != nil {
+		reason = appv1alpha1.EventReasonChildDeleteFailed
+	}
+
+	r.recordClusterEvent(ctx, instance, reason, unitCluster(child), err)
+}
@@ -0,0 +1,210 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployable
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/yaml"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+)
+
+// fileSinkDir is the directory the File backend writes the rendered child deployables to.
+var fileSinkDir string
+
+// SetFileSinkDir sets the directory the File backend writes the rendered child deployables to, a directory per cluster namespace.
+func SetFileSinkDir(dir string) {
+	fileSinkDir = dir
+}
+
+// fileBackend writes the child deployable rendered for each cluster to <dir>/<cluster namespace>/<namespace>.<name>.yaml,
+// for a pipeline or an agent outside of the hub to pick up. The file, read back as a child deployable, is the unit.
+type fileBackend struct {
+	r *ReconcileDeployable
+}
+
+// filePath is the path of the file of the hub deployable in the directory of a cluster namespace.
+func filePath(instance *appv1alpha1.Deployable, namespace string) string {
+	return filepath.Join(fileSinkDir, namespace, hostedName(instance)+".yaml")
+}
+
+// list reads back the files of the hub deployable, none if no directory is set.
+func (b *fileBackend) list(ctx context.Context, instance *appv1alpha1.Deployable) ([]client.Object, error) {
+	if fileSinkDir == "" {
+		return nil, nil
+	}
+
+	paths, err := filepath.Glob(filePath(instance, "*"))
+	if err != nil {
+		return nil, err
+	}
+
+	hosting := types.NamespacedName{Namespace: instance.GetNamespace(), Name: instance.GetName()}.String()
+
+	var units []client.Object
+
+	for _, path := range paths {
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		dpl := &appv1alpha1.Deployable{}
+		if err := yaml.Unmarshal(raw, dpl); err != nil {
+			logf.FromContext(ctx).Error(err, "Failed to read file, rewriting it", "file", path)
+
+			// a file that can not be read back is rewritten
+			dpl.SetName(instance.GetName())
+			dpl.SetNamespace(filepath.Base(filepath.Dir(path)))
+		} else if dpl.GetAnnotations()[appv1alpha1.AnnotationHosting] != hosting {
+			continue
+		}
+
+		units = append(units, dpl)
+	}
+
+	return units, nil
+}
+
+// desiredFile names the rendered child deployable after the hub deployable, and returns the existing one if any.
+func desiredFile(instance, rendered *appv1alpha1.Deployable, units []client.Object) (*appv1alpha1.Deployable, *appv1alpha1.Deployable) {
+	desired := rendered.DeepCopy()
+	desired.SetGenerateName("")
+	desired.SetName(instance.GetName())
+	desired.SetGroupVersionKind(appv1alpha1.SchemeGroupVersion.WithKind("Deployable"))
+
+	for _, unit := range units {
+		if unit.GetNamespace() == desired.GetNamespace() {
+			return desired, unit.(*appv1alpha1.Deployable)
+		}
+	}
+
+	return desired, nil
+}
+
+func (b *fileBackend) pending(ctx context.Context, cluster types.NamespacedName, instance, rendered *appv1alpha1.Deployable,
+	units []client.Object) bool {
+	desired, existing := desiredFile(instance, rendered, units)
+
+	return existing == nil || !isFileUpToDate(existing, desired)
+}
+
+func (b *fileBackend) apply(ctx context.Context, cluster types.NamespacedName, instance, rendered *appv1alpha1.Deployable,
+	units []client.Object) ([]client.Object, error) {
+	log := logf.FromContext(ctx)
+	desired, existing := desiredFile(instance, rendered, units)
+
+	if existing != nil && isFileUpToDate(existing, desired) {
+		return []client.Object{existing}, nil
+	}
+
+	err := writeFile(filePath(instance, cluster.Namespace), desired)
+	if existing == nil {
+		recordChildOperation(childOperationCreate, err)
+	} else {
+		recordChildOperation(childOperationUpdate, err)
+	}
+
+	reason := appv1alpha1.EventReasonPropagated
+	if err != nil {
+		reason = appv1alpha1.EventReasonPropagationFailed
+	}
+
+	b.r.recordClusterEvent(ctx, instance, reason, cluster.Name, err)
+
+	if err != nil {
+		log.Error(err, "Failed to write file")
+		return nil, err
+	}
+
+	log.Info("Wrote file", "file", filePath(instance, cluster.Namespace))
+
+	instance.Status.PropagatedStatus[cluster.Name] = &appv1alpha1.ResourceUnitStatus{}
+
+	if existing == nil {
+		return nil, nil
+	}
+
+	return []client.Object{existing}, nil
+}
+
+// writeFile writes the child deployable to a temporary file renamed in place, so that readers never see a partial file.
+func writeFile(path string, dpl *appv1alpha1.Deployable) error {
+	if fileSinkDir == "" {
+		return errors.New("the File backend has no directory, set --file-sink-dir")
+	}
+
+	raw, err := yaml.Marshal(dpl)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (b *fileBackend) delete(ctx context.Context, instance *appv1alpha1.Deployable, unit client.Object) error {
+	path := filePath(instance, unit.GetNamespace())
+	logf.FromContext(ctx).Info("Deleting file", "file", path)
+
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		err = nil
+	}
+
+	recordChildOperation(childOperationDelete, err)
+	b.r.recordChildDeleted(ctx, instance, unit, err)
+
+	return err
+}
+
+// status reports the cluster deployed once its file is written, what picks it up reporting nothing back.
+func (b *fileBackend) status(unit client.Object) *appv1alpha1.ResourceUnitStatus {
+	return &appv1alpha1.ResourceUnitStatus{Phase: appv1alpha1.DeployableDeployed}
+}
+
+// isFileUpToDate tells if the file has the rendered template and the labels of the desired child deployable.
+func isFileUpToDate(existing, desired *appv1alpha1.Deployable) bool {
+	return existing.GetAnnotations()[appv1alpha1.AnnotationTemplateHash] == desired.GetAnnotations()[appv1alpha1.AnnotationTemplateHash] &&
+		reflect.DeepEqual(existing.GetLabels(), desired.GetLabels())
+}
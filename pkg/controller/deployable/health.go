@@ -0,0 +1,64 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployable
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// reconcileStuckAfter is how long a reconcile may run before the liveness check fails.
+const reconcileStuckAfter = 10 * time.Minute
+
+// reconcileWatchdog tracks the reconciles in progress, so that the liveness check catches a stuck reconcile loop.
+type reconcileWatchdog struct {
+	mu         sync.Mutex
+	inProgress map[types.NamespacedName]time.Time
+}
+
+var watchdog = &reconcileWatchdog{inProgress: make(map[types.NamespacedName]time.Time)}
+
+// begin records the start of the reconcile of key. The returned function records its end.
+func (w *reconcileWatchdog) begin(key types.NamespacedName) func() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.inProgress[key] = time.Now()
+
+	return func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		delete(w.inProgress, key)
+	}
+}
+
+// check is the liveness check failing when a reconcile has been running for longer than reconcileStuckAfter.
+func (w *reconcileWatchdog) check(_ *http.Request) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for key, start := range w.inProgress {
+		if running := time.Since(start); running > reconcileStuckAfter {
+			return fmt.Errorf("reconcile of deployable %s is stuck for %s", key, running.Round(time.Second))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,337 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployable
+
+import (
+	"context"
+	"encoding/json"
+	goerrors "errors"
+	"reflect"
+	"strings"
+
+	spokeClusterV1 "github.com/open-cluster-management/api/cluster/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+	"github.com/stolostron/multicloud-operators-deployable/pkg/utils"
+)
+
+const (
+	// ReasonNotLocalCluster is the propagated status reason of a cluster the Local backend can not apply to, not being
+	// the hub itself.
+	ReasonNotLocalCluster = "NotLocalCluster"
+
+	// localInventoryKey is the key of the inventory ConfigMap listing the applied resources.
+	localInventoryKey = "resources"
+)
+
+// localBackend applies the rendered template directly to the hub, for the cluster labeled local-cluster=true, and keeps
+// the resources it applied in an inventory ConfigMap in the cluster namespace. The inventory ConfigMap is the unit.
+// The resources without a namespace are applied in the namespace of the hub deployable.
+type localBackend struct {
+	r *ReconcileDeployable
+}
+
+func (b *localBackend) list(ctx context.Context, instance *appv1alpha1.Deployable) ([]client.Object, error) {
+	cmlist := &corev1.ConfigMapList{}
+	if err := b.r.List(ctx, cmlist, client.MatchingLabels{
+		appv1alpha1.PropertyHostingDeployableName: instance.GetName(),
+		appv1alpha1.LabelLocalInventory:           "true",
+	}); err != nil {
+		logf.FromContext(ctx).Error(err, "Failed to list local inventories", "hosting", instance.GetNamespace()+"/"+instance.GetName())
+		return nil, err
+	}
+
+	hosting := types.NamespacedName{Namespace: instance.GetNamespace(), Name: instance.GetName()}.String()
+
+	var inventories []client.Object
+
+	for i := range cmlist.Items {
+		if cmlist.Items[i].GetAnnotations()[appv1alpha1.AnnotationHosting] == hosting {
+			inventories = append(inventories, &cmlist.Items[i])
+		}
+	}
+
+	return inventories, nil
+}
+
+// desiredInventory builds the inventory ConfigMap of the rendered child deployable, and returns the existing one if any.
+func desiredInventory(instance, rendered *appv1alpha1.Deployable, units []client.Object) (*corev1.ConfigMap, *corev1.ConfigMap) {
+	desired := &corev1.ConfigMap{}
+	desired.SetName(hostedName(instance))
+	desired.SetNamespace(rendered.GetNamespace())
+	desired.SetAnnotations(rendered.GetAnnotations())
+
+	labels := map[string]string{appv1alpha1.LabelLocalInventory: "true"}
+	for k, v := range rendered.GetLabels() {
+		labels[k] = v
+	}
+
+	desired.SetLabels(labels)
+
+	for _, unit := range units {
+		if unit.GetNamespace() == desired.GetNamespace() {
+			return desired, unit.(*corev1.ConfigMap)
+		}
+	}
+
+	return desired, nil
+}
+
+func (b *localBackend) pending(ctx context.Context, cluster types.NamespacedName, instance, rendered *appv1alpha1.Deployable,
+	units []client.Object) bool {
+	desired, inventory := desiredInventory(instance, rendered, units)
+
+	return inventory == nil || !isInventoryUpToDate(inventory, desired)
+}
+
+func (b *localBackend) apply(ctx context.Context, cluster types.NamespacedName, instance, rendered *appv1alpha1.Deployable,
+	units []client.Object) ([]client.Object, error) {
+	log := logf.FromContext(ctx)
+	desired, inventory := desiredInventory(instance, rendered, units)
+
+	if inventory != nil && isInventoryUpToDate(inventory, desired) {
+		return []client.Object{inventory}, nil
+	}
+
+	if !b.isLocalCluster(ctx, cluster) {
+		msg := "The Local backend only applies to the cluster labeled local-cluster=true"
+		log.Info(msg)
+
+		instance.Status.PropagatedStatus[cluster.Name] = &appv1alpha1.ResourceUnitStatus{
+			Phase:   appv1alpha1.DeployableFailed,
+			Reason:  ReasonNotLocalCluster,
+			Message: msg,
+		}
+
+		b.r.recordClusterEvent(ctx, instance, appv1alpha1.EventReasonPropagationFailed, cluster.Name, goerrors.New(msg))
+
+		if inventory == nil {
+			return nil, nil
+		}
+
+		return []client.Object{inventory}, nil
+	}
+
+	refs, err := b.applyObjects(ctx, instance, rendered)
+	if err == nil {
+		err = b.saveInventory(ctx, desired, inventory, refs)
+	}
+
+	reason := appv1alpha1.EventReasonPropagated
+	if err != nil {
+		reason = appv1alpha1.EventReasonPropagationFailed
+	}
+
+	b.r.recordClusterEvent(ctx, instance, reason, cluster.Name, err)
+
+	if err != nil {
+		log.Error(err, "Failed to apply to the local cluster")
+		return nil, err
+	}
+
+	instance.Status.PropagatedStatus[cluster.Name] = &appv1alpha1.ResourceUnitStatus{}
+
+	if inventory == nil {
+		return nil, nil
+	}
+
+	return []client.Object{inventory}, nil
+}
+
+// isLocalCluster tells if the cluster is the hub itself.
+func (b *localBackend) isLocalCluster(ctx context.Context, cluster types.NamespacedName) bool {
+	managedCluster := &spokeClusterV1.ManagedCluster{}
+	if err := b.r.Get(ctx, types.NamespacedName{Name: cluster.Name}, managedCluster); err != nil {
+		logf.FromContext(ctx).Error(err, "Failed to find managed cluster")
+		return false
+	}
+
+	return strings.EqualFold(managedCluster.GetLabels()["local-cluster"], "true")
+}
+
+// applyObjects creates or updates the resources of the rendered template in the hub, and returns their references.
+func (b *localBackend) applyObjects(ctx context.Context, instance, rendered *appv1alpha1.Deployable) ([]corev1.ObjectReference, error) {
+	objs, err := utils.TemplateObjects(rendered.Spec.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]corev1.ObjectReference, 0, len(objs))
+
+	for _, obj := range objs {
+		if obj.GetNamespace() == "" && b.isNamespaced(obj) {
+			obj.SetNamespace(instance.GetNamespace())
+		}
+
+		annotations := obj.GetAnnotations()
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+
+		annotations[appv1alpha1.AnnotationHosting] = rendered.GetAnnotations()[appv1alpha1.AnnotationHosting]
+		obj.SetAnnotations(annotations)
+
+		existing := &unstructured.Unstructured{}
+		existing.SetGroupVersionKind(obj.GroupVersionKind())
+
+		err := b.r.Get(ctx, client.ObjectKeyFromObject(obj), existing)
+
+		switch {
+		case errors.IsNotFound(err):
+			logf.FromContext(ctx).Info("Creating local resource", "kind", obj.GetKind(), "resource", obj.GetNamespace()+"/"+obj.GetName())
+			err = b.r.Create(ctx, obj)
+			recordChildOperation(childOperationCreate, err)
+		case err == nil:
+			obj.SetResourceVersion(existing.GetResourceVersion())
+			err = b.r.Update(ctx, obj)
+			recordChildOperation(childOperationUpdate, err)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		refs = append(refs, objectReference(obj))
+	}
+
+	return refs, nil
+}
+
+// isNamespaced tells if the kind of the resource is namespaced, assuming it is when the hub can not tell.
+func (b *localBackend) isNamespaced(obj *unstructured.Unstructured) bool {
+	mapper := b.r.RESTMapper()
+	if mapper == nil {
+		return true
+	}
+
+	mapping, err := mapper.RESTMapping(obj.GroupVersionKind().GroupKind(), obj.GroupVersionKind().Version)
+	if err != nil {
+		return true
+	}
+
+	return mapping.Scope.Name() == meta.RESTScopeNameNamespace
+}
+
+// saveInventory creates or updates the inventory ConfigMap, and deletes the resources it no longer lists.
+func (b *localBackend) saveInventory(ctx context.Context, desired, inventory *corev1.ConfigMap, refs []corev1.ObjectReference) error {
+	raw, err := json.Marshal(refs)
+	if err != nil {
+		return err
+	}
+
+	desired.Data = map[string]string{localInventoryKey: string(raw)}
+
+	if inventory == nil {
+		err = b.r.Create(ctx, desired)
+		recordChildOperation(childOperationCreate, err)
+
+		return err
+	}
+
+	applied := make(map[corev1.ObjectReference]bool)
+	for _, ref := range refs {
+		applied[ref] = true
+	}
+
+	for _, ref := range inventoryObjects(inventory) {
+		if !applied[ref] {
+			if err := b.deleteObject(ctx, ref); err != nil {
+				return err
+			}
+		}
+	}
+
+	inventory.SetLabels(desired.GetLabels())
+	inventory.SetAnnotations(desired.GetAnnotations())
+	inventory.Data = desired.Data
+	err = b.r.Update(ctx, inventory)
+	recordChildOperation(childOperationUpdate, err)
+
+	return err
+}
+
+func (b *localBackend) deleteObject(ctx context.Context, ref corev1.ObjectReference) error {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(ref.APIVersion)
+	obj.SetKind(ref.Kind)
+	obj.SetNamespace(ref.Namespace)
+	obj.SetName(ref.Name)
+
+	logf.FromContext(ctx).Info("Deleting local resource", "kind", ref.Kind, "resource", ref.Namespace+"/"+ref.Name)
+
+	err := b.r.Delete(ctx, obj)
+	if errors.IsNotFound(err) {
+		err = nil
+	}
+
+	recordChildOperation(childOperationDelete, err)
+
+	return err
+}
+
+// delete deletes the resources listed in the inventory, then the inventory.
+func (b *localBackend) delete(ctx context.Context, instance *appv1alpha1.Deployable, unit client.Object) error {
+	var err error
+
+	for _, ref := range inventoryObjects(unit.(*corev1.ConfigMap)) {
+		if err = b.deleteObject(ctx, ref); err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		err = b.r.Delete(ctx, unit)
+		if errors.IsNotFound(err) {
+			err = nil
+		}
+	}
+
+	b.r.recordChildDeleted(ctx, instance, unit, err)
+
+	return err
+}
+
+// status reports the cluster deployed once the resources are applied, the hub having no agent to report them.
+func (b *localBackend) status(unit client.Object) *appv1alpha1.ResourceUnitStatus {
+	return &appv1alpha1.ResourceUnitStatus{Phase: appv1alpha1.DeployableDeployed}
+}
+
+// isInventoryUpToDate tells if the inventory ConfigMap was saved for the rendered template and has the labels of the desired one.
+func isInventoryUpToDate(inventory, desired *corev1.ConfigMap) bool {
+	return inventory.GetAnnotations()[appv1alpha1.AnnotationTemplateHash] == desired.GetAnnotations()[appv1alpha1.AnnotationTemplateHash] &&
+		reflect.DeepEqual(inventory.GetLabels(), desired.GetLabels())
+}
+
+// inventoryObjects returns the resources listed in the inventory ConfigMap.
+func inventoryObjects(inventory *corev1.ConfigMap) []corev1.ObjectReference {
+	var refs []corev1.ObjectReference
+
+	if err := json.Unmarshal([]byte(inventory.Data[localInventoryKey]), &refs); err != nil {
+		return nil
+	}
+
+	return refs
+}
+
+func objectReference(obj *unstructured.Unstructured) corev1.ObjectReference {
+	return corev1.ObjectReference{APIVersion: obj.GetAPIVersion(), Kind: obj.GetKind(), Namespace: obj.GetNamespace(), Name: obj.GetName()}
+}
@@ -0,0 +1,68 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployable
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+)
+
+// Log verbosity levels of the deployable controller.
+// Changes made to deployables and the reconcile outcome are logged at the default level.
+const (
+	// logLevelDebug is for the decisions taken in a reconcile.
+	logLevelDebug = 1
+	// logLevelTrace is for the details of each reconcile step.
+	logLevelTrace = 2
+)
+
+// reconcileContext returns the context of a reconcile, with a logger carrying the deployable and a reconcile ID.
+func (r *ReconcileDeployable) reconcileContext(ctx context.Context, request reconcile.Request) (context.Context, logr.Logger) {
+	log := r.log
+	if log == nil {
+		log = logf.Log.WithName("deployable")
+	}
+
+	log = log.WithValues("namespace", request.Namespace, "name", request.Name, "reconcileID", string(uuid.NewUUID()))
+
+	return logf.IntoContext(ctx, log), log
+}
+
+// clusterContext adds the target cluster to the logger of ctx.
+func clusterContext(ctx context.Context, cluster types.NamespacedName) (context.Context, logr.Logger) {
+	log := logf.FromContext(ctx, "cluster", cluster.Name)
+
+	return logf.IntoContext(ctx, log), log
+}
+
+// propagatedPhases summarizes the propagated status as the phase of each cluster.
+func propagatedPhases(status map[string]*appv1alpha1.ResourceUnitStatus) map[string]appv1alpha1.DeployablePhase {
+	phases := make(map[string]appv1alpha1.DeployablePhase, len(status))
+
+	for cluster, cs := range status {
+		if cs != nil {
+			phases[cluster] = cs.Phase
+		}
+	}
+
+	return phases
+}
@@ -0,0 +1,131 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployable
+
+import (
+	"context"
+	"time"
+
+	spokeClusterV1 "github.com/open-cluster-management/api/cluster/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+	"github.com/stolostron/multicloud-operators-deployable/pkg/utils"
+)
+
+// ReasonDeferred is the propagated status reason of a cluster waiting for its maintenance window.
+const ReasonDeferred = "Deferred"
+
+// deferToMaintenanceWindow tells if the change of the units in the cluster has to wait for a maintenance window.
+// When deferred, the units in the cluster are kept as they are and the propagated status records when the next window opens.
+func (r *ReconcileDeployable) deferToMaintenanceWindow(ctx context.Context, backend propagationBackend, cluster types.NamespacedName,
+	instance, rendered *appv1alpha1.Deployable, units []client.Object) bool {
+	if len(instance.Spec.MaintenanceWindows) == 0 {
+		return false
+	}
+
+	// nothing to defer if the units are already up to date
+	if !backend.pending(ctx, cluster, instance, rendered, units) {
+		return false
+	}
+
+	managedCluster := &spokeClusterV1.ManagedCluster{}
+	if err := r.Get(ctx, types.NamespacedName{Name: cluster.Name}, managedCluster); err != nil {
+		logf.FromContext(ctx).Error(err, "Failed to find managed cluster for maintenance windows")
+	}
+
+	next, err := utils.NextMaintenanceWindow(instance.Spec.MaintenanceWindows, labels.Set(managedCluster.GetLabels()), time.Now())
+	if err == nil && next.IsZero() {
+		return false
+	}
+
+	if instance.Status.PropagatedStatus == nil {
+		instance.Status.PropagatedStatus = make(map[string]*appv1alpha1.ResourceUnitStatus)
+	}
+
+	status := instance.Status.PropagatedStatus[cluster.Name]
+	if status == nil {
+		status = &appv1alpha1.ResourceUnitStatus{}
+		instance.Status.PropagatedStatus[cluster.Name] = status
+	}
+
+	status.Reason = ReasonDeferred
+
+	// an invalid window defers the changes until it is fixed
+	if err != nil {
+		logf.FromContext(ctx).Info("Deferring changes until the maintenance window is fixed", "error", err.Error())
+
+		status.DeferredUntil = nil
+		status.Message = "Changes are deferred until the " + err.Error() + " is fixed"
+
+		return true
+	}
+
+	logf.FromContext(ctx).V(logLevelDebug).Info("Deferring changes to the next maintenance window", "deferredUntil", next)
+
+	deferredUntil := metav1.NewTime(next)
+	status.DeferredUntil = &deferredUntil
+	status.Message = "Changes are deferred until the maintenance window at " + deferredUntil.UTC().Format(time.RFC3339)
+
+	return true
+}
+
+// checkMaintenanceWindows records the first maintenance window that can not be parsed in the InvalidMaintenanceWindow condition.
+func checkMaintenanceWindows(instance *appv1alpha1.Deployable) {
+	err := utils.ValidateMaintenanceWindows(instance.Spec.MaintenanceWindows, time.Now())
+	if err == nil {
+		meta.RemoveStatusCondition(&instance.Status.Conditions, appv1alpha1.ConditionInvalidMaintenanceWindow)
+		return
+	}
+
+	meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+		Type:               appv1alpha1.ConditionInvalidMaintenanceWindow,
+		Status:             metav1.ConditionTrue,
+		Reason:             appv1alpha1.ReasonMaintenanceWindowRejected,
+		Message:            "The " + err.Error() + ", the changes of the clusters it may apply to are deferred",
+		ObservedGeneration: instance.GetGeneration(),
+	})
+}
+
+// maintenanceWindowRequeueAfter returns when the earliest deferred maintenance window opens, never while suspended.
+func maintenanceWindowRequeueAfter(instance *appv1alpha1.Deployable) time.Duration {
+	if isSuspended(instance) {
+		return 0
+	}
+
+	var next time.Duration
+
+	for _, status := range instance.Status.PropagatedStatus {
+		if status == nil || status.DeferredUntil == nil {
+			continue
+		}
+
+		wait := time.Until(status.DeferredUntil.Time) + time.Second
+		if wait < time.Second {
+			wait = time.Second
+		}
+
+		if next == 0 || wait < next {
+			next = wait
+		}
+	}
+
+	return next
+}
@@ -0,0 +1,282 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployable
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	workv1 "github.com/open-cluster-management/api/work/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+	"github.com/stolostron/multicloud-operators-deployable/pkg/utils"
+)
+
+// manifestWorkAPIReady is set when the hub serves the ManifestWork API, so that ManifestWorks are watched and listed.
+var manifestWorkAPIReady bool
+
+// isManifestWorkAPIReady tells if the hub serves the ManifestWork API.
+func isManifestWorkAPIReady(reader client.Reader) bool {
+	return reader.List(context.TODO(), &workv1.ManifestWorkList{}, client.Limit(1)) == nil
+}
+
+// hostedName is the name of the objects the hub deployable keeps in other namespaces, like its ManifestWork in each
+// cluster namespace or its copy in a channel. The namespace and the name are joined with a dot, which a namespace can
+// not have, so that the names of two hub deployables never collide. A name too long for an object is cut and suffixed
+// with a hash of the full name.
+func hostedName(instance *appv1alpha1.Deployable) string {
+	name := instance.GetNamespace() + "." + instance.GetName()
+	if len(name) <= validation.DNS1123SubdomainMaxLength {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(name))
+
+	return strings.TrimRight(name[:validation.DNS1123SubdomainMaxLength-9], ".-") + "-" + hex.EncodeToString(sum[:])[:8]
+}
+
+// manifestWorkBackend creates a ManifestWork with the rendered template in each cluster namespace, and reads the
+// cluster status back from the conditions the work agent reports.
+type manifestWorkBackend struct {
+	r *ReconcileDeployable
+}
+
+// list returns the ManifestWorks of the hub deployable, none if the hub does not serve the ManifestWork API.
+func (b *manifestWorkBackend) list(ctx context.Context, instance *appv1alpha1.Deployable) ([]client.Object, error) {
+	if !manifestWorkAPIReady {
+		return nil, nil
+	}
+
+	worklist := &workv1.ManifestWorkList{}
+	if err := b.r.List(ctx, worklist, client.MatchingLabels{appv1alpha1.PropertyHostingDeployableName: instance.GetName()}); err != nil {
+		logf.FromContext(ctx).Error(err, "Failed to list manifestworks", "hosting", instance.GetNamespace()+"/"+instance.GetName())
+		return nil, err
+	}
+
+	hosting := types.NamespacedName{Namespace: instance.GetNamespace(), Name: instance.GetName()}.String()
+
+	var works []client.Object
+
+	for i := range worklist.Items {
+		if worklist.Items[i].GetAnnotations()[appv1alpha1.AnnotationHosting] == hosting {
+			works = append(works, &worklist.Items[i])
+		}
+	}
+
+	return works, nil
+}
+
+// desiredManifestWork wraps the child deployable rendered for the cluster in its ManifestWork, and returns the existing one if any.
+func desiredManifestWork(instance, rendered *appv1alpha1.Deployable, units []client.Object) (*workv1.ManifestWork, *workv1.ManifestWork) {
+	desired := NewManifestWork(instance, rendered)
+
+	// the ManifestWorks named before the current naming keep their name
+	for _, unit := range units {
+		if unit.GetNamespace() == desired.GetNamespace() {
+			desired.SetName(unit.GetName())
+			return desired, unit.(*workv1.ManifestWork)
+		}
+	}
+
+	return desired, nil
+}
+
+func (b *manifestWorkBackend) pending(ctx context.Context, cluster types.NamespacedName, instance, rendered *appv1alpha1.Deployable,
+	units []client.Object) bool {
+	desired, work := desiredManifestWork(instance, rendered, units)
+
+	return work == nil || !isManifestWorkUpToDate(work, desired)
+}
+
+func (b *manifestWorkBackend) apply(ctx context.Context, cluster types.NamespacedName, instance, rendered *appv1alpha1.Deployable,
+	units []client.Object) ([]client.Object, error) {
+	log := logf.FromContext(ctx)
+	desired, work := desiredManifestWork(instance, rendered, units)
+
+	if work != nil && isManifestWorkUpToDate(work, desired) {
+		return []client.Object{work}, nil
+	}
+
+	var err error
+
+	if work == nil {
+		log.Info("Creating manifestwork", "manifestwork", desired.GetNamespace()+"/"+desired.GetName())
+		err = b.r.Create(ctx, desired)
+		recordChildOperation(childOperationCreate, err)
+	} else {
+		log.Info("Updating manifestwork", "manifestwork", work.GetNamespace()+"/"+work.GetName())
+		work.SetLabels(desired.GetLabels())
+		work.SetAnnotations(desired.GetAnnotations())
+		work.Spec = desired.Spec
+		err = b.r.Update(ctx, work)
+		recordChildOperation(childOperationUpdate, err)
+	}
+
+	reason := appv1alpha1.EventReasonPropagated
+	if err != nil {
+		reason = appv1alpha1.EventReasonPropagationFailed
+	}
+
+	b.r.recordClusterEvent(ctx, instance, reason, cluster.Name, err)
+
+	if err != nil {
+		log.Error(err, "Failed to create or update manifestwork")
+		return nil, err
+	}
+
+	instance.Status.PropagatedStatus[cluster.Name] = &appv1alpha1.ResourceUnitStatus{}
+
+	if work == nil {
+		return nil, nil
+	}
+
+	return []client.Object{work}, nil
+}
+
+func (b *manifestWorkBackend) delete(ctx context.Context, instance *appv1alpha1.Deployable, unit client.Object) error {
+	logf.FromContext(ctx).V(logLevelTrace).Info("Deleting manifestwork", "manifestwork", unit.GetNamespace()+"/"+unit.GetName())
+
+	err := b.r.Delete(ctx, unit)
+	if errors.IsNotFound(err) {
+		err = nil
+	}
+
+	recordChildOperation(childOperationDelete, err)
+	b.r.recordChildDeleted(ctx, instance, unit, err)
+
+	return err
+}
+
+// sweepManifestWorks deletes the ManifestWorks whose hub deployable is gone, like those left while the controller was down.
+func (r *ReconcileDeployable) sweepManifestWorks(ctx context.Context, deployables map[string]*appv1alpha1.Deployable) error {
+	if !manifestWorkAPIReady {
+		return nil
+	}
+
+	worklist := &workv1.ManifestWorkList{}
+	if err := r.List(ctx, worklist, client.HasLabels{appv1alpha1.PropertyHostingDeployableName}); err != nil {
+		return err
+	}
+
+	for i := range worklist.Items {
+		work := &worklist.Items[i]
+
+		hosting := work.GetAnnotations()[appv1alpha1.AnnotationHosting]
+		if hosting == "" {
+			continue
+		}
+
+		if _, ok := deployables[hosting]; ok {
+			continue
+		}
+
+		err := r.Delete(ctx, work)
+		if errors.IsNotFound(err) {
+			err = nil
+		}
+
+		recordChildOperation(childOperationDelete, err)
+		logf.FromContext(ctx).Info("Deleted manifestwork whose hosting deployable is gone", "manifestwork", work.GetNamespace()+"/"+work.GetName(),
+			"hosting", hosting, "error", err)
+	}
+
+	return nil
+}
+
+func (b *manifestWorkBackend) status(unit client.Object) *appv1alpha1.ResourceUnitStatus {
+	return manifestWorkStatus(unit.(*workv1.ManifestWork))
+}
+
+// NewManifestWork wraps the template of the child deployable rendered for a cluster in a ManifestWork.
+func NewManifestWork(instance, child *appv1alpha1.Deployable) *workv1.ManifestWork {
+	work := &workv1.ManifestWork{}
+	work.SetName(hostedName(instance))
+	work.SetNamespace(child.GetNamespace())
+	work.SetLabels(child.GetLabels())
+	work.SetAnnotations(child.GetAnnotations())
+	work.Spec.Workload.Manifests = []workv1.Manifest{{RawExtension: *child.Spec.Template.DeepCopy()}}
+
+	// a List, like a rendered chart, has a manifest per resource
+	if utils.IsListTemplate(child.Spec.Template) {
+		list := &unstructured.UnstructuredList{}
+		if err := list.UnmarshalJSON(child.Spec.Template.Raw); err == nil {
+			work.Spec.Workload.Manifests = nil
+
+			for i := range list.Items {
+				if raw, err := list.Items[i].MarshalJSON(); err == nil {
+					work.Spec.Workload.Manifests = append(work.Spec.Workload.Manifests, workv1.Manifest{RawExtension: runtime.RawExtension{Raw: raw}})
+				}
+			}
+		}
+	}
+
+	return work
+}
+
+// isManifestWorkUpToDate tells if the ManifestWork has the rendered template and the labels of the desired one.
+func isManifestWorkUpToDate(work, desired *workv1.ManifestWork) bool {
+	return work.GetAnnotations()[appv1alpha1.AnnotationTemplateHash] == desired.GetAnnotations()[appv1alpha1.AnnotationTemplateHash] &&
+		reflect.DeepEqual(work.GetLabels(), desired.GetLabels())
+}
+
+// manifestWorkStatus reads the cluster status from the conditions the work agent reports on the ManifestWork.
+func manifestWorkStatus(work *workv1.ManifestWork) *appv1alpha1.ResourceUnitStatus {
+	status := &appv1alpha1.ResourceUnitStatus{}
+
+	conds := work.Status.Conditions
+	applied := meta.FindStatusCondition(conds, workv1.WorkApplied)
+	available := meta.FindStatusCondition(conds, workv1.WorkAvailable)
+	degraded := meta.FindStatusCondition(conds, workv1.WorkDegraded)
+
+	switch {
+	case degraded != nil && degraded.Status == metav1.ConditionTrue:
+		status.Phase = appv1alpha1.DeployableFailed
+		status.Reason, status.Message = degraded.Reason, degraded.Message
+	case applied != nil && applied.Status == metav1.ConditionFalse:
+		status.Phase = appv1alpha1.DeployableFailed
+		status.Reason, status.Message = applied.Reason, applied.Message
+	case applied != nil && available != nil && applied.Status == metav1.ConditionTrue && available.Status == metav1.ConditionTrue:
+		status.Phase = appv1alpha1.DeployableDeployed
+	case available != nil:
+		status.Reason, status.Message = available.Reason, available.Message
+	}
+
+	for i := range conds {
+		if status.LastUpdateTime == nil || status.LastUpdateTime.Before(&conds[i].LastTransitionTime) {
+			status.LastUpdateTime = conds[i].LastTransitionTime.DeepCopy()
+		}
+	}
+
+	if len(work.Status.ResourceStatus.Manifests) > 0 {
+		if raw, err := json.Marshal(work.Status.ResourceStatus); err == nil {
+			status.ResourceStatus = &runtime.RawExtension{Raw: raw}
+		}
+	}
+
+	return status
+}
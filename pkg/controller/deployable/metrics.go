@@ -0,0 +1,212 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployable
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+)
+
+const (
+	metricsNamespace = "deployable"
+
+	childOperationCreate = "create"
+	childOperationUpdate = "update"
+	childOperationDelete = "delete"
+)
+
+var (
+	hubDeployablesGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "hub_deployables",
+		Help:      "Number of hub deployables by phase.",
+	}, []string{"phase"})
+
+	clusterStatusesGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "cluster_statuses",
+		Help:      "Number of per-cluster propagated statuses of hub deployables by phase.",
+	}, []string{"phase"})
+
+	propagationDurationHistogram = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "propagation_duration_seconds",
+		Help:      "Time from a hub deployable spec change until all its clusters are deployed.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 14),
+	})
+
+	childOperationsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "child_operations_total",
+		Help:      "Number of child deployable create, update and delete operations.",
+	}, []string{"operation"})
+
+	childOperationErrorsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "child_operation_errors_total",
+		Help:      "Number of failed child deployable create, update and delete operations.",
+	}, []string{"operation"})
+
+	rolloutProgressGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "rollout_progress_ratio",
+		Help:      "Ratio of clusters moved to the target template by the ongoing rolling update of a hub deployable.",
+	}, []string{"namespace", "name"})
+
+	expiredChildrenHistogram = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "expired_children",
+		Help:      "Number of expired children found by each sweep of a hub deployable.",
+		Buckets:   []float64{0, 1, 2, 5, 10, 20, 50, 100, 200, 500},
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		hubDeployablesGauge,
+		clusterStatusesGauge,
+		propagationDurationHistogram,
+		childOperationsCounter,
+		childOperationErrorsCounter,
+		rolloutProgressGauge,
+		expiredChildrenHistogram,
+	)
+}
+
+// propagationTracker remembers the phases of the hub deployables to keep the phase gauges,
+// and when their current spec generation started to propagate.
+type propagationTracker struct {
+	mu sync.Mutex
+
+	phases        map[types.NamespacedName]appv1alpha1.DeployablePhase
+	clusterPhases map[types.NamespacedName]map[appv1alpha1.DeployablePhase]int
+	generations   map[types.NamespacedName]*propagationGeneration
+}
+
+type propagationGeneration struct {
+	generation int64
+	start      time.Time
+	deployed   bool
+}
+
+var deployableMetrics = &propagationTracker{
+	phases:        make(map[types.NamespacedName]appv1alpha1.DeployablePhase),
+	clusterPhases: make(map[types.NamespacedName]map[appv1alpha1.DeployablePhase]int),
+	generations:   make(map[types.NamespacedName]*propagationGeneration),
+}
+
+// observe records the new status of a reconciled hub deployable.
+func (t *propagationTracker) observe(instance *appv1alpha1.Deployable, status *appv1alpha1.DeployableStatus) {
+	key := types.NamespacedName{Namespace: instance.GetNamespace(), Name: instance.GetName()}
+
+	if instance.Spec.Placement == nil {
+		t.forget(key)
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.phases[key] = status.Phase
+
+	clusterPhases := make(map[appv1alpha1.DeployablePhase]int)
+	deployed := len(status.PropagatedStatus) > 0
+
+	for _, cs := range status.PropagatedStatus {
+		if cs == nil {
+			continue
+		}
+
+		clusterPhases[cs.Phase]++
+
+		if cs.Phase != appv1alpha1.DeployableDeployed {
+			deployed = false
+		}
+	}
+
+	t.clusterPhases[key] = clusterPhases
+
+	gen := t.generations[key]
+	if gen == nil || gen.generation != instance.GetGeneration() {
+		gen = &propagationGeneration{generation: instance.GetGeneration(), start: time.Now()}
+		t.generations[key] = gen
+	}
+
+	if deployed && !gen.deployed {
+		gen.deployed = true
+
+		propagationDurationHistogram.Observe(time.Since(gen.start).Seconds())
+	}
+
+	t.updateGauges()
+}
+
+// forget drops a deployable that is gone or no longer a hub deployable.
+func (t *propagationTracker) forget(key types.NamespacedName) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.phases, key)
+	delete(t.clusterPhases, key)
+	delete(t.generations, key)
+
+	rolloutProgressGauge.DeleteLabelValues(key.Namespace, key.Name)
+
+	t.updateGauges()
+}
+
+func (t *propagationTracker) updateGauges() {
+	hubDeployablesGauge.Reset()
+
+	for _, phase := range t.phases {
+		hubDeployablesGauge.WithLabelValues(string(phase)).Inc()
+	}
+
+	clusterStatusesGauge.Reset()
+
+	for _, clusterPhases := range t.clusterPhases {
+		for phase, n := range clusterPhases {
+			clusterStatusesGauge.WithLabelValues(string(phase)).Add(float64(n))
+		}
+	}
+}
+
+func recordChildOperation(operation string, err error) {
+	childOperationsCounter.WithLabelValues(operation).Inc()
+
+	if err != nil {
+		childOperationErrorsCounter.WithLabelValues(operation).Inc()
+	}
+}
+
+// recordRolloutProgress sets the share of the clusters no longer held on the previous template.
+func recordRolloutProgress(instance *appv1alpha1.Deployable, pending int) {
+	total := len(instance.Status.PropagatedStatus)
+	if total == 0 {
+		return
+	}
+
+	rolloutProgressGauge.WithLabelValues(instance.GetNamespace(), instance.GetName()).Set(float64(total-pending) / float64(total))
+}
+
+func forgetRolloutProgress(instance *appv1alpha1.Deployable) {
+	rolloutProgressGauge.DeleteLabelValues(instance.GetNamespace(), instance.GetName())
+}
@@ -0,0 +1,132 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployable
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+	"github.com/stolostron/multicloud-operators-deployable/pkg/utils"
+)
+
+const (
+	defaultLocalSuffix = "-local"
+	defaultLocalPrefix = "local-"
+)
+
+// defaultLocalClusterNaming renames the template of the hub deployables that do not set spec.localClusterNaming.
+var defaultLocalClusterNaming = appv1alpha1.LocalClusterNaming{Policy: appv1alpha1.LocalClusterNamingSuffix}
+
+// SetDefaultLocalClusterNaming sets how the template of the hub deployables that do not set spec.localClusterNaming
+// is renamed for the local cluster.
+func SetDefaultLocalClusterNaming(policy, value string, kinds []string) error {
+	naming := appv1alpha1.LocalClusterNaming{Policy: appv1alpha1.LocalClusterNamingPolicy(policy), Value: value, Kinds: kinds}
+
+	switch naming.Policy {
+	case appv1alpha1.LocalClusterNamingSuffix, appv1alpha1.LocalClusterNamingPrefix,
+		appv1alpha1.LocalClusterNamingHash, appv1alpha1.LocalClusterNamingNone:
+	default:
+		return fmt.Errorf("unsupported local cluster naming policy %q, expected Suffix, Prefix, Hash or None", policy)
+	}
+
+	defaultLocalClusterNaming = naming
+
+	return nil
+}
+
+// localClusterNamingFor returns how the template of the deployable is renamed for the local cluster.
+func localClusterNamingFor(instance *appv1alpha1.Deployable) *appv1alpha1.LocalClusterNaming {
+	if instance.Spec.LocalClusterNaming != nil {
+		return instance.Spec.LocalClusterNaming
+	}
+
+	return defaultLocalClusterNaming.DeepCopy()
+}
+
+// localName returns the name of a resource of the template on the local cluster.
+func localName(naming *appv1alpha1.LocalClusterNaming, name, cluster string) string {
+	switch naming.Policy {
+	case appv1alpha1.LocalClusterNamingNone:
+		return name
+	case appv1alpha1.LocalClusterNamingPrefix:
+		if naming.Value == "" {
+			return defaultLocalPrefix + name
+		}
+
+		return naming.Value + name
+	case appv1alpha1.LocalClusterNamingHash:
+		sum := sha256.Sum256([]byte(cluster))
+
+		return name + "-" + hex.EncodeToString(sum[:])[:8]
+	default:
+		if naming.Value == "" {
+			return name + defaultLocalSuffix
+		}
+
+		return name + naming.Value
+	}
+}
+
+// renameForLocalCluster renames the template, or the items of a List template, of the kinds of the naming policy.
+func renameForLocalCluster(naming *appv1alpha1.LocalClusterNaming, tpl *runtime.RawExtension, cluster string) (*runtime.RawExtension, error) {
+	if tpl == nil || naming.Policy == appv1alpha1.LocalClusterNamingNone {
+		return tpl, nil
+	}
+
+	rename := func(obj *unstructured.Unstructured) {
+		if len(naming.Kinds) == 0 || sets.NewString(naming.Kinds...).Has(obj.GetKind()) {
+			obj.SetName(localName(naming, obj.GetName(), cluster))
+		}
+	}
+
+	var (
+		raw []byte
+		err error
+	)
+
+	if utils.IsListTemplate(tpl) {
+		list := &unstructured.UnstructuredList{}
+		if err = list.UnmarshalJSON(tpl.Raw); err != nil {
+			return nil, err
+		}
+
+		for i := range list.Items {
+			rename(&list.Items[i])
+		}
+
+		raw, err = list.MarshalJSON()
+	} else {
+		obj := &unstructured.Unstructured{}
+		if err = obj.UnmarshalJSON(tpl.Raw); err != nil {
+			return nil, err
+		}
+
+		rename(obj)
+
+		raw, err = obj.MarshalJSON()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &runtime.RawExtension{Raw: raw}, nil
+}
@@ -19,11 +19,10 @@ import (
 
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/klog"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
 
 	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
-	"github.com/stolostron/multicloud-operators-deployable/pkg/utils"
 	placementv1alpha1 "github.com/stolostron/multicloud-operators-placementrule/pkg/apis/apps/v1"
 	placementutils "github.com/stolostron/multicloud-operators-placementrule/pkg/utils"
 )
@@ -31,13 +30,8 @@ import (
 // Top priority: placementRef, ignore others
 // Next priority: clusterNames, ignore selector
 // Bottomline: Use label selector
-func (r *ReconcileDeployable) getClustersByPlacement(instance *appv1alpha1.Deployable) ([]types.NamespacedName, error) {
-	if klog.V(utils.QuiteLogLel) {
-		fnName := utils.GetFnName()
-		klog.Infof("Entering: %v()", fnName)
-
-		defer klog.Infof("Exiting: %v()", fnName)
-	}
+func (r *ReconcileDeployable) getClustersByPlacement(ctx context.Context, instance *appv1alpha1.Deployable) ([]types.NamespacedName, error) {
+	log := logf.FromContext(ctx)
 
 	var clusters []types.NamespacedName
 
@@ -47,11 +41,11 @@ func (r *ReconcileDeployable) getClustersByPlacement(instance *appv1alpha1.Deplo
 	// Next priority: clusterNames, ignore selector
 	// Bottomline: Use label selector
 	if instance.Spec.Placement.PlacementRef != nil {
-		clusters, err = r.getClustersFromPlacementRef(instance)
+		clusters, err = r.getClustersFromPlacementRef(ctx, instance)
 	} else {
 		clustermap, err := placementutils.PlaceByGenericPlacmentFields(r.Client, instance.Spec.Placement.GenericPlacementFields, instance)
 		if err != nil {
-			klog.Error("Failed to get clusters from generic fields with error: ", err)
+			log.Error(err, "Failed to get clusters from generic placement fields")
 			return nil, err
 		}
 		for _, cl := range clustermap {
@@ -60,22 +54,17 @@ func (r *ReconcileDeployable) getClustersByPlacement(instance *appv1alpha1.Deplo
 	}
 
 	if err != nil {
-		klog.Error("Failed in finding cluster namespaces. error: ", err)
+		log.Error(err, "Failed to find cluster namespaces")
 		return nil, err
 	}
 
-	klog.V(10).Info("Deploying to clusters", clusters)
+	log.V(logLevelTrace).Info("Placed deployable", "clusters", len(clusters))
 
 	return clusters, nil
 }
 
-func (r *ReconcileDeployable) getClustersFromPlacementRef(instance *appv1alpha1.Deployable) ([]types.NamespacedName, error) {
-	if klog.V(utils.QuiteLogLel) {
-		fnName := utils.GetFnName()
-		klog.Infof("Entering: %v()", fnName)
-
-		defer klog.Infof("Exiting: %v()", fnName)
-	}
+func (r *ReconcileDeployable) getClustersFromPlacementRef(ctx context.Context, instance *appv1alpha1.Deployable) ([]types.NamespacedName, error) {
+	log := logf.FromContext(ctx)
 
 	var clusters []types.NamespacedName
 	// only support mcm placementpolicy now
@@ -83,18 +72,18 @@ func (r *ReconcileDeployable) getClustersFromPlacementRef(instance *appv1alpha1.
 	pref := instance.Spec.Placement.PlacementRef
 
 	if len(pref.Kind) > 0 && pref.Kind != "PlacementRule" || len(pref.APIVersion) > 0 && pref.APIVersion != "apps.open-cluster-management.io/v1" {
-		klog.Warning("Unsupported placement reference:", instance.Spec.Placement.PlacementRef)
+		log.Info("Unsupported placement reference", "kind", pref.Kind, "apiVersion", pref.APIVersion, "placementRef", pref.Name)
 
 		return nil, nil
 	}
 
-	klog.V(10).Info("Referencing existing PlacementRule:", instance.Spec.Placement.PlacementRef, " in ", instance.GetNamespace())
+	log.V(logLevelTrace).Info("Referencing PlacementRule", "placementRef", pref.Name)
 
 	// get placementpolicy resource
-	err := r.Get(context.TODO(), client.ObjectKey{Name: instance.Spec.Placement.PlacementRef.Name, Namespace: instance.GetNamespace()}, pp)
+	err := r.Get(ctx, client.ObjectKey{Name: instance.Spec.Placement.PlacementRef.Name, Namespace: instance.GetNamespace()}, pp)
 	if err != nil {
 		if errors.IsNotFound(err) {
-			klog.Warning("Failed to locate placement reference", instance.Spec.Placement.PlacementRef)
+			log.Info("PlacementRule not found", "placementRef", pref.Name)
 
 			return nil, err
 		}
@@ -102,8 +91,6 @@ func (r *ReconcileDeployable) getClustersFromPlacementRef(instance *appv1alpha1.
 		return nil, err
 	}
 
-	klog.V(10).Info("Preparing cluster namespaces from ", pp)
-
 	for _, decision := range pp.Status.Decisions {
 		cluster := types.NamespacedName{Name: decision.ClusterName, Namespace: decision.ClusterNamespace}
 		clusters = append(clusters, cluster)
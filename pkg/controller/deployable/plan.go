@@ -0,0 +1,129 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployable
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+	"github.com/stolostron/multicloud-operators-deployable/pkg/utils"
+)
+
+// planDeployable records in status what the next propagation of the hub deployable would do with its backend, without
+// creating, updating or deleting any unit. The rolling update is planned on a copy, so the spec of the deployable is left as is.
+func (r *ReconcileDeployable) planDeployable(ctx context.Context, instance *appv1alpha1.Deployable, name appv1alpha1.PropagationBackend,
+	allunits map[appv1alpha1.PropagationBackend][]client.Object) error {
+	log := logf.FromContext(ctx)
+
+	// the events of the planned rolling update are dropped
+	dry := &ReconcileDeployable{Client: r.Client, eventRecorder: &utils.EventRecorder{EventRecorder: &record.FakeRecorder{}}}
+	backend := backends[name](dry)
+	planned := instance.DeepCopy()
+
+	if planned.Status.PropagatedStatus == nil {
+		planned.Status.PropagatedStatus = make(map[string]*appv1alpha1.ResourceUnitStatus)
+	}
+
+	refreshUnitStatus(planned, backend, allunits[name])
+
+	var clusters []types.NamespacedName
+
+	if len(planned.GetFinalizers()) == 0 && planned.Spec.Placement != nil {
+		if err := dry.rollingUpdate(ctx, planned); err != nil {
+			return err
+		}
+
+		var err error
+
+		if clusters, err = dry.getClustersByPlacement(ctx, planned); err != nil {
+			return err
+		}
+	}
+
+	byNamespace := unitsByNamespace(allunits[name])
+	hosting := types.NamespacedName{Name: planned.GetName(), Namespace: planned.GetNamespace()}
+	targeted, create, update, deferred := sets.NewString(), sets.NewString(), sets.NewString(), sets.NewString()
+
+	for i := range clusters {
+		cluster := clusters[i]
+		targeted.Insert(cluster.Name)
+		units := byNamespace[cluster.Namespace]
+
+		// a cluster whose template does not render keeps what it has
+		rendered, err := dry.renderLocalDeployable(ctx, cluster, hosting, planned)
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case dry.deferToMaintenanceWindow(ctx, backend, cluster, planned, rendered, units):
+			deferred.Insert(cluster.Name)
+		case len(units) == 0:
+			create.Insert(cluster.Name)
+		case backend.pending(ctx, cluster, planned, rendered, units):
+			update.Insert(cluster.Name)
+		}
+	}
+
+	// the units of the other backends expire
+	expired := sets.NewString()
+
+	for other, units := range allunits {
+		for _, unit := range units {
+			if unit.GetNamespace() == instance.GetNamespace() {
+				continue
+			}
+
+			if cluster := unitCluster(unit); other != name || !targeted.Has(cluster) {
+				expired.Insert(cluster)
+			}
+		}
+	}
+
+	plan := &appv1alpha1.DeployablePlan{
+		ObservedGeneration: instance.GetGeneration(),
+		Clusters:           planList(targeted),
+		Create:             planList(create),
+		Update:             planList(update),
+		Delete:             planList(expired),
+		Deferred:           planList(deferred),
+	}
+
+	if planned.GetAnnotations()[appv1alpha1.AnnotationRollingUpdateTarget] != "" {
+		plan.RolloutBatch = planList(create.Union(update))
+	}
+
+	log.V(logLevelDebug).Info("Planned dry run", "backend", name, "create", len(plan.Create), "update", len(plan.Update),
+		"delete", len(plan.Delete), "deferred", len(plan.Deferred))
+
+	instance.Status.Plan = plan
+
+	return nil
+}
+
+// planList sorts the clusters of the plan, leaving empty lists nil so that an unchanged plan does not update the status.
+func planList(clusters sets.String) []string {
+	if clusters.Len() == 0 {
+		return nil
+	}
+
+	return clusters.List()
+}
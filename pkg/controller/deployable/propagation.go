@@ -21,118 +21,162 @@ import (
 	"github.com/stolostron/multicloud-operators-deployable/pkg/utils"
 
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/klog"
 
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
-func (r *ReconcileDeployable) handleDeployable(instance *appv1alpha1.Deployable) error {
-	if klog.V(utils.QuiteLogLel) {
-		fnName := utils.GetFnName()
-		klog.Infof("Entering: %v()", fnName)
+func (r *ReconcileDeployable) handleDeployable(ctx context.Context, instance *appv1alpha1.Deployable) error {
+	log := logf.FromContext(ctx)
 
-		defer klog.Infof("Exiting: %v()", fnName)
-	}
+	ctx = withClusterEvents(ctx)
+	defer r.flushClusterEvents(ctx, instance)
 
 	// propagate subscription-pause label to its subscription template
 	err := utils.SetPauseLabelDplSubTpl(instance, instance)
 	if err != nil {
-		klog.Info("Failed to propagate pause label to new local deployable subscription template. err:", err)
+		log.Error(err, "Failed to propagate pause label to the subscription template")
 		return err
 	}
 
-	// try to find children
-	children, err := r.getDeployableFamily(instance)
+	// try to find the units propagated by each backend
+	spanctx, span := startSpan(ctx, "listUnits", instance)
+	allunits, err := r.listUnits(spanctx, instance)
+	endSpan(span, err)
 
 	if err != nil {
-		klog.Error("Failed to get children deployable with err:", err)
+		log.Error(err, "Failed to list propagated units")
+		return err
 	}
 
-	// actively delete children deployables when change from hub to local only
-	if len(instance.GetFinalizers()) > 0 || instance.Spec.Placement == nil {
-		for _, dpl := range children {
-			dplkey := types.NamespacedName{Namespace: dpl.GetNamespace(), Name: dpl.GetName()}
+	name := backendFor(instance)
+	backend := backends[name](r)
+	units := allunits[name]
 
-			klog.V(5).Info("As hub, deleting ", dpl.GetNamespace(), "/", dpl.GetName())
+	// a dry run only plans the propagation
+	if instance.Spec.DryRun {
+		return r.planDeployable(ctx, instance, name, allunits)
+	}
 
-			if dpl.Namespace != instance.Namespace {
-				err = r.Delete(context.TODO(), dpl)
+	instance.Status.Plan = nil
 
-				addtionalMsg := "Delete propogated Deployable " + dplkey.String()
-				r.eventRecorder.RecordEvent(instance, "Delete", addtionalMsg, err)
+	// a suspended deployable only refreshes the status of its children
+	if r.checkSuspended(ctx, instance) {
+		if instance.Spec.Placement != nil {
+			if instance.Status.PropagatedStatus == nil {
+				instance.Status.PropagatedStatus = make(map[string]*appv1alpha1.ResourceUnitStatus)
+			}
+
+			refreshUnitStatus(instance, backend, units)
+		}
+
+		return nil
+	}
+
+	// publish into the channels, with or without placement
+	if len(instance.Spec.Channels) > 0 || instance.Status.Channels != nil {
+		if err := r.publishChannels(ctx, instance); err != nil {
+			log.Error(err, "Failed to publish into channels")
+			return err
+		}
+	}
+
+	// actively delete children when change from hub to local only
+	if len(instance.GetFinalizers()) > 0 || instance.Spec.Placement == nil {
+		for other, listed := range allunits {
+			for _, unit := range listed {
+				log.V(logLevelTrace).Info("Deleting child", "child", unit.GetNamespace()+"/"+unit.GetName())
+
+				if unit.GetNamespace() != instance.GetNamespace() {
+					_ = backends[other](r).delete(ctx, instance, unit)
+				}
 			}
 		}
 
 		instance.Status.PropagatedStatus = nil
+		meta.RemoveStatusCondition(&instance.Status.Conditions, appv1alpha1.ConditionBackendHandover)
 
 		return nil
 	}
 
+	// a chart that does not render is not propagated, the clusters keep what they have
+	if err := setChartStatus(ctx, instance); err != nil {
+		log.Error(err, "Failed to render helm chart")
+		return err
+	}
+
 	// hub reconcile always has in cluster status
 	if instance.Status.PropagatedStatus == nil {
 		instance.Status.PropagatedStatus = make(map[string]*appv1alpha1.ResourceUnitStatus)
 	}
 
-	// prepare map to delete expired children
-	expireddeployablemap := make(map[string]*appv1alpha1.Deployable)
-
-	for _, dpl := range children {
-		expireddeployablemap[getDeployableTrueKey(dpl)] = dpl
-
-		if utils.GetClusterFromResourceObject(dpl).Name != "" {
-			instance.Status.PropagatedStatus[utils.GetClusterFromResourceObject(dpl).Name] = dpl.Status.ResourceUnitStatus.DeepCopy()
-			klog.V(5).Infof("child dpl cluster name: %v, unit status: %#v", utils.GetClusterFromResourceObject(dpl).Name, dpl.Status.ResourceUnitStatus.DeepCopy())
-		}
-	}
-	// instance itself does not expire anyway
-	delete(expireddeployablemap, getDeployableTrueKey(instance))
-	klog.V(1).Info("Existing deployables to check expiration:", expireddeployablemap)
+	refreshUnitStatus(instance, backend, units)
+	log.V(logLevelDebug).Info("Checking children for expiration", "backend", name, "children", len(units))
 
-	err = r.rollingUpdate(instance)
+	spanctx, span = startSpan(ctx, "rollingUpdate", instance)
+	err = r.rollingUpdate(spanctx, instance)
+	endSpan(span, err)
 
 	if err != nil {
-		klog.Error("Error in rolling update:", err)
+		log.Error(err, "Failed in rolling update")
 		return err
 	}
 
 	// // Generate deployable for managed clusters
-	clusters, err := r.getClustersByPlacement(instance)
+	spanctx, span = startSpan(ctx, "getClustersByPlacement", instance)
+	clusters, err := r.getClustersByPlacement(spanctx, instance)
+	endSpan(span, err)
 
 	if err != nil {
-		klog.Error("Error in getting clusters:", err)
+		log.Error(err, "Failed to get clusters by placement")
 		return err
 	}
 
 	// propagate template
-	expireddeployablemap, err = r.propagateDeployables(clusters, instance, expireddeployablemap)
+	spanctx, span = startSpan(ctx, "propagateUnits", instance)
+	expired, err := r.propagateUnits(spanctx, backend, clusters, instance, units)
+	endSpan(span, err)
+
 	if err != nil {
-		klog.Error("Error in propagating to clusters:", err)
+		log.Error(err, "Failed to propagate to clusters")
 		return err
 	}
 
-	// delete expired deployables
-	klog.V(5).Info("Expired deployables map:", expireddeployablemap)
+	// the resources of the child deployables are left in place in the clusters for the ManifestWorks to adopt
+	if name == appv1alpha1.PropagationBackendManifestWork && len(allunits[appv1alpha1.PropagationBackendDeployable]) > 0 {
+		allunits[appv1alpha1.PropagationBackendDeployable] = r.keepHandedOverResources(ctx, clusters,
+			allunits[appv1alpha1.PropagationBackendDeployable])
+	}
 
-	for _, dpl := range expireddeployablemap {
-		delete(instance.Status.PropagatedStatus, utils.GetClusterFromResourceObject(dpl).Name)
-		dplanno := dpl.GetAnnotations()
+	// delete expired units, the units of the other backends expire once the backend is available in their cluster
+	expiredUnits := handOverUnits(instance, name, backend, clusters, allunits)
+	expiredUnits[name] = expired
+	expiredCount := 0
 
-		if dplanno == nil && dplanno[appv1alpha1.AnnotationShared] == "true" {
-			continue
-		}
+	for _, listed := range expiredUnits {
+		expiredCount += len(listed)
+	}
+
+	log.V(logLevelDebug).Info("Deleting expired children", "children", expiredCount)
+	expiredChildrenHistogram.Observe(float64(expiredCount))
 
-		dplkey := types.NamespacedName{Namespace: dpl.GetNamespace(), Name: dpl.GetName()}
-		err = r.Delete(context.TODO(), dpl)
+	spanctx, span = startSpan(ctx, "deleteExpiredUnits", instance)
 
-		addtionalMsg := "Delete Expired Deployable " + dplkey.String()
-		r.eventRecorder.RecordEvent(instance, "Delete", addtionalMsg, err)
+	for other, listed := range expiredUnits {
+		for _, unit := range listed {
+			err = backends[other](r).delete(spanctx, instance, unit)
 
-		if err != nil {
-			klog.Error("Failed to delete local deployable ", dpl.GetNamespace(), "/", dpl.GetName(), ":", err, "skipping")
+			if err != nil {
+				log.Error(err, "Failed to delete expired child, skipping", "child", unit.GetNamespace()+"/"+unit.GetName())
+				span.RecordError(err)
+			}
 		}
 	}
 
+	span.End()
+
 	//remove expired clusters from instance status targetClusters list
 	clusterStatusMap := instance.Status.PropagatedStatus
 	for clusterName := range clusterStatusMap {
@@ -142,32 +186,41 @@ func (r *ReconcileDeployable) handleDeployable(instance *appv1alpha1.Deployable)
 	}
 
 	// delete invalid overrides generated by rolling update
-	r.validateOverridesForRollingUpdate(instance)
+	r.validateOverridesForRollingUpdate(ctx, instance)
 
 	instance.Status.Phase = appv1alpha1.DeployablePropagated
 	instance.Status.Reason = ""
 
-	klog.V(5).Infof("Exit hub func with err: %v, and instance status: %#v", err, instance.Status)
+	log.V(logLevelTrace).Info("Handled hub deployable", "phase", instance.Status.Phase, "clusters", propagatedPhases(instance.Status.PropagatedStatus))
 
 	return err
 }
 
-func (r *ReconcileDeployable) getDeployableFamily(instance *appv1alpha1.Deployable) ([]*appv1alpha1.Deployable, error) {
-	if klog.V(utils.QuiteLogLel) {
-		fnName := utils.GetFnName()
-		klog.Infof("Entering: %v()", fnName)
+// refreshUnitStatus refreshes the cluster status of the hub deployable from the units of its backend.
+func refreshUnitStatus(instance *appv1alpha1.Deployable, backend propagationBackend, units []client.Object) {
+	for _, unit := range units {
+		cluster := utils.GetClusterFromResourceObject(unit)
+		if cluster == nil || cluster.Name == "" {
+			continue
+		}
 
-		defer klog.Infof("Exiting: %v()", fnName)
+		if refreshed := backend.status(unit); refreshed != nil {
+			keepDriftedCondition(instance.Status.PropagatedStatus[cluster.Name], refreshed)
+			instance.Status.PropagatedStatus[cluster.Name] = refreshed
+		}
 	}
+}
+
+func (r *ReconcileDeployable) getDeployableFamily(ctx context.Context, instance *appv1alpha1.Deployable) ([]*appv1alpha1.Deployable, error) {
 	// get all existing deployables
 	exlist := &appv1alpha1.DeployableList{}
 	exlabel := make(map[string]string)
 	// Label does not support "/" for NamespacedName, let get by name first and filter by annotation later
 	exlabel[appv1alpha1.PropertyHostingDeployableName] = instance.GetName()
-	err := r.List(context.TODO(), exlist, client.MatchingLabels(exlabel))
+	err := r.List(ctx, exlist, client.MatchingLabels(exlabel))
 
 	if err != nil && !errors.IsNotFound(err) {
-		klog.Error("Trying to list existing deployabe ", instance.GetNamespace(), "/", instance.GetName(), " with error:", err)
+		logf.FromContext(ctx).Error(err, "Failed to list children deployables", "hosting", instance.GetNamespace()+"/"+instance.GetName())
 		return nil, err
 	}
 
@@ -191,13 +244,6 @@ func (r *ReconcileDeployable) getDeployableFamily(instance *appv1alpha1.Deployab
 }
 
 func getDeployableTrueKey(dpl *appv1alpha1.Deployable) string {
-	if klog.V(utils.QuiteLogLel) {
-		fnName := utils.GetFnName()
-		klog.Infof("Entering: %v()", fnName)
-
-		defer klog.Infof("Exiting: %v()", fnName)
-	}
-
 	objkey := types.NamespacedName{Name: dpl.Name, Namespace: dpl.Namespace}
 
 	if dpl.GetGenerateName() != "" {
@@ -208,13 +254,15 @@ func getDeployableTrueKey(dpl *appv1alpha1.Deployable) string {
 }
 
 // validateDeployables validate parent deployable exist or not. The deployables with empty parent will be removed.
-func (r *ReconcileDeployable) validateDeployables() error {
+func (r *ReconcileDeployable) validateDeployables(ctx context.Context) error {
+	log := logf.FromContext(ctx)
+
 	deployablelist := &appv1alpha1.DeployableList{}
 	listopts := &client.ListOptions{}
-	err := r.List(context.TODO(), deployablelist, listopts)
+	err := r.List(ctx, deployablelist, listopts)
 
 	if err != nil {
-		klog.Error("Failed to obtain deployable list")
+		log.Error(err, "Failed to list deployables")
 		return err
 	}
 
@@ -222,7 +270,6 @@ func (r *ReconcileDeployable) validateDeployables() error {
 	deployableMap := make(map[string]*appv1alpha1.Deployable)
 	for _, dpl := range deployablelist.Items {
 		deployableMap[(types.NamespacedName{Name: dpl.GetName(), Namespace: dpl.GetNamespace()}).String()] = dpl.DeepCopy()
-		klog.V(5).Info("validateDeployables() dpl: ", dpl.GetNamespace(), " ", dpl.GetName())
 	}
 
 	// check each deployable for parents
@@ -243,8 +290,6 @@ func (r *ReconcileDeployable) validateDeployables() error {
 				break
 			}
 
-			klog.V(5).Infof("obj: %#v, hosting deployable: %#v", obj.GetNamespace()+"/"+obj.GetName(), host)
-
 			ok := false
 			hostDpl, ok = deployableMap[host.String()]
 
@@ -256,13 +301,19 @@ func (r *ReconcileDeployable) validateDeployables() error {
 				// parent is gone, delete the deployable from map and from kube
 				delete(deployableMap, k)
 
-				err = r.Delete(context.TODO(), obj)
-				klog.V(5).Infof("parent is gone, delete the deployable from map and from kube: host: %#v, k: %#v, v: %#v, err: %#v", host, k, v, err)
+				err = r.Delete(ctx, obj)
+				recordChildOperation(childOperationDelete, err)
+				log.Info("Deleted deployable whose hosting deployable is gone", "deployable", k, "hosting", host.String(), "error", err)
 
 				break
 			}
 		}
 	}
 
+	if err := r.sweepManifestWorks(ctx, deployableMap); err != nil {
+		log.Error(err, "Failed to delete the manifestworks whose hosting deployable is gone")
+		return err
+	}
+
 	return nil
 }
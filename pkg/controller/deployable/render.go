@@ -0,0 +1,64 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployable
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+	"github.com/stolostron/multicloud-operators-deployable/pkg/utils"
+)
+
+// RenderChildren renders the child deployables propagated for the hub deployable, one per cluster selected by its placement.
+// It only reads the managed clusters and placement rules through c, so it can run against an in-memory client.
+// Rolling updates, maintenance windows and dependencies are not applied, and a cluster whose template does not render fails.
+func RenderChildren(ctx context.Context, c client.Client, instance *appv1alpha1.Deployable) ([]*appv1alpha1.Deployable, error) {
+	r := &ReconcileDeployable{Client: c}
+	instance = instance.DeepCopy()
+
+	if err := utils.SetPauseLabelDplSubTpl(instance, instance); err != nil {
+		return nil, err
+	}
+
+	if instance.Spec.Placement == nil {
+		return nil, nil
+	}
+
+	clusters, err := r.getClustersByPlacement(ctx, instance)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].Name < clusters[j].Name })
+
+	hosting := types.NamespacedName{Name: instance.GetName(), Namespace: instance.GetNamespace()}
+	children := make([]*appv1alpha1.Deployable, 0, len(clusters))
+
+	for i := range clusters {
+		child, err := r.renderLocalDeployable(ctx, clusters[i], hosting, instance)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: %w", clusters[i].Name, err)
+		}
+
+		children = append(children, child)
+	}
+
+	return children, nil
+}
@@ -16,35 +16,36 @@ package deployable
 
 import (
 	"context"
+	"fmt"
 	"reflect"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
 	"github.com/stolostron/multicloud-operators-deployable/pkg/utils"
 
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/klog"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
-func (r *ReconcileDeployable) rollingUpdate(instance *appv1alpha1.Deployable) error {
-	if klog.V(utils.QuiteLogLel) {
-		fnName := utils.GetFnName()
-		klog.Infof("Entering: %v()", fnName)
-
-		defer klog.Infof("Exiting: %v()", fnName)
-	}
-
-	klog.V(1).Info("Rolling Updating ", instance.GetName())
+func (r *ReconcileDeployable) rollingUpdate(ctx context.Context, instance *appv1alpha1.Deployable) error {
+	log := logf.FromContext(ctx)
 
 	annotations := instance.GetAnnotations()
 
 	if annotations == nil || annotations[appv1alpha1.AnnotationRollingUpdateTarget] == "" {
-		klog.V(1).Info("Empty annotation or No rolling update target in annotations", annotations)
+		log.V(logLevelTrace).Info("No rolling update target")
+		forgetRolloutProgress(instance)
+
 		return nil
 	}
 
 	if len(instance.Status.PropagatedStatus) == 0 {
-		klog.V(1).Info(" No propagated clusters for rolling update", annotations)
+		log.V(logLevelDebug).Info("No propagated clusters for rolling update", "target", annotations[appv1alpha1.AnnotationRollingUpdateTarget])
 		return nil
 	}
 
@@ -55,17 +56,18 @@ func (r *ReconcileDeployable) rollingUpdate(instance *appv1alpha1.Deployable) er
 	}
 
 	maxunav = (len(instance.Status.PropagatedStatus)*maxunav + 99) / 100
-	klog.V(1).Info("ongoing rolling update to ", annotations[appv1alpha1.AnnotationRollingUpdateTarget], " with max ", maxunav, " unavaialble clusters")
+	log = log.WithValues("target", annotations[appv1alpha1.AnnotationRollingUpdateTarget])
+	log.V(logLevelDebug).Info("Rolling update in progress", "maxUnavailable", maxunav)
 
 	targetdpl := &appv1alpha1.Deployable{}
-	err = r.Get(context.TODO(),
+	err = r.Get(ctx,
 		types.NamespacedName{
 			Name:      annotations[appv1alpha1.AnnotationRollingUpdateTarget],
 			Namespace: instance.Namespace,
 		}, targetdpl)
 
 	if err != nil {
-		klog.Info("Failed to find rolling update target", annotations[appv1alpha1.AnnotationRollingUpdateTarget])
+		log.Error(err, "Failed to find rolling update target")
 
 		return err
 	}
@@ -73,13 +75,45 @@ func (r *ReconcileDeployable) rollingUpdate(instance *appv1alpha1.Deployable) er
 	// propagate subscription-pause label to rolling update target deployable subscription template
 	err = utils.SetPauseLabelDplSubTpl(instance, targetdpl)
 	if err != nil {
-		klog.Info("Failed to propagate pause label to target deployable subscription template. err:", err)
+		log.Error(err, "Failed to propagate pause label to the target subscription template")
 		return err
 	}
 
 	//it is only triggered in the initial rolling update.
 	if !reflect.DeepEqual(instance.Spec.Template, targetdpl.Spec.Template) {
-		klog.V(1).Info("Initialize rolling update to ", annotations[appv1alpha1.AnnotationRollingUpdateTarget])
+		log.Info("Starting rolling update")
+
+		// a new target restarts from the template in use before the previous rolling update, if any
+		rus := instance.Status.RollingUpdate
+		if rus != nil && rus.PreviousTemplate != nil && isRollingUpdateInProgress(instance) {
+			instance.Spec.Template = rus.PreviousTemplate.DeepCopy()
+			instance.Spec.Overrides = nil
+
+			for _, ov := range rus.PreviousOverrides {
+				instance.Spec.Overrides = append(instance.Spec.Overrides, *(ov.DeepCopy()))
+			}
+		} else {
+			rus = &appv1alpha1.RollingUpdateStatus{
+				PreviousTemplate: instance.Spec.Template.DeepCopy(),
+			}
+
+			for _, ov := range instance.Spec.Overrides {
+				rus.PreviousOverrides = append(rus.PreviousOverrides, *(ov.DeepCopy()))
+			}
+		}
+
+		now := metav1.Now()
+		rus.Target = targetdpl.GetName()
+		rus.StartTime = &now
+		rus.ResumedTime = nil
+		rus.FailedClusters = nil
+		rus.Stages = nil
+		rus.Approvals = nil
+		instance.Status.RollingUpdate = rus
+
+		setRollingUpdateCondition(instance, metav1.ConditionTrue, appv1alpha1.ReasonRollingUpdateProgressing,
+			"Rolling update to "+targetdpl.GetName()+" started")
+		r.eventRecorder.RecordEvent(instance, appv1alpha1.EventReasonRolloutStarted, "Rolling update to "+targetdpl.GetName()+" started", nil)
 
 		ov := appv1alpha1.Overrides{}
 
@@ -98,13 +132,20 @@ func (r *ReconcileDeployable) rollingUpdate(instance *appv1alpha1.Deployable) er
 		// existing overrides are rolling out anyway
 		maxunav -= len(instance.Spec.Overrides)
 
+		var groupovs []appv1alpha1.Overrides
+
 		for _, ov := range targetdpl.Spec.Overrides {
+			if isClusterGroupOverride(ov) {
+				groupovs = append(groupovs, *(ov.DeepCopy()))
+				continue
+			}
+
 			covmap[ov.ClusterName] = *(ov.DeepCopy())
 		}
 
-		maxunav -= len(targetdpl.Spec.Overrides)
+		maxunav -= len(targetdpl.Spec.Overrides) - len(groupovs)
 
-		instance.Spec.Overrides = nil
+		instance.Spec.Overrides = groupovs
 		for _, ov := range covmap {
 			instance.Spec.Overrides = append(instance.Spec.Overrides, *(ov.DeepCopy()))
 		}
@@ -112,25 +153,49 @@ func (r *ReconcileDeployable) rollingUpdate(instance *appv1alpha1.Deployable) er
 		targetdpl.Spec.Template.DeepCopyInto(instance.Spec.Template)
 	}
 
+	if stop := r.checkRollingUpdateFailure(ctx, instance, targetdpl); stop {
+		return nil
+	}
+
 	for _, cs := range instance.Status.PropagatedStatus {
 		if cs.Phase != appv1alpha1.DeployableDeployed {
 			maxunav--
 		}
 	}
 
+	var rollable map[string]struct{}
+
+	staged := isStagedRollingUpdate(instance)
+	if staged {
+		rollable = r.advanceRolloutStages(ctx, instance, getRollingUpdatePendingClusters(instance, targetdpl))
+	}
+
 	var targetovs []appv1alpha1.Overrides
 
 	ovmap := make(map[string]*appv1alpha1.Overrides)
 
 	for _, tov := range targetdpl.Spec.Overrides {
-		ovmap[tov.ClusterName] = tov.DeepCopy()
+		if !isClusterGroupOverride(tov) {
+			ovmap[tov.ClusterName] = tov.DeepCopy()
+		}
 	}
 
 	for _, ov := range instance.Spec.Overrides {
+		// the overlays of cluster groups come with the target
+		if isClusterGroupOverride(ov) {
+			targetovs = append(targetovs, *(ov.DeepCopy()))
+			continue
+		}
+
 		// ensure desired overrides are aligned
+		_, inStage := rollable[ov.ClusterName]
+
 		if cov, ok := ovmap[ov.ClusterName]; ok {
 			targetovs = append(targetovs, *cov)
-		} else if maxunav > 0 {
+		} else if staged && inStage {
+			// roll 1 more in the current stage
+			continue
+		} else if !staged && maxunav > 0 {
 			// roll 1 more
 			maxunav--
 		} else {
@@ -147,32 +212,296 @@ func (r *ReconcileDeployable) rollingUpdate(instance *appv1alpha1.Deployable) er
 		instance.Spec.Overrides = append(instance.Spec.Overrides, *(cov.DeepCopy()))
 	}
 
-	klog.V(1).Info("Rolling update exit with overrides: ", instance.Spec.Overrides)
+	pending := getRollingUpdatePendingClusters(instance, targetdpl)
+	recordRolloutProgress(instance, len(pending))
+
+	if len(pending) == 0 && isRollingUpdateInProgress(instance) && isRolloutStagesCompleted(instance) {
+		deployed := true
+
+		for _, cs := range instance.Status.PropagatedStatus {
+			if cs.Phase != appv1alpha1.DeployableDeployed {
+				deployed = false
+				break
+			}
+		}
+
+		if deployed {
+			setRollingUpdateCondition(instance, metav1.ConditionTrue, appv1alpha1.ReasonRollingUpdateCompleted,
+				"Rolling update to "+targetdpl.GetName()+" completed")
+			r.eventRecorder.RecordEvent(instance, appv1alpha1.EventReasonRolloutCompleted, "Rolling update to "+targetdpl.GetName()+" completed", nil)
+		}
+	}
+
+	log.V(logLevelDebug).Info("Rolling update reconciled", "pendingClusters", len(pending))
 
 	return nil
 }
 
-func (r *ReconcileDeployable) validateOverridesForRollingUpdate(instance *appv1alpha1.Deployable) {
-	if klog.V(utils.QuiteLogLel) {
-		fnName := utils.GetFnName()
-		klog.Infof("Entering: %v()", fnName)
+// checkRollingUpdateFailure counts the updated clusters that failed, or stayed undeployed longer than the progress
+// deadline, and halts or rolls back the rolling update once they exceed the failure budget.
+// It returns true if no more clusters should be rolled in this reconcile.
+func (r *ReconcileDeployable) checkRollingUpdateFailure(ctx context.Context, instance, targetdpl *appv1alpha1.Deployable) bool {
+	log := logf.FromContext(ctx)
 
-		defer klog.Infof("Exiting: %v()", fnName)
+	cond := meta.FindStatusCondition(instance.Status.Conditions, appv1alpha1.ConditionProgressing)
+	if cond != nil && cond.Reason == appv1alpha1.ReasonRollingUpdateHalted {
+		log.V(logLevelDebug).Info("Rolling update is halted")
+		return true
 	}
 
-	klog.V(1).Info("Rolling update validation started with overrides: ", instance.Spec.Overrides, "and status ", instance.Status.PropagatedStatus)
+	annotations := instance.GetAnnotations()
 
-	var allov []appv1alpha1.Overrides
+	budgetstr := annotations[appv1alpha1.AnnotationRollingUpdateMaxFailure]
+	if budgetstr == "" {
+		meta.RemoveStatusCondition(&instance.Status.Conditions, appv1alpha1.ConditionInvalidFailureBudget)
+		return false
+	}
+
+	budget, err := parseFailureBudget(budgetstr, len(instance.Status.PropagatedStatus))
+	if err != nil {
+		log.Error(err, "Invalid rolling update failure budget", "maxFailure", budgetstr)
+		meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+			Type:               appv1alpha1.ConditionInvalidFailureBudget,
+			Status:             metav1.ConditionTrue,
+			Reason:             appv1alpha1.ReasonFailureBudgetRejected,
+			Message:            "Failure budget " + budgetstr + " is rejected, the rolling update has no failure budget: " + err.Error(),
+			ObservedGeneration: instance.GetGeneration(),
+		})
+
+		return false
+	}
+
+	meta.RemoveStatusCondition(&instance.Status.Conditions, appv1alpha1.ConditionInvalidFailureBudget)
+
+	var deadline time.Duration
+
+	if dstr := annotations[appv1alpha1.AnnotationRollingUpdateProgressDeadline]; dstr != "" {
+		deadline, err = time.ParseDuration(dstr)
+		if err != nil {
+			log.Error(err, "Invalid rolling update progress deadline", "progressDeadline", dstr)
+		}
+	}
+
+	starttime := rollingUpdateProgressStart(instance.Status.RollingUpdate)
+
+	pending := getRollingUpdatePendingClusters(instance, targetdpl)
+
+	var failed []string
+
+	for cluster, cs := range instance.Status.PropagatedStatus {
+		if _, ok := pending[cluster]; ok {
+			continue
+		}
+
+		if isRollingUpdateClusterFailed(cs, deadline, starttime) {
+			failed = append(failed, cluster)
+		}
+	}
+
+	sort.Strings(failed)
+
+	if instance.Status.RollingUpdate != nil {
+		instance.Status.RollingUpdate.FailedClusters = failed
+	}
+
+	if len(failed) <= budget {
+		return false
+	}
+
+	msg := fmt.Sprintf("%d clusters failed in rolling update to %s, exceeding failure budget %s: %s",
+		len(failed), targetdpl.GetName(), budgetstr, strings.Join(failed, ","))
+
+	policy := appv1alpha1.RollingUpdateFailurePolicy(annotations[appv1alpha1.AnnotationRollingUpdateFailurePolicy])
+
+	reason := appv1alpha1.EventReasonRolloutHalted
+
+	if strings.EqualFold(string(policy), string(appv1alpha1.RollingUpdateFailurePolicyRollback)) {
+		r.rollbackRollingUpdate(ctx, instance)
+
+		msg += ", rolled back"
+		reason = appv1alpha1.EventReasonRolloutRolledBack
+		setRollingUpdateCondition(instance, metav1.ConditionFalse, appv1alpha1.ReasonRollingUpdateRolledBack, msg)
+	} else {
+		msg += ", halted"
+		setRollingUpdateCondition(instance, metav1.ConditionFalse, appv1alpha1.ReasonRollingUpdateHalted, msg)
+	}
+
+	log.Info("Rolling update failure budget exceeded", "failedClusters", failed, "policy", policy)
+	r.eventRecorder.RecordEvent(instance, reason, msg, fmt.Errorf("failure budget exceeded"))
+
+	return true
+}
+
+// rollbackRollingUpdate restores the template and overrides saved when the rolling update started,
+// and removes the rolling update target so the rolling update is not started again.
+func (r *ReconcileDeployable) rollbackRollingUpdate(ctx context.Context, instance *appv1alpha1.Deployable) {
+	if !utils.RollbackRollingUpdate(instance) {
+		logf.FromContext(ctx).Info("No previous template to roll back to")
+	}
+}
+
+// getRollingUpdatePendingClusters returns the clusters still held on the previous template by rolling update overrides.
+func getRollingUpdatePendingClusters(instance, targetdpl *appv1alpha1.Deployable) map[string]struct{} {
+	tovmap := make(map[string]struct{})
+	for _, tov := range targetdpl.Spec.Overrides {
+		tovmap[tov.ClusterName] = struct{}{}
+	}
+
+	pending := make(map[string]struct{})
 
 	for _, ov := range instance.Spec.Overrides {
-		klog.V(5).Info("validating overrides: ", ov)
+		if isClusterGroupOverride(ov) {
+			continue
+		}
+
+		if _, ok := tovmap[ov.ClusterName]; !ok {
+			pending[ov.ClusterName] = struct{}{}
+		}
+	}
+
+	return pending
+}
+
+// rollingUpdateProgressStart returns when the progress deadline of the rolling update starts, when it started or when
+// it was last resumed.
+func rollingUpdateProgressStart(rus *appv1alpha1.RollingUpdateStatus) *metav1.Time {
+	if rus == nil {
+		return nil
+	}
+
+	if rus.ResumedTime != nil && (rus.StartTime == nil || rus.ResumedTime.After(rus.StartTime.Time)) {
+		return rus.ResumedTime
+	}
+
+	return rus.StartTime
+}
+
+func isRollingUpdateClusterFailed(cs *appv1alpha1.ResourceUnitStatus, deadline time.Duration, starttime *metav1.Time) bool {
+	if cs == nil {
+		return false
+	}
+
+	if cs.Phase == appv1alpha1.DeployableFailed {
+		return true
+	}
+
+	if deadline <= 0 || cs.Phase == appv1alpha1.DeployableDeployed {
+		return false
+	}
+
+	since := starttime
+	if cs.LastUpdateTime != nil && (since == nil || cs.LastUpdateTime.After(since.Time)) {
+		since = cs.LastUpdateTime
+	}
+
+	return since != nil && time.Since(since.Time) > deadline
+}
+
+// parseFailureBudget converts a count, or a percentage of total clusters like "10%", into a number of clusters.
+// Negative counts and percentages outside 0-100 are rejected.
+func parseFailureBudget(budget string, total int) (int, error) {
+	if strings.HasSuffix(budget, "%") {
+		pct, err := strconv.Atoi(strings.TrimSuffix(budget, "%"))
+		if err != nil {
+			return 0, err
+		}
+
+		if pct < 0 || pct > 100 {
+			return 0, fmt.Errorf("percentage %d%% is not between 0%% and 100%%", pct)
+		}
+
+		return total * pct / 100, nil
+	}
+
+	count, err := strconv.Atoi(budget)
+	if err != nil {
+		return 0, err
+	}
 
-		if _, ok := instance.Status.PropagatedStatus[ov.ClusterName]; ok {
+	if count < 0 {
+		return 0, fmt.Errorf("count %d is negative", count)
+	}
+
+	return count, nil
+}
+
+func isRollingUpdateInProgress(instance *appv1alpha1.Deployable) bool {
+	cond := meta.FindStatusCondition(instance.Status.Conditions, appv1alpha1.ConditionProgressing)
+
+	return cond != nil && cond.Status == metav1.ConditionTrue && cond.Reason == appv1alpha1.ReasonRollingUpdateProgressing
+}
+
+func setRollingUpdateCondition(instance *appv1alpha1.Deployable, status metav1.ConditionStatus, reason, msg string) {
+	meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+		Type:               appv1alpha1.ConditionProgressing,
+		Status:             status,
+		Reason:             reason,
+		Message:            msg,
+		ObservedGeneration: instance.GetGeneration(),
+	})
+}
+
+// rollingUpdateRequeueAfter returns when the progress deadline of the ongoing rolling update needs to be checked again,
+// for the clusters still in progress whose deadline is ahead, never while suspended.
+func rollingUpdateRequeueAfter(instance *appv1alpha1.Deployable) time.Duration {
+	dstr := instance.GetAnnotations()[appv1alpha1.AnnotationRollingUpdateProgressDeadline]
+	if dstr == "" || instance.Status.RollingUpdate == nil || !isRollingUpdateInProgress(instance) || isSuspended(instance) {
+		return 0
+	}
+
+	deadline, err := time.ParseDuration(dstr)
+	if err != nil || deadline <= 0 {
+		return 0
+	}
+
+	var next time.Duration
+
+	for _, cs := range instance.Status.PropagatedStatus {
+		if cs == nil || cs.Phase == appv1alpha1.DeployableDeployed || cs.Phase == appv1alpha1.DeployableFailed {
+			continue
+		}
+
+		since := rollingUpdateProgressStart(instance.Status.RollingUpdate)
+		if cs.LastUpdateTime != nil && (since == nil || cs.LastUpdateTime.After(since.Time)) {
+			since = cs.LastUpdateTime
+		}
+
+		if since == nil {
+			continue
+		}
+
+		// a deadline already passed was checked by the reconcile it requeued
+		until := time.Until(since.Add(deadline))
+		if until <= 0 {
+			continue
+		}
+
+		if wait := until + time.Second; next == 0 || wait < next {
+			next = wait
+		}
+	}
+
+	return next
+}
+
+func (r *ReconcileDeployable) validateOverridesForRollingUpdate(ctx context.Context, instance *appv1alpha1.Deployable) {
+	var allov []appv1alpha1.Overrides
+
+	for _, ov := range instance.Spec.Overrides {
+		if _, ok := instance.Status.PropagatedStatus[ov.ClusterName]; ok || isClusterGroupOverride(ov) {
 			allov = append(allov, *(ov.DeepCopy()))
 		}
 	}
 
+	if len(allov) != len(instance.Spec.Overrides) {
+		logf.FromContext(ctx).V(logLevelDebug).Info("Removed overrides of clusters no longer propagated",
+			"overrides", len(allov), "removed", len(instance.Spec.Overrides)-len(allov))
+	}
+
 	instance.Spec.Overrides = allov
+}
 
-	klog.V(5).Info("Rolling update validated overrides: ", instance.Spec.Overrides)
+// isClusterGroupOverride tells if the override applies to the clusters selected by labels rather than to a named cluster.
+func isClusterGroupOverride(ov appv1alpha1.Overrides) bool {
+	return ov.ClusterSelector != nil
 }
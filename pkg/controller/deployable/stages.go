@@ -0,0 +1,363 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployable
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	spokeClusterV1 "github.com/open-cluster-management/api/cluster/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+	"github.com/stolostron/multicloud-operators-deployable/pkg/utils"
+)
+
+// remainingStageName names the implicit last stage taking the clusters not selected by any configured stage.
+const remainingStageName = "remaining"
+
+func isStagedRollingUpdate(instance *appv1alpha1.Deployable) bool {
+	rolling := instance.Spec.RollingUpdate
+
+	return rolling != nil && (len(rolling.Stages) > 0 || rolling.RequireApproval)
+}
+
+// assignRolloutStages splits the target clusters into the configured stages, in order.
+// Clusters are sorted by name so the same clusters go first every time.
+func (r *ReconcileDeployable) assignRolloutStages(ctx context.Context, instance *appv1alpha1.Deployable) []appv1alpha1.RolloutStageStatus {
+	log := logf.FromContext(ctx)
+
+	var remaining []string
+	for cluster := range instance.Status.PropagatedStatus {
+		remaining = append(remaining, cluster)
+	}
+
+	sort.Strings(remaining)
+
+	clusterlabels := make(map[string]labels.Set)
+	mclist := &spokeClusterV1.ManagedClusterList{}
+
+	if err := r.List(ctx, mclist); err != nil {
+		log.Error(err, "Failed to list managed clusters for rollout stages")
+	}
+
+	for _, mc := range mclist.Items {
+		clusterlabels[mc.GetName()] = labels.Set(mc.GetLabels())
+	}
+
+	var stages []appv1alpha1.RolloutStageStatus
+
+	for _, stage := range instance.Spec.RollingUpdate.Stages {
+		selector, err := utils.ConvertLabels(stage.ClusterSelector)
+		if err != nil {
+			log.Error(err, "Invalid cluster selector in rollout stage", "stage", stage.Name)
+
+			selector = labels.Nothing()
+		}
+
+		var selected, rest []string
+
+		for _, cluster := range remaining {
+			if selector.Matches(clusterlabels[cluster]) {
+				selected = append(selected, cluster)
+			} else {
+				rest = append(rest, cluster)
+			}
+		}
+
+		if stage.Percentage != nil && *stage.Percentage < 100 {
+			n := (len(selected)**stage.Percentage + 99) / 100
+			rest = append(rest, selected[n:]...)
+			selected = selected[:n]
+
+			sort.Strings(rest)
+		}
+
+		remaining = rest
+
+		stages = append(stages, appv1alpha1.RolloutStageStatus{
+			Name:     stage.Name,
+			Phase:    appv1alpha1.RolloutStagePending,
+			Clusters: selected,
+		})
+	}
+
+	if len(remaining) > 0 {
+		stages = append(stages, appv1alpha1.RolloutStageStatus{
+			Name:     remainingStageName,
+			Phase:    appv1alpha1.RolloutStagePending,
+			Clusters: remaining,
+		})
+	}
+
+	return stages
+}
+
+// advanceRolloutStages moves the staged rolling update forward and returns the pending clusters allowed to roll now.
+// Only the first stage not completed rolls, and only once it is approved when it requires approval.
+func (r *ReconcileDeployable) advanceRolloutStages(ctx context.Context, instance *appv1alpha1.Deployable,
+	pending map[string]struct{}) map[string]struct{} {
+	rus := instance.Status.RollingUpdate
+	if rus == nil {
+		return nil
+	}
+
+	if len(rus.Stages) == 0 {
+		rus.Stages = r.assignRolloutStages(ctx, instance)
+	}
+
+	// clusters joining in the middle of the rolling update are rolled with the current stage
+	assigned := make(map[string]struct{})
+
+	for _, ss := range rus.Stages {
+		for _, cluster := range ss.Clusters {
+			assigned[cluster] = struct{}{}
+		}
+	}
+
+	var joined []string
+
+	for cluster := range instance.Status.PropagatedStatus {
+		if _, ok := assigned[cluster]; !ok {
+			joined = append(joined, cluster)
+		}
+	}
+
+	sort.Strings(joined)
+
+	rollable := make(map[string]struct{})
+	now := metav1.Now()
+
+	for i := range rus.Stages {
+		ss := &rus.Stages[i]
+
+		if ss.Phase == appv1alpha1.RolloutStageCompleted {
+			continue
+		}
+
+		if len(joined) > 0 {
+			ss.Clusters = append(ss.Clusters, joined...)
+			joined = nil
+		}
+
+		spec := getRolloutStageSpec(instance, ss.Name)
+
+		if ss.Phase == appv1alpha1.RolloutStagePending || ss.Phase == appv1alpha1.RolloutStageWaitingForApproval {
+			if spec.RequireApproval && !r.approveRolloutStage(ctx, instance, ss.Name) {
+				ss.Phase = appv1alpha1.RolloutStageWaitingForApproval
+				return rollable
+			}
+
+			ss.Phase = appv1alpha1.RolloutStageProgressing
+			ss.StartTime = &now
+
+			r.eventRecorder.RecordEvent(instance, appv1alpha1.EventReasonRolloutStageStarted, "Rollout stage "+ss.Name+" started", nil)
+		}
+
+		maxunav := getRolloutStageMaxUnavailable(instance, spec)
+		maxunav = (len(ss.Clusters)*maxunav + 99) / 100
+
+		var stagepending []string
+
+		unavailable := 0
+
+		for _, cluster := range ss.Clusters {
+			if _, ok := pending[cluster]; ok {
+				stagepending = append(stagepending, cluster)
+				continue
+			}
+
+			if cs, ok := instance.Status.PropagatedStatus[cluster]; ok && cs.Phase != appv1alpha1.DeployableDeployed {
+				unavailable++
+			}
+		}
+
+		ss.UpdatedClusters = len(ss.Clusters) - len(stagepending)
+
+		if len(stagepending) > 0 {
+			for _, cluster := range stagepending {
+				if unavailable >= maxunav {
+					break
+				}
+
+				rollable[cluster] = struct{}{}
+				unavailable++
+				ss.UpdatedClusters++
+			}
+
+			return rollable
+		}
+
+		if unavailable > 0 {
+			// wait for the stage clusters to be deployed
+			return rollable
+		}
+
+		if ss.Phase != appv1alpha1.RolloutStageBaking {
+			ss.Phase = appv1alpha1.RolloutStageBaking
+			ss.DeployedTime = &now
+		}
+
+		if spec.BakeTime != nil && ss.DeployedTime.Add(spec.BakeTime.Duration).After(now.Time) {
+			return rollable
+		}
+
+		ss.Phase = appv1alpha1.RolloutStageCompleted
+		ss.CompletionTime = &now
+
+		r.eventRecorder.RecordEvent(instance, appv1alpha1.EventReasonRolloutStageCompleted, "Rollout stage "+ss.Name+" completed", nil)
+	}
+
+	// all stages are completed, roll whatever is left
+	for cluster := range pending {
+		rollable[cluster] = struct{}{}
+	}
+
+	return rollable
+}
+
+func isRolloutStagesCompleted(instance *appv1alpha1.Deployable) bool {
+	if !isStagedRollingUpdate(instance) {
+		return true
+	}
+
+	if instance.Status.RollingUpdate == nil {
+		return false
+	}
+
+	for _, ss := range instance.Status.RollingUpdate.Stages {
+		if ss.Phase != appv1alpha1.RolloutStageCompleted {
+			return false
+		}
+	}
+
+	return true
+}
+
+func getRolloutStageSpec(instance *appv1alpha1.Deployable, name string) appv1alpha1.RolloutStage {
+	for _, stage := range instance.Spec.RollingUpdate.Stages {
+		if stage.Name == name {
+			return stage
+		}
+	}
+
+	return appv1alpha1.RolloutStage{Name: name, RequireApproval: instance.Spec.RollingUpdate.RequireApproval}
+}
+
+func getRolloutStageMaxUnavailable(instance *appv1alpha1.Deployable, stage appv1alpha1.RolloutStage) int {
+	if stage.MaxUnavailable != nil {
+		return *stage.MaxUnavailable
+	}
+
+	maxunav, err := strconv.Atoi(instance.GetAnnotations()[appv1alpha1.AnnotationRollingUpdateMaxUnavailable])
+	if err != nil {
+		maxunav = appv1alpha1.DefaultRollingUpdateMaxUnavailablePercentage
+	}
+
+	return maxunav
+}
+
+// approveRolloutStage tells if the stage is approved. A pending approval given in the hub deployable annotations
+// is recorded in status with the approver recorded by the admission webhook, and the annotations are removed.
+func (r *ReconcileDeployable) approveRolloutStage(ctx context.Context, instance *appv1alpha1.Deployable, name string) bool {
+	rus := instance.Status.RollingUpdate
+
+	for _, approval := range rus.Approvals {
+		if approval.Stage == name {
+			return true
+		}
+	}
+
+	annotations := instance.GetAnnotations()
+	approval := annotations[appv1alpha1.AnnotationRollingUpdateApprovedBy]
+	approver := annotations[appv1alpha1.AnnotationRollingUpdateApprover]
+	stage := annotations[appv1alpha1.AnnotationRollingUpdateApprovedStage]
+
+	if approval == "" || approver == "" || (stage != "" && stage != name) {
+		msg := "Rollout stage " + name + " is waiting for approval"
+		if approval != "" && approver == "" {
+			msg += ", the approver is not recorded by the admission webhook"
+		}
+
+		meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+			Type:               appv1alpha1.ConditionWaitingForApproval,
+			Status:             metav1.ConditionTrue,
+			Reason:             appv1alpha1.ReasonApprovalRequired,
+			Message:            msg,
+			ObservedGeneration: instance.GetGeneration(),
+		})
+
+		return false
+	}
+
+	now := metav1.Now()
+	rus.Approvals = append(rus.Approvals, appv1alpha1.RolloutApproval{
+		Stage:        name,
+		Approver:     approver,
+		ApprovedTime: &now,
+	})
+
+	delete(annotations, appv1alpha1.AnnotationRollingUpdateApprovedBy)
+	delete(annotations, appv1alpha1.AnnotationRollingUpdateApprover)
+	delete(annotations, appv1alpha1.AnnotationRollingUpdateApprovedStage)
+	instance.SetAnnotations(annotations)
+
+	msg := "Rollout stage " + name + " approved by " + approver
+
+	meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+		Type:               appv1alpha1.ConditionWaitingForApproval,
+		Status:             metav1.ConditionFalse,
+		Reason:             appv1alpha1.ReasonApproved,
+		Message:            msg,
+		ObservedGeneration: instance.GetGeneration(),
+	})
+
+	logf.FromContext(ctx).Info("Rollout stage approved", "stage", name, "approver", approver)
+	r.eventRecorder.RecordEvent(instance, appv1alpha1.EventReasonRolloutStageApproved, msg, nil)
+
+	return true
+}
+
+// rolloutStageRequeueAfter returns when the bake time of the current stage is over, never while suspended.
+func rolloutStageRequeueAfter(instance *appv1alpha1.Deployable) time.Duration {
+	if !isStagedRollingUpdate(instance) || instance.Status.RollingUpdate == nil || isSuspended(instance) {
+		return 0
+	}
+
+	for _, ss := range instance.Status.RollingUpdate.Stages {
+		if ss.Phase != appv1alpha1.RolloutStageBaking || ss.DeployedTime == nil {
+			continue
+		}
+
+		spec := getRolloutStageSpec(instance, ss.Name)
+		if spec.BakeTime == nil {
+			return time.Second
+		}
+
+		wait := time.Until(ss.DeployedTime.Add(spec.BakeTime.Duration)) + time.Second
+		if wait < time.Second {
+			wait = time.Second
+		}
+
+		return wait
+	}
+
+	return 0
+}
@@ -0,0 +1,171 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployable
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+)
+
+// checkSuspended tells if the deployable, or its namespace, is suspended and records it in the Suspended condition.
+func (r *ReconcileDeployable) checkSuspended(ctx context.Context, instance *appv1alpha1.Deployable) bool {
+	reason := ""
+
+	if instance.Spec.Suspend {
+		reason = appv1alpha1.ReasonDeployableSuspended
+	} else if isNamespaceSuspended(ctx, r.Client, instance.GetNamespace()) {
+		reason = appv1alpha1.ReasonNamespaceSuspended
+	}
+
+	if reason == "" {
+		resumeRollingUpdate(instance)
+
+		if meta.IsStatusConditionTrue(instance.Status.Conditions, appv1alpha1.ConditionSuspended) {
+			meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+				Type:               appv1alpha1.ConditionSuspended,
+				Status:             metav1.ConditionFalse,
+				Reason:             appv1alpha1.ReasonResumed,
+				Message:            "Propagation resumed",
+				ObservedGeneration: instance.GetGeneration(),
+			})
+
+			r.eventRecorder.RecordEvent(instance, appv1alpha1.EventReasonResumed, "Propagation resumed", nil)
+		}
+
+		return false
+	}
+
+	if rus := instance.Status.RollingUpdate; rus != nil && rus.SuspendedTime == nil {
+		now := metav1.Now()
+		rus.SuspendedTime = &now
+	}
+
+	if !meta.IsStatusConditionTrue(instance.Status.Conditions, appv1alpha1.ConditionSuspended) {
+		r.eventRecorder.RecordEvent(instance, appv1alpha1.EventReasonSuspended, "Propagation suspended by "+reason, nil)
+	}
+
+	meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+		Type:               appv1alpha1.ConditionSuspended,
+		Status:             metav1.ConditionTrue,
+		Reason:             reason,
+		Message:            "Propagation, rolling update and cleanup are suspended",
+		ObservedGeneration: instance.GetGeneration(),
+	})
+
+	logf.FromContext(ctx).V(logLevelDebug).Info("Deployable is suspended", "reason", reason)
+
+	return true
+}
+
+// isSuspended tells if the deployable was found suspended by its last reconcile, its timers not running.
+func isSuspended(instance *appv1alpha1.Deployable) bool {
+	return meta.IsStatusConditionTrue(instance.Status.Conditions, appv1alpha1.ConditionSuspended)
+}
+
+// resumeRollingUpdate shifts the bake times of the rollout stages by the time the rolling update was suspended,
+// and restarts the progress deadline of the clusters.
+func resumeRollingUpdate(instance *appv1alpha1.Deployable) {
+	rus := instance.Status.RollingUpdate
+	if rus == nil || rus.SuspendedTime == nil {
+		return
+	}
+
+	now := metav1.Now()
+	suspended := now.Sub(rus.SuspendedTime.Time)
+
+	for i := range rus.Stages {
+		if ss := &rus.Stages[i]; ss.Phase == appv1alpha1.RolloutStageBaking && ss.DeployedTime != nil {
+			shifted := metav1.NewTime(ss.DeployedTime.Add(suspended))
+			ss.DeployedTime = &shifted
+		}
+	}
+
+	rus.SuspendedTime = nil
+	rus.ResumedTime = &now
+}
+
+func isNamespaceSuspended(ctx context.Context, c client.Client, namespace string) bool {
+	ns := &corev1.Namespace{}
+
+	if err := c.Get(ctx, types.NamespacedName{Name: namespace}, ns); err != nil {
+		logf.FromContext(ctx).V(logLevelTrace).Info("Failed to get namespace", "error", err.Error())
+		return false
+	}
+
+	return strings.EqualFold(ns.GetLabels()[appv1alpha1.LabelSuspendDeployables], "true")
+}
+
+type namespaceMapper struct {
+	client.Client
+	log logr.Logger
+}
+
+// Map enqueues the hub deployables of a namespace when its suspend label changes.
+func (mapper *namespaceMapper) Map(obj client.Object) []reconcile.Request {
+	var requests []reconcile.Request
+
+	dplList := &appv1alpha1.DeployableList{}
+
+	err := mapper.List(context.TODO(), dplList, &client.ListOptions{Namespace: obj.GetName()})
+	if err != nil {
+		mapper.log.Error(err, "Failed to list deployables for namespace mapper", "namespace", obj.GetName())
+		return requests
+	}
+
+	for _, dpl := range dplList.Items {
+		if dpl.Spec.Placement == nil {
+			continue
+		}
+
+		objkey := types.NamespacedName{
+			Name:      dpl.GetName(),
+			Namespace: dpl.GetNamespace(),
+		}
+
+		requests = append(requests, reconcile.Request{NamespacedName: objkey})
+	}
+
+	return requests
+}
+
+// namespacePredicateFunc only passes changes of the suspend-deployables label.
+var namespacePredicateFunc = predicate.Funcs{
+	CreateFunc: func(e event.CreateEvent) bool {
+		return false
+	},
+	DeleteFunc: func(e event.DeleteEvent) bool {
+		return false
+	},
+	GenericFunc: func(e event.GenericEvent) bool {
+		return false
+	},
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		return e.ObjectOld.GetLabels()[appv1alpha1.LabelSuspendDeployables] !=
+			e.ObjectNew.GetLabels()[appv1alpha1.LabelSuspendDeployables]
+	},
+}
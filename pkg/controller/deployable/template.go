@@ -17,57 +17,142 @@ package deployable
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"strings"
 
+	"go.opentelemetry.io/otel/attribute"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/klog"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
 
 	spokeClusterV1 "github.com/open-cluster-management/api/cluster/v1"
 	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
 	"github.com/stolostron/multicloud-operators-deployable/pkg/utils"
 )
 
-func (r *ReconcileDeployable) propagateDeployables(clusters []types.NamespacedName, instance *appv1alpha1.Deployable,
-	familymap map[string]*appv1alpha1.Deployable) (map[string]*appv1alpha1.Deployable, error) {
-	if klog.V(utils.QuiteLogLel) {
-		fnName := utils.GetFnName()
-		klog.Infof("Entering: %v()", fnName)
+// ReasonRenderFailed is the propagated status reason of a cluster whose template does not render, like a chart that fails
+// with the values of the cluster. The cluster keeps what it has.
+const ReasonRenderFailed = "RenderFailed"
 
-		defer klog.Infof("Exiting: %v()", fnName)
-	}
+// propagateUnits applies the template rendered for each cluster with the propagation backend, and returns the units left to expire.
+// The template is rendered once per cluster, and a cluster whose template does not render keeps its units.
+func (r *ReconcileDeployable) propagateUnits(ctx context.Context, backend propagationBackend, clusters []types.NamespacedName,
+	instance *appv1alpha1.Deployable, units []client.Object) ([]client.Object, error) {
+	byNamespace := unitsByNamespace(units)
+	kept := make(map[client.Object]bool)
 
-	var err error
-	// generate the deploaybles
 	hosting := types.NamespacedName{Name: instance.GetName(), Namespace: instance.GetNamespace()}
 
+	checkMaintenanceWindows(instance)
+
 	for _, cluster := range clusters {
-		familymap, err = r.createManagedDeployable(cluster, hosting, instance, familymap)
+		clusterctx, log := clusterContext(ctx, cluster)
+		spanctx, span := startSpan(clusterctx, "applyUnits", instance, clusterAttributes(cluster)...)
+		clusterUnits := byNamespace[cluster.Namespace]
+
+		rendered, err := r.renderLocalDeployable(spanctx, cluster, hosting, instance)
+		if err != nil {
+			r.failRender(spanctx, cluster, instance, err)
+
+			for _, unit := range clusterUnits {
+				kept[unit] = true
+			}
+
+			span.SetAttributes(attribute.Bool("cluster.failed", true))
+			span.End()
+
+			continue
+		}
+
+		reason, denied, err := r.reviewCluster(spanctx, cluster, instance, rendered)
+		if err != nil {
+			endSpan(span, err)
+			log.Error(err, "Failed to review the propagation to cluster")
+
+			return nil, err
+		}
+
+		if denied != "" {
+			r.denyCluster(spanctx, cluster, instance, reason, denied)
+
+			// the cluster keeps what it has, nothing new is propagated
+			for _, unit := range clusterUnits {
+				kept[unit] = true
+			}
+
+			span.SetAttributes(attribute.Bool("cluster.denied", true))
+			span.End()
+
+			continue
+		}
+
+		if r.deferToMaintenanceWindow(spanctx, backend, cluster, instance, rendered, clusterUnits) {
+			// keep the existing units in the cluster from expiring
+			for _, unit := range clusterUnits {
+				kept[unit] = true
+			}
+
+			span.SetAttributes(attribute.Bool("cluster.deferred", true))
+			span.End()
+
+			continue
+		}
+
+		applied, err := backend.apply(spanctx, cluster, instance, rendered, clusterUnits)
+		endSpan(span, err)
+
 		if err != nil {
-			klog.Error("Error in propagating ", cluster)
-			return familymap, err
+			log.Error(err, "Failed to propagate to cluster")
+			return nil, err
+		}
+
+		for _, unit := range applied {
+			kept[unit] = true
 		}
 	}
 
-	return familymap, nil
+	var expired []client.Object
+
+	for _, unit := range units {
+		if !kept[unit] {
+			expired = append(expired, unit)
+		}
+	}
+
+	return expired, nil
 }
 
-func (r *ReconcileDeployable) createManagedDeployable(cluster types.NamespacedName, hosting types.NamespacedName,
-	instance *appv1alpha1.Deployable, familymap map[string]*appv1alpha1.Deployable) (map[string]*appv1alpha1.Deployable, error) {
-	if klog.V(utils.QuiteLogLel) {
-		fnName := utils.GetFnName()
-		klog.Infof("Entering: %v()", fnName)
+// failRender records in the propagated status of the cluster why its template does not render.
+func (r *ReconcileDeployable) failRender(ctx context.Context, cluster types.NamespacedName, instance *appv1alpha1.Deployable, err error) {
+	logf.FromContext(ctx).Error(err, "Failed to render the template for cluster, keeping what the cluster has")
+
+	if instance.Status.PropagatedStatus == nil {
+		instance.Status.PropagatedStatus = make(map[string]*appv1alpha1.ResourceUnitStatus)
+	}
 
-		defer klog.Infof("Exiting: %v()", fnName)
+	instance.Status.PropagatedStatus[cluster.Name] = &appv1alpha1.ResourceUnitStatus{
+		Phase:   appv1alpha1.DeployableFailed,
+		Reason:  ReasonRenderFailed,
+		Message: err.Error(),
 	}
 
+	r.recordClusterEvent(ctx, instance, appv1alpha1.EventReasonPropagationFailed, cluster.Name, err)
+}
+
+// createManagedDeployable creates or updates the child deployable of the cluster from the rendered one, then the
+// children of the dependencies.
+func (r *ReconcileDeployable) createManagedDeployable(ctx context.Context, cluster types.NamespacedName, instance, rendered *appv1alpha1.Deployable,
+	familymap map[string]*appv1alpha1.Deployable) (map[string]*appv1alpha1.Deployable, error) {
+	log := logf.FromContext(ctx)
+
 	var err error
 
 	namespace := cluster.Namespace
 	// remove active clusters from expiration list
-	klog.V(5).Info("Creating Managed deployable:", instance, " in cluster:", cluster)
+	log.V(logLevelTrace).Info("Propagating deployable", "deployable", instance.GetNamespace()+"/"+instance.GetName())
 
 	// create or update child deployable
 	truekey := types.NamespacedName{Name: instance.GetName() + "-", Namespace: namespace}.String()
@@ -80,12 +165,14 @@ func (r *ReconcileDeployable) createManagedDeployable(cluster types.NamespacedNa
 	}
 
 	original := existingdeployable.DeepCopy()
-	existingdeployable = r.setLocalDeployable(&cluster, hosting, instance, existingdeployable)
+	existingdeployable = mergeLocalDeployable(existingdeployable, rendered)
+	drifted := ok && applyDriftPolicy(instance, original, existingdeployable)
 	ifRecordEvent := false
 
 	if !ok {
-		klog.V(5).Info("Creating new local deployable:", existingdeployable)
-		err = r.Create(context.TODO(), existingdeployable)
+		log.Info("Creating child deployable", "child", existingdeployable.GetNamespace()+"/"+existingdeployable.GetGenerateName())
+		err = r.Create(ctx, existingdeployable)
+		recordChildOperation(childOperationCreate, err)
 
 		if instance.Status.PropagatedStatus == nil {
 			instance.Status.PropagatedStatus = make(map[string]*appv1alpha1.ResourceUnitStatus)
@@ -95,8 +182,10 @@ func (r *ReconcileDeployable) createManagedDeployable(cluster types.NamespacedNa
 		ifRecordEvent = true
 	} else {
 		if !utils.CompareDeployable(original, existingdeployable) {
-			klog.Info("Updating existing local deployable: ", existingdeployable.GetName())
-			err = r.Update(context.TODO(), existingdeployable)
+			log.Info("Updating child deployable", "child", existingdeployable.GetNamespace()+"/"+existingdeployable.GetName())
+			err = r.Update(ctx, existingdeployable)
+			recordChildOperation(childOperationUpdate, err)
+
 			if err == nil {
 				newDpl := existingdeployable.DeepCopy()
 				newDpl.Status.Phase = ""
@@ -105,96 +194,75 @@ func (r *ReconcileDeployable) createManagedDeployable(cluster types.NamespacedNa
 				newDpl.Status.ResourceStatus = nil
 				now := metav1.Now()
 				newDpl.Status.LastUpdateTime = &now
-				err = r.Status().Update(context.TODO(), newDpl)
+				err = r.Status().Update(ctx, newDpl)
 			}
 
 			instance.Status.PropagatedStatus[cluster.Name] = &appv1alpha1.ResourceUnitStatus{}
 			ifRecordEvent = true
 		} else {
-			klog.V(5).Info("Same existing local deployable, no need to update. instance: ",
-				string(original.Spec.Template.Raw), " vs existing: ", string(existingdeployable.Spec.Template.Raw))
+			log.V(logLevelTrace).Info("Child deployable is up to date", "child", existingdeployable.GetNamespace()+"/"+existingdeployable.GetName())
 		}
 	}
 
 	if ifRecordEvent {
-		//record events
-		hostDeployable := &appv1alpha1.Deployable{}
-		error1 := r.Get(context.TODO(), hosting, hostDeployable)
-
-		if error1 != nil {
-			klog.V(5).Info("hosting deployable not found, unable to record events to it. ", hosting)
-		} else {
-			dplkey := types.NamespacedName{Name: existingdeployable.GetName(), Namespace: existingdeployable.GetNamespace()}
-			eventObj := ""
-			addtionalMsg := ""
-
-			if utils.IsDependencyDeployable(existingdeployable) {
-				eventObj = "Dependency Deployable"
-			} else {
-				eventObj = "Deployable"
-			}
-
-			addtionalMsg = "Propagate " + eventObj + " " + dplkey.String() + " for cluster " + cluster.String()
-			r.eventRecorder.RecordEvent(hostDeployable, "Deploy", addtionalMsg, err)
+		reason := appv1alpha1.EventReasonPropagated
+		if err != nil {
+			reason = appv1alpha1.EventReasonPropagationFailed
 		}
+
+		r.recordClusterEvent(ctx, instance, reason, cluster.Name, err)
 	}
 
 	if err != nil {
 		// return error is something is wrong
-		klog.Error("Failed in processing local deployable with error:", err)
+		log.Error(err, "Failed to create or update child deployable")
 
 		return nil, err
 	}
 
+	if drifted {
+		r.reportDrift(ctx, cluster, instance, existingdeployable)
+	}
+
 	// remove it from to be deleted map
-	klog.V(5).Info("Removing ", truekey, " from ", familymap)
 	delete(familymap, truekey)
 
-	return r.createManagedDependencies(cluster, instance, familymap)
+	return r.createManagedDependencies(ctx, cluster, instance, familymap)
 }
 
-func (r *ReconcileDeployable) setLocalDeployable(cluster *client.ObjectKey, hosting types.NamespacedName,
-	instance, localdeployable *appv1alpha1.Deployable) *appv1alpha1.Deployable {
-	if klog.V(utils.QuiteLogLel) {
-		fnName := utils.GetFnName()
-		klog.Infof("Entering: %v()", fnName)
-
-		defer klog.Infof("Exiting: %v()", fnName)
-	}
+// renderLocalDeployable renders the child deployable of the hub deployable for the cluster: the template renamed for the
+// local cluster, overridden, rendered from its chart and kustomized, with the annotations and labels of a child. It fails
+// when the chart or the overlays of the cluster do not apply, so that the cluster keeps what it has.
+func (r *ReconcileDeployable) renderLocalDeployable(ctx context.Context, cluster types.NamespacedName, hosting types.NamespacedName,
+	instance *appv1alpha1.Deployable) (*appv1alpha1.Deployable, error) {
+	log := logf.FromContext(ctx)
 
+	localdeployable := &appv1alpha1.Deployable{}
 	localdeployable.SetGenerateName(instance.GetName() + "-")
 	localdeployable.SetNamespace(cluster.Namespace)
-
 	localdeployable.Spec.Template = instance.Spec.Template.DeepCopy()
 
 	managedCluster := &spokeClusterV1.ManagedCluster{}
 	managedClusterKey := types.NamespacedName{
 		Name: cluster.Name,
 	}
-	err := r.Get(context.TODO(), managedClusterKey, managedCluster)
+	err := r.Get(ctx, managedClusterKey, managedCluster)
 
 	if err != nil {
-		klog.Error("Failed to find managed cluster " + cluster.Name)
+		log.Error(err, "Failed to find managed cluster")
 	} else {
 		labels := managedCluster.GetLabels()
 
 		if strings.EqualFold(labels["local-cluster"], "true") {
-			klog.Info("This is local-cluster")
-			klog.Info("Appending -local to the subscription name")
-			// append -local to the local subscription name to avoid subscription name collision in the same namespace.
-			sub := &unstructured.Unstructured{}
-			err := json.Unmarshal(localdeployable.Spec.Template.Raw, sub)
+			// rename the template of the local cluster to avoid name collisions with the hub resources in the same namespace.
+			naming := localClusterNamingFor(instance)
+			log.V(logLevelDebug).Info("Renaming the template for local-cluster", "policy", naming.Policy, "kinds", naming.Kinds)
 
+			renamed, err := renameForLocalCluster(naming, localdeployable.Spec.Template, cluster.Name)
 			if err != nil {
-				klog.Info("Error in unmarshall, err:", err, " |template: ", string(localdeployable.Spec.Template.Raw))
+				log.Error(err, "Failed to rename template")
 			} else {
-				sub.SetName(sub.GetName() + "-local")
-			}
-
-			localdeployable.Spec.Template.Raw, err = json.Marshal(sub)
-
-			if err != nil {
-				klog.Info("Error in mashalling obj ", sub, err)
+				localdeployable.Spec.Template = renamed
 			}
 		}
 	}
@@ -202,11 +270,9 @@ func (r *ReconcileDeployable) setLocalDeployable(cluster *client.ObjectKey, host
 	localdeployable.Spec.Dependencies = instance.Spec.Dependencies
 	localdeployable.Spec.Overrides = nil
 	localdeployable.Spec.Channels = nil
+	localdeployable.Spec.LocalClusterNaming = nil
 
-	localAnnotations := localdeployable.GetAnnotations()
-	if localAnnotations == nil {
-		localAnnotations = make(map[string]string)
-	}
+	localAnnotations := make(map[string]string)
 
 	for k, v := range instance.GetAnnotations() {
 		localAnnotations[k] = v
@@ -228,11 +294,7 @@ func (r *ReconcileDeployable) setLocalDeployable(cluster *client.ObjectKey, host
 
 	localdeployable.SetAnnotations(localAnnotations)
 
-	localLabels := localdeployable.GetLabels()
-
-	if localLabels == nil {
-		localLabels = make(map[string]string)
-	}
+	localLabels := make(map[string]string)
 
 	for k, v := range instance.GetLabels() {
 		localLabels[k] = v
@@ -250,34 +312,134 @@ func (r *ReconcileDeployable) setLocalDeployable(cluster *client.ObjectKey, host
 	// propagate subscription-pause label to new local deployable subscription template
 	err = utils.SetPauseLabelDplSubTpl(instance, localdeployable)
 	if err != nil {
-		klog.Info("Failed to propagate pause label to new local deployable subscription template. err:", err)
+		log.Error(err, "Failed to propagate pause label to the subscription template")
 	}
 
 	localdeployable.SetLabels(localLabels)
 
-	covs, _ := utils.PrepareOverrides(*cluster, instance)
-	if covs != nil {
-		tplobj := &unstructured.Unstructured{}
-		err := json.Unmarshal(localdeployable.Spec.Template.Raw, tplobj)
+	overrideLocalTemplate(ctx, cluster, labels.Set(managedCluster.GetLabels()), instance, localdeployable)
 
+	if utils.IsHelmChartTemplate(localdeployable.Spec.Template) {
+		rendered, _, err := utils.RenderHelmChart(ctx, localdeployable.Spec.Template)
 		if err != nil {
-			klog.Info("Error in unmarshall template ", string(localdeployable.Spec.Template.Raw))
-			return localdeployable
+			return nil, fmt.Errorf("failed to render the helm chart of %s/%s: %w", instance.GetNamespace(), instance.GetName(), err)
 		}
 
-		tplobj, err = utils.OverrideTemplate(tplobj, covs)
-		if err != nil {
-			klog.Info("Error in overriding obj ", tplobj)
-			return localdeployable
-		}
+		localdeployable.Spec.Template = rendered
+	}
 
-		localdeployable.Spec.Template.Raw, err = json.Marshal(tplobj)
-		if err != nil {
-			klog.Info("Error in mashalling obj ", tplobj)
-		}
+	overlays := utils.PrepareOverlays(cluster, labels.Set(managedCluster.GetLabels()), instance)
+
+	kustomized, err := utils.KustomizeTemplate(localdeployable.Spec.Template, overlays)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply the kustomize overlays of %s/%s: %w", instance.GetNamespace(), instance.GetName(), err)
+	}
+
+	localdeployable.Spec.Template = kustomized
+
+	return setTemplateHash(localdeployable), nil
+}
+
+// mergeLocalDeployable updates a copy of the existing child deployable with the rendered one, keeping the annotations
+// and labels set on the child by others.
+func mergeLocalDeployable(existing, rendered *appv1alpha1.Deployable) *appv1alpha1.Deployable {
+	merged := existing.DeepCopy()
+	merged.SetGenerateName(rendered.GetGenerateName())
+	merged.SetNamespace(rendered.GetNamespace())
+	merged.Spec.Template = rendered.Spec.Template.DeepCopy()
+	merged.Spec.Dependencies = rendered.Spec.Dependencies
+	merged.Spec.Overrides = nil
+	merged.Spec.Channels = nil
+	merged.Spec.LocalClusterNaming = nil
+
+	annotations := merged.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+
+	for k, v := range rendered.GetAnnotations() {
+		annotations[k] = v
+	}
+
+	// the rolling update target is not propagated, even when the existing child still has it
+	delete(annotations, appv1alpha1.AnnotationRollingUpdateTarget)
+	merged.SetAnnotations(annotations)
+
+	labels := merged.GetLabels()
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+
+	for k, v := range rendered.GetLabels() {
+		labels[k] = v
+	}
+
+	merged.SetLabels(labels)
+
+	return merged
+}
+
+// overrideLocalTemplate applies the overrides of the cluster, and of the groups it belongs to, to the template of the child deployable.
+func overrideLocalTemplate(ctx context.Context, cluster client.ObjectKey, clusterLabels labels.Set, instance, localdeployable *appv1alpha1.Deployable) {
+	log := logf.FromContext(ctx)
+
+	named, _ := utils.PrepareOverrides(cluster, instance)
+
+	covs := append(utils.PrepareSelectorOverrides(clusterLabels, instance), named...)
+	if len(covs) == 0 {
+		return
+	}
+
+	tplobj := &unstructured.Unstructured{}
+	err := json.Unmarshal(localdeployable.Spec.Template.Raw, tplobj)
+
+	if err != nil {
+		log.Error(err, "Failed to unmarshal template")
+		return
 	}
 
-	klog.V(5).Info("Local deployable:", localdeployable)
+	tplobj, err = utils.OverrideTemplate(tplobj, covs)
+	if err != nil {
+		log.Error(err, "Failed to override template")
+		return
+	}
+
+	localdeployable.Spec.Template.Raw, err = json.Marshal(tplobj)
+	if err != nil {
+		log.Error(err, "Failed to marshal template")
+	}
+}
+
+// setTemplateHash records the hash of the rendered template in the child deployable, to detect out of band edits.
+func setTemplateHash(dpl *appv1alpha1.Deployable) *appv1alpha1.Deployable {
+	annotations := dpl.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+
+	annotations[appv1alpha1.AnnotationTemplateHash] = utils.TemplateHash(dpl.Spec.Template)
+	dpl.SetAnnotations(annotations)
+
+	return dpl
+}
+
+// setChartStatus renders the HelmChart template with its own values, and records the chart and the rendered hash.
+func setChartStatus(ctx context.Context, instance *appv1alpha1.Deployable) error {
+	if !utils.IsHelmChartTemplate(instance.Spec.Template) {
+		instance.Status.Chart = nil
+		return nil
+	}
+
+	rendered, metadata, err := utils.RenderHelmChart(ctx, instance.Spec.Template)
+	if err != nil {
+		return err
+	}
+
+	instance.Status.Chart = &appv1alpha1.ChartStatus{
+		Name:         metadata.Name,
+		Version:      metadata.Version,
+		RenderedHash: utils.TemplateHash(rendered),
+	}
 
-	return localdeployable
+	return nil
 }
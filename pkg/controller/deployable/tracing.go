@@ -0,0 +1,68 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployable
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+)
+
+const tracerName = "github.com/stolostron/multicloud-operators-deployable/pkg/controller/deployable"
+
+// startReconcileSpan starts the root span of a reconcile.
+func startReconcileSpan(ctx context.Context, request reconcile.Request) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, "Reconcile", trace.WithAttributes(deployableAttributes(request.NamespacedName)...))
+}
+
+// startSpan starts a span of a reconcile phase, carrying the deployable attributes.
+func startSpan(ctx context.Context, name string, instance *appv1alpha1.Deployable,
+	attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, deployableAttributes(types.NamespacedName{Namespace: instance.GetNamespace(), Name: instance.GetName()})...)
+
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+func deployableAttributes(key types.NamespacedName) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("deployable.namespace", key.Namespace),
+		attribute.String("deployable.name", key.Name),
+	}
+}
+
+// clusterAttributes identifies the target cluster of a span.
+func clusterAttributes(cluster types.NamespacedName) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("cluster.name", cluster.Name),
+		attribute.String("cluster.namespace", cluster.Namespace),
+	}
+}
+
+// endSpan records the error, if any, and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
@@ -16,6 +16,8 @@ package utils
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 
@@ -26,7 +28,6 @@ import (
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/klog"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
@@ -88,12 +89,12 @@ var DeployablePredicateFunc = predicate.Funcs{
 // CompareDeployable compare two deployables and return true if they are equal.
 func CompareDeployable(olddpl *appv1alpha1.Deployable, newdpl *appv1alpha1.Deployable) bool {
 	if !reflect.DeepEqual(newdpl.GetAnnotations(), olddpl.GetAnnotations()) {
-		klog.V(5).Infof("old annotation: %#v, new annotation: %#v", olddpl.GetAnnotations(), newdpl.GetAnnotations())
+		log.V(5).Info("Annotations differ", "old", olddpl.GetAnnotations(), "new", newdpl.GetAnnotations())
 		return false
 	}
 
 	if !reflect.DeepEqual(newdpl.GetLabels(), olddpl.GetLabels()) {
-		klog.V(5).Infof("old lael: %#v, new label: %#v", olddpl.GetLabels(), newdpl.GetLabels())
+		log.V(5).Info("Labels differ", "old", olddpl.GetLabels(), "new", newdpl.GetLabels())
 		return false
 	}
 
@@ -119,7 +120,7 @@ func CompareDeployable(olddpl *appv1alpha1.Deployable, newdpl *appv1alpha1.Deplo
 	}
 
 	if !reflect.DeepEqual(newtmpl, oldtmpl) {
-		klog.V(5).Infof("old template: %#v, new template: %#v", oldtmpl, newtmpl)
+		log.V(5).Info("Templates differ", "old", oldtmpl, "new", newtmpl)
 		return false
 	}
 
@@ -128,20 +129,70 @@ func CompareDeployable(olddpl *appv1alpha1.Deployable, newdpl *appv1alpha1.Deplo
 	tmpdpl.Spec.Template = newdpl.Spec.Template.DeepCopy()
 
 	if !reflect.DeepEqual(tmpdpl.Spec, newdpl.Spec) {
-		klog.V(5).Infof("old spec: %#v, new spec: %#v", tmpdpl.Spec, newdpl.Spec)
+		log.V(5).Info("Specs differ", "old", tmpdpl.Spec, "new", newdpl.Spec)
 		return false
 	}
 
 	return true
 }
 
+// TemplateHash returns the hash of the template content, whatever the formatting of its raw JSON.
+func TemplateHash(template *runtime.RawExtension) string {
+	if template == nil || template.Raw == nil {
+		return ""
+	}
+
+	raw := template.Raw
+
+	var content interface{}
+	if err := json.Unmarshal(template.Raw, &content); err == nil {
+		if normalized, err := json.Marshal(content); err == nil {
+			raw = normalized
+		}
+	}
+
+	sum := sha256.Sum256(raw)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// IsDeployableDrifted tells if the template of the child deployable no longer matches the hash recorded when it was propagated.
+// Children propagated before the hash was recorded are never drifted.
+func IsDeployableDrifted(dpl *appv1alpha1.Deployable) bool {
+	hash := dpl.GetAnnotations()[appv1alpha1.AnnotationTemplateHash]
+
+	return hash != "" && hash != TemplateHash(dpl.Spec.Template)
+}
+
+// RollbackRollingUpdate restores the template and overrides saved when the rolling update started, and removes
+// the rolling update target so the rolling update is not started again. It returns false if there is nothing to restore.
+func RollbackRollingUpdate(dpl *appv1alpha1.Deployable) bool {
+	rus := dpl.Status.RollingUpdate
+	if rus == nil || rus.PreviousTemplate == nil {
+		return false
+	}
+
+	dpl.Spec.Template = rus.PreviousTemplate.DeepCopy()
+	dpl.Spec.Overrides = nil
+
+	for _, ov := range rus.PreviousOverrides {
+		dpl.Spec.Overrides = append(dpl.Spec.Overrides, *(ov.DeepCopy()))
+	}
+
+	annotations := dpl.GetAnnotations()
+	delete(annotations, appv1alpha1.AnnotationRollingUpdateTarget)
+	dpl.SetAnnotations(annotations)
+
+	return true
+}
+
 // PrepareInstance prepares the deployable instane for later actions
 func PrepareInstance(instance *appv1alpha1.Deployable) bool {
-	if klog.V(QuiteLogLel) {
+	if log.V(QuiteLogLel).Enabled() {
 		fnName := GetFnName()
-		klog.Infof("Entering: %v()", fnName)
+		log.V(QuiteLogLel).Info("Entering", "function", fnName)
 
-		defer klog.Infof("Exiting: %v()", fnName)
+		defer log.V(QuiteLogLel).Info("Exiting", "function", fnName)
 	}
 
 	original := instance.DeepCopy()
@@ -175,11 +226,11 @@ func PrepareInstance(instance *appv1alpha1.Deployable) bool {
 
 // GetUnstructuredTemplateFromDeployable return error if needed
 func GetUnstructuredTemplateFromDeployable(instance *appv1alpha1.Deployable) (*unstructured.Unstructured, error) {
-	if klog.V(QuiteLogLel) {
+	if log.V(QuiteLogLel).Enabled() {
 		fnName := GetFnName()
-		klog.Infof("Entering: %v()", fnName)
+		log.V(QuiteLogLel).Info("Entering", "function", fnName)
 
-		defer klog.Infof("Exiting: %v()", fnName)
+		defer log.V(QuiteLogLel).Info("Exiting", "function", fnName)
 	}
 
 	template := &unstructured.Unstructured{}
@@ -189,10 +240,10 @@ func GetUnstructuredTemplateFromDeployable(instance *appv1alpha1.Deployable) (*u
 	}
 
 	err := json.Unmarshal(instance.Spec.Template.Raw, template)
-	klog.V(10).Info("Processing Local with template:", template)
+	log.V(10).Info("Processing Local with template", "template", template)
 
 	if err != nil {
-		klog.Error("Failed to unmashal template with error: ", err)
+		log.Error(err, "Failed to unmarshal template")
 
 		return nil, err
 	}
@@ -204,13 +255,104 @@ func GetUnstructuredTemplateFromDeployable(instance *appv1alpha1.Deployable) (*u
 	return template, nil
 }
 
+// TemplateObjects returns the resources of the template, the items of a List template.
+func TemplateObjects(tpl *runtime.RawExtension) ([]*unstructured.Unstructured, error) {
+	if tpl == nil {
+		return nil, nil
+	}
+
+	if !IsListTemplate(tpl) {
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(tpl.Raw); err != nil {
+			return nil, err
+		}
+
+		return []*unstructured.Unstructured{obj}, nil
+	}
+
+	list := &unstructured.UnstructuredList{}
+	if err := list.UnmarshalJSON(tpl.Raw); err != nil {
+		return nil, err
+	}
+
+	objs := make([]*unstructured.Unstructured, 0, len(list.Items))
+	for i := range list.Items {
+		objs = append(objs, &list.Items[i])
+	}
+
+	return objs, nil
+}
+
+// AnnotateTemplateObjects sets the annotation in the resources of the template, the items of a List template.
+func AnnotateTemplateObjects(tpl *runtime.RawExtension, key, value string) (*runtime.RawExtension, error) {
+	objs, err := TemplateObjects(tpl)
+	if err != nil || objs == nil {
+		return tpl, err
+	}
+
+	for _, obj := range objs {
+		annotations := obj.GetAnnotations()
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+
+		annotations[key] = value
+		obj.SetAnnotations(annotations)
+	}
+
+	var raw []byte
+
+	if IsListTemplate(tpl) {
+		list := &unstructured.UnstructuredList{Object: map[string]interface{}{"apiVersion": "v1", "kind": "List"}}
+		for _, obj := range objs {
+			list.Items = append(list.Items, *obj)
+		}
+
+		raw, err = list.MarshalJSON()
+	} else {
+		raw, err = objs[0].MarshalJSON()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &runtime.RawExtension{Raw: raw}, nil
+}
+
+// GetRecordedUser returns the user that last changed what the deployable propagates and its groups, recorded at admission.
+func GetRecordedUser(obj metav1.Object) (string, []string) {
+	annotations := obj.GetAnnotations()
+
+	var groups []string
+
+	if annotations[appv1alpha1.AnnotationUserGroups] != "" {
+		groups = strings.Split(annotations[appv1alpha1.AnnotationUserGroups], ",")
+	}
+
+	return annotations[appv1alpha1.AnnotationUserIdentity], groups
+}
+
+// SetRecordedUser records the user that last changed what the deployable propagates and its groups.
+func SetRecordedUser(obj metav1.Object, user string, groups []string) {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+
+	annotations[appv1alpha1.AnnotationUserIdentity] = user
+	annotations[appv1alpha1.AnnotationUserGroups] = strings.Join(groups, ",")
+
+	obj.SetAnnotations(annotations)
+}
+
 // GetClusterFromResourceObject return nil if no host is found
 func GetClusterFromResourceObject(obj metav1.Object) *types.NamespacedName {
-	if klog.V(QuiteLogLel) {
+	if log.V(QuiteLogLel).Enabled() {
 		fnName := GetFnName()
-		klog.Infof("Entering: %v()", fnName)
+		log.V(QuiteLogLel).Info("Entering", "function", fnName)
 
-		defer klog.Infof("Exiting: %v()", fnName)
+		defer log.V(QuiteLogLel).Info("Exiting", "function", fnName)
 	}
 
 	if obj == nil {
@@ -242,11 +384,11 @@ func GetClusterFromResourceObject(obj metav1.Object) *types.NamespacedName {
 
 // GetHostDeployableFromObject return nil if no host is found
 func GetHostDeployableFromObject(obj metav1.Object) *types.NamespacedName {
-	if klog.V(QuiteLogLel) {
+	if log.V(QuiteLogLel).Enabled() {
 		fnName := GetFnName()
-		klog.Infof("Entering: %v()", fnName)
+		log.V(QuiteLogLel).Info("Entering", "function", fnName)
 
-		defer klog.Infof("Exiting: %v()", fnName)
+		defer log.V(QuiteLogLel).Info("Exiting", "function", fnName)
 	}
 
 	if obj == nil {
@@ -278,11 +420,11 @@ func GetHostDeployableFromObject(obj metav1.Object) *types.NamespacedName {
 
 // IsDependencyDeployable return true the deploable is dependent depolyable
 func IsDependencyDeployable(instance *appv1alpha1.Deployable) bool {
-	if klog.V(QuiteLogLel) {
+	if log.V(QuiteLogLel).Enabled() {
 		fnName := GetFnName()
-		klog.Infof("Entering: %v()", fnName)
+		log.V(QuiteLogLel).Info("Entering", "function", fnName)
 
-		defer klog.Infof("Exiting: %v()", fnName)
+		defer log.V(QuiteLogLel).Info("Exiting", "function", fnName)
 	}
 
 	if instance == nil {
@@ -319,7 +461,7 @@ func IsDependencyDeployable(instance *appv1alpha1.Deployable) bool {
 		return false
 	}
 
-	klog.V(10).Info("hostDeployable:", hostDeployable, ", genHhostDeployable:", genHhostDeployable)
+	log.V(10).Info("Got hosting deployable", "hostDeployable", hostDeployable, "genHostDeployable", genHhostDeployable)
 
 	return hostDeployable != genHhostDeployable
 }
@@ -328,11 +470,11 @@ func IsDependencyDeployable(instance *appv1alpha1.Deployable) bool {
 // - nil:  success
 // - others: failed, with error message in reason
 func UpdateDeployableStatus(statusClient client.Client, templateerr error, tplunit metav1.Object, status interface{}) error {
-	if klog.V(QuiteLogLel) {
+	if log.V(QuiteLogLel).Enabled() {
 		fnName := GetFnName()
-		klog.Infof("Entering: %v()", fnName)
+		log.V(QuiteLogLel).Info("Entering", "function", fnName)
 
-		defer klog.Infof("Exiting: %v()", fnName)
+		defer log.V(QuiteLogLel).Info("Exiting", "function", fnName)
 	}
 
 	dpl := &appv1alpha1.Deployable{}
@@ -341,7 +483,7 @@ func UpdateDeployableStatus(statusClient client.Client, templateerr error, tplun
 	var err error
 
 	if host == nil {
-		klog.Info("Failed to find hosting deployable for ", tplunit)
+		log.Info("Failed to find hosting deployable", "unit", tplunit.GetNamespace()+"/"+tplunit.GetName())
 	} else {
 		err = statusClient.Get(context.TODO(), *host, dpl)
 
@@ -351,7 +493,7 @@ func UpdateDeployableStatus(statusClient client.Client, templateerr error, tplun
 		}
 	}
 
-	klog.V(10).Info("Trying to update deployable status:", host, templateerr)
+	log.V(10).Info("Trying to update deployable status", "deployable", host, "error", templateerr)
 
 	dpl.Status.PropagatedStatus = nil
 	if templateerr == nil {
@@ -370,7 +512,7 @@ func UpdateDeployableStatus(statusClient client.Client, templateerr error, tplun
 		dpl.Status.ResourceStatus.Raw, err = json.Marshal(status)
 
 		if err != nil {
-			klog.Info("Failed to mashall status for ", host, status, " with err:", err)
+			log.Info("Failed to marshal status", "deployable", host, "status", status, "error", err)
 		}
 	}
 
@@ -379,7 +521,7 @@ func UpdateDeployableStatus(statusClient client.Client, templateerr error, tplun
 	err = statusClient.Status().Update(context.Background(), dpl)
 	// want to print out the error log before leave
 	if err != nil {
-		klog.Error("Failed to update status of deployable ", dpl)
+		log.Error(err, "Failed to update status of deployable", "deployable", dpl.GetNamespace()+"/"+dpl.GetName())
 	}
 
 	return err
@@ -399,7 +541,7 @@ func ContainsName(a []types.NamespacedName, x string) bool {
 // PrintPropagatedStatus output Propagated Status for each cluster
 func PrintPropagatedStatus(r map[string]*appv1alpha1.ResourceUnitStatus, msg string) {
 	for cluster, unitStatus := range r {
-		klog.Infof("%v - cluster: %v, unit status: %#v", msg, cluster, unitStatus)
+		log.Info(msg, "cluster", cluster, "status", unitStatus)
 	}
 }
 
@@ -433,7 +575,7 @@ func SetPauseLabelDplSubTpl(instance, targetdpl *appv1alpha1.Deployable) error {
 
 	err := json.Unmarshal(targetdpl.Spec.Template.Raw, targetTpl)
 	if err != nil {
-		klog.Error("Failed to unmashal target deployable subscription template with error: ", err)
+		log.Error(err, "Failed to unmarshal target deployable subscription template")
 		return err
 	}
 
@@ -456,7 +598,7 @@ func SetPauseLabelDplSubTpl(instance, targetdpl *appv1alpha1.Deployable) error {
 
 	targetdpl.Spec.Template.Raw, err = json.Marshal(targetTpl)
 	if err != nil {
-		klog.Error("Error in mashalling targetTpl obj")
+		log.Error(err, "Failed to marshal target template")
 		return err
 	}
 
@@ -20,6 +20,7 @@ import (
 	"github.com/onsi/gomega"
 	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 )
 
@@ -162,3 +163,24 @@ func TestPrepareInstance(t *testing.T) {
 	b = PrepareInstance(newDepl)
 	g.Expect(b).To(gomega.Equal(false))
 }
+
+func TestTemplateHash(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	tpl := &runtime.RawExtension{Raw: []byte(`{"kind":"ConfigMap","data":{"a":"1","b":"2"}}`)}
+	reformatted := &runtime.RawExtension{Raw: []byte(`{ "data": {"b": "2", "a": "1"}, "kind": "ConfigMap" }`)}
+	edited := &runtime.RawExtension{Raw: []byte(`{"kind":"ConfigMap","data":{"a":"1","b":"3"}}`)}
+
+	g.Expect(TemplateHash(nil)).To(gomega.BeEmpty())
+	g.Expect(TemplateHash(reformatted)).To(gomega.Equal(TemplateHash(tpl)))
+	g.Expect(TemplateHash(edited)).NotTo(gomega.Equal(TemplateHash(tpl)))
+
+	dpl := &appv1alpha1.Deployable{Spec: appv1alpha1.DeployableSpec{Template: edited}}
+	g.Expect(IsDeployableDrifted(dpl)).To(gomega.BeFalse())
+
+	dpl.SetAnnotations(map[string]string{appv1alpha1.AnnotationTemplateHash: TemplateHash(tpl)})
+	g.Expect(IsDeployableDrifted(dpl)).To(gomega.BeTrue())
+
+	dpl.Spec.Template = reformatted
+	g.Expect(IsDeployableDrifted(dpl)).To(gomega.BeFalse())
+}
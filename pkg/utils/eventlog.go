@@ -15,6 +15,7 @@
 package utils
 
 import (
+	"fmt"
 	"regexp"
 	"runtime"
 
@@ -24,9 +25,12 @@ import (
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/record"
-	"k8s.io/klog"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
+// log is the logger of the utilities, writing to the logger of the controllers.
+var log = logf.Log.WithName("utils")
+
 // QuiteLogLel - "important" information
 const QuiteLogLel = 4
 
@@ -58,6 +62,9 @@ func EnterFnString() string {
 // ExitFuString - called when exiting a function
 func ExitFuString(s string) {}
 
+// EventComponent is the source component of the events recorded by the deployable controller.
+const EventComponent = "deployable-controller"
+
 // EventRecorder - record kubernetes event
 type EventRecorder struct {
 	record.EventRecorder
@@ -67,16 +74,18 @@ type EventRecorder struct {
 func NewEventRecorder(cfg *rest.Config, scheme *apiruntime.Scheme) (*EventRecorder, error) {
 	reccs, err := kubernetes.NewForConfig(cfg)
 	if err != nil {
-		klog.Error("Failed to new clientset for event recorder. err: ", err)
+		log.Error(err, "Failed to create the clientset of the event recorder")
 		return nil, err
 	}
 
 	rec := &EventRecorder{}
 	eventBroadcaster := record.NewBroadcaster()
-	eventBroadcaster.StartLogging(klog.Infof)
+	eventBroadcaster.StartLogging(func(format string, args ...interface{}) {
+		log.Info(fmt.Sprintf(format, args...))
+	})
 	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: reccs.CoreV1().Events("")})
 
-	rec.EventRecorder = eventBroadcaster.NewRecorder(scheme, corev1.EventSource{Component: "subscription"})
+	rec.EventRecorder = eventBroadcaster.NewRecorder(scheme, corev1.EventSource{Component: EventComponent})
 
 	return rec, nil
 }
@@ -0,0 +1,344 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+)
+
+// HelmChartKind is the kind of the deployable templates rendered from a Helm chart on the hub.
+const HelmChartKind = "HelmChart"
+
+// chartCacheTTL is how long a loaded chart is reused before its source is read again.
+const chartCacheTTL = time.Minute
+
+// HelmChartTemplate is a deployable template rendered from a Helm chart. The name and namespace are the ones of the release.
+type HelmChartTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec HelmChartSpec `json:"spec"`
+}
+
+// HelmChartSpec tells the chart to render and its values. The cluster overrides of the deployable apply to the values,
+// like spec.values.replicaCount.
+type HelmChartSpec struct {
+	Chart  HelmChartSource        `json:"chart"`
+	Values map[string]interface{} `json:"values,omitempty"`
+}
+
+// HelmChartSource is where the chart is read from, a path of the controller or an OCI artifact.
+type HelmChartSource struct {
+	// Path of a chart directory or archive, like a mounted volume.
+	Path string `json:"path,omitempty"`
+	// OCI reference of a chart pushed to a registry, like oci://ghcr.io/org/charts/app:1.2.0.
+	OCI string `json:"oci,omitempty"`
+}
+
+// helmChartSources are the directories of the chart paths and the registry hosts the HelmChart templates may use.
+var helmChartSources struct {
+	paths      []string
+	registries []string
+}
+
+// SetHelmChartSources sets the directories the HelmChart templates may read a chart path from, and the registry hosts,
+// as host or host:port, they may pull an oci chart from. No chart may be read from a source not set.
+func SetHelmChartSources(paths, registries []string) {
+	helmChartSources.paths = paths
+	helmChartSources.registries = registries
+}
+
+// isChartPathAllowed tells if the chart path, its symbolic links resolved, is in a directory of the chart paths.
+func isChartPathAllowed(chartPath string) bool {
+	resolved := resolvePath(chartPath)
+
+	for _, dir := range helmChartSources.paths {
+		rel, err := filepath.Rel(resolvePath(dir), resolved)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolvePath returns the absolute path with the symbolic links resolved, or cleaned if it does not exist.
+func resolvePath(p string) string {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return filepath.Clean(p)
+	}
+
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		return resolved
+	}
+
+	return abs
+}
+
+// isRegistryAllowed tells if the charts may be pulled from the registry host.
+func isRegistryAllowed(host string) bool {
+	for _, registry := range helmChartSources.registries {
+		if strings.EqualFold(registry, host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type cachedChart struct {
+	chart    *chart.Chart
+	loadedAt time.Time
+}
+
+var chartCache = struct {
+	sync.Mutex
+	charts map[string]cachedChart
+}{charts: make(map[string]cachedChart)}
+
+// IsHelmChartTemplate tells if the template is rendered from a Helm chart.
+func IsHelmChartTemplate(tpl *runtime.RawExtension) bool {
+	if tpl == nil || tpl.Raw == nil {
+		return false
+	}
+
+	meta := &metav1.TypeMeta{}
+	if err := json.Unmarshal(tpl.Raw, meta); err != nil {
+		return false
+	}
+
+	return meta.Kind == HelmChartKind && meta.APIVersion == appv1alpha1.SchemeGroupVersion.String()
+}
+
+// RenderHelmChart renders the Helm chart template into a List of the resources of the chart, custom resource definitions
+// first and then in the install order of Helm. Hooks and notes are not rendered.
+func RenderHelmChart(ctx context.Context, tpl *runtime.RawExtension) (*runtime.RawExtension, *chart.Metadata, error) {
+	helmtpl := &HelmChartTemplate{}
+	if err := json.Unmarshal(tpl.Raw, helmtpl); err != nil {
+		return nil, nil, err
+	}
+
+	cached, err := loadChart(ctx, helmtpl.Spec.Chart)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the dependencies are processed in a copy, the values of each render pruning and importing differently
+	ch := copyChart(cached)
+
+	values := helmtpl.Spec.Values
+	if values == nil {
+		values = make(map[string]interface{})
+	}
+
+	if err := chartutil.ProcessDependencies(ch, values); err != nil {
+		return nil, nil, err
+	}
+
+	options := chartutil.ReleaseOptions{Name: helmtpl.GetName(), Namespace: helmtpl.GetNamespace(), Revision: 1, IsInstall: true}
+
+	renderValues, err := chartutil.ToRenderValues(ch, values, options, chartutil.DefaultCapabilities)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files, err := engine.Render(ch, renderValues)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for name := range files {
+		if ext := path.Ext(name); ext != ".yaml" && ext != ".yml" && ext != ".json" {
+			delete(files, name)
+		}
+	}
+
+	_, manifests, err := releaseutil.SortManifests(files, chartutil.DefaultCapabilities.APIVersions, releaseutil.InstallOrder)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var docs []string
+
+	for _, crd := range ch.CRDObjects() {
+		split := releaseutil.SplitManifests(string(crd.File.Data))
+
+		keys := make([]string, 0, len(split))
+		for key := range split {
+			keys = append(keys, key)
+		}
+
+		sort.Sort(releaseutil.BySplitManifestsOrder(keys))
+
+		for _, key := range keys {
+			docs = append(docs, split[key])
+		}
+	}
+
+	for _, manifest := range manifests {
+		docs = append(docs, manifest.Content)
+	}
+
+	list := &unstructured.UnstructuredList{Object: map[string]interface{}{"apiVersion": "v1", "kind": "List"}}
+
+	for _, doc := range docs {
+		obj := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal([]byte(doc), &obj.Object); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse the rendered chart %s: %v", ch.Name(), err)
+		}
+
+		if len(obj.Object) > 0 {
+			list.Items = append(list.Items, *obj)
+		}
+	}
+
+	raw, err := list.MarshalJSON()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &runtime.RawExtension{Raw: raw}, ch.Metadata, nil
+}
+
+// loadChart loads the chart from its source, reusing the charts loaded in the last minute.
+func loadChart(ctx context.Context, source HelmChartSource) (*chart.Chart, error) {
+	if source.Path != "" && !isChartPathAllowed(source.Path) {
+		return nil, fmt.Errorf("the chart path %s is not in a directory of --helm-chart-paths", source.Path)
+	}
+
+	key := source.Path + "|" + source.OCI
+
+	chartCache.Lock()
+	cached, ok := chartCache.charts[key]
+	chartCache.Unlock()
+
+	if ok && time.Since(cached.loadedAt) < chartCacheTTL {
+		return cached.chart, nil
+	}
+
+	var (
+		ch  *chart.Chart
+		err error
+	)
+
+	switch {
+	case source.Path != "":
+		ch, err = loader.Load(source.Path)
+	case source.OCI != "":
+		var data []byte
+
+		if data, err = PullOCIChart(ctx, source.OCI); err == nil {
+			ch, err = loader.LoadArchive(bytes.NewReader(data))
+		}
+	default:
+		err = fmt.Errorf("the chart of a %s template needs a path or an oci reference", HelmChartKind)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	chartCache.Lock()
+	chartCache.charts[key] = cachedChart{chart: ch, loadedAt: time.Now()}
+	chartCache.Unlock()
+
+	return ch, nil
+}
+
+// copyChart copies the parts of the chart ProcessDependencies changes: the metadata of the dependencies, the values
+// and the subcharts. The templates and the files are shared.
+func copyChart(ch *chart.Chart) *chart.Chart {
+	copied := *ch
+
+	if ch.Metadata != nil {
+		metadata := *ch.Metadata
+		metadata.Dependencies = make([]*chart.Dependency, 0, len(ch.Metadata.Dependencies))
+
+		for _, dependency := range ch.Metadata.Dependencies {
+			d := *dependency
+			metadata.Dependencies = append(metadata.Dependencies, &d)
+		}
+
+		copied.Metadata = &metadata
+	}
+
+	copied.Values, _ = copyValue(ch.Values).(map[string]interface{})
+
+	subcharts := make([]*chart.Chart, 0, len(ch.Dependencies()))
+	for _, subchart := range ch.Dependencies() {
+		subcharts = append(subcharts, copyChart(subchart))
+	}
+
+	copied.SetDependencies(subcharts...)
+
+	return &copied
+}
+
+// copyValue deeply copies the maps and the slices of chart values.
+func copyValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			copied[k] = copyValue(v)
+		}
+
+		return copied
+	case chartutil.Values:
+		return copyValue(map[string]interface{}(value))
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for i, v := range value {
+			copied[i] = copyValue(v)
+		}
+
+		return copied
+	default:
+		return value
+	}
+}
+
+// IsListTemplate tells if the template is a List of resources, like a rendered Helm chart.
+func IsListTemplate(tpl *runtime.RawExtension) bool {
+	if tpl == nil || tpl.Raw == nil {
+		return false
+	}
+
+	meta := &metav1.TypeMeta{}
+
+	return json.Unmarshal(tpl.Raw, meta) == nil && meta.Kind == "List" && meta.APIVersion == "v1"
+}
@@ -0,0 +1,182 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// TemplateKindsPolicyKey is the key of the template kinds policy in the data of its ConfigMap.
+const TemplateKindsPolicyKey = "policy.yaml"
+
+// templateKindsPolicyTTL is how long the policy of the ConfigMap is reused before it is read again.
+const templateKindsPolicyTTL = time.Minute
+
+// TemplateKindRules list the kinds that may or may not be propagated, as Kind for the core group and Kind.group
+// for the others, like ClusterRoleBinding.rbac.authorization.k8s.io. * matches every kind, and *.group every kind
+// of the group. The versions are not matched.
+type TemplateKindRules struct {
+	// Allowed kinds, every kind if empty.
+	Allowed []string `json:"allowed,omitempty"`
+	// Denied kinds, even if allowed.
+	Denied []string `json:"denied,omitempty"`
+}
+
+// TemplateKindsPolicy has the rules of the deployable namespaces, and the default rules of the other namespaces.
+type TemplateKindsPolicy struct {
+	TemplateKindRules `json:",inline"`
+	// Namespaces replaces the default rules for the deployables of these namespaces.
+	Namespaces map[string]TemplateKindRules `json:"namespaces,omitempty"`
+}
+
+var templateKindsPolicy = struct {
+	sync.Mutex
+	flags     TemplateKindsPolicy
+	configMap *types.NamespacedName
+	cached    *TemplateKindsPolicy
+	loadedAt  time.Time
+}{}
+
+// SetTemplateKindsPolicy sets the policy of the manager flags, and the ConfigMap whose policy replaces it when found.
+func SetTemplateKindsPolicy(flags TemplateKindsPolicy, configMap *types.NamespacedName) {
+	templateKindsPolicy.Lock()
+	defer templateKindsPolicy.Unlock()
+
+	templateKindsPolicy.flags = flags
+	templateKindsPolicy.configMap = configMap
+	templateKindsPolicy.cached = nil
+}
+
+// GetTemplateKindsPolicy returns the policy of the ConfigMap, or the policy of the manager flags if there is no ConfigMap.
+func GetTemplateKindsPolicy(ctx context.Context, reader client.Reader) (*TemplateKindsPolicy, error) {
+	templateKindsPolicy.Lock()
+	defer templateKindsPolicy.Unlock()
+
+	flags := templateKindsPolicy.flags.DeepCopy()
+
+	if templateKindsPolicy.configMap == nil {
+		return flags, nil
+	}
+
+	if templateKindsPolicy.cached != nil && time.Since(templateKindsPolicy.loadedAt) < templateKindsPolicyTTL {
+		return templateKindsPolicy.cached.DeepCopy(), nil
+	}
+
+	policy := flags
+	cm := &corev1.ConfigMap{}
+
+	err := reader.Get(ctx, *templateKindsPolicy.configMap, cm)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	if err == nil {
+		policy = &TemplateKindsPolicy{}
+		if err := yaml.Unmarshal([]byte(cm.Data[TemplateKindsPolicyKey]), policy); err != nil {
+			return nil, fmt.Errorf("invalid template kinds policy in configmap %s: %v", templateKindsPolicy.configMap, err)
+		}
+	}
+
+	templateKindsPolicy.cached = policy
+	templateKindsPolicy.loadedAt = time.Now()
+
+	return policy.DeepCopy(), nil
+}
+
+// DeepCopy copies the policy.
+func (p *TemplateKindsPolicy) DeepCopy() *TemplateKindsPolicy {
+	out := &TemplateKindsPolicy{TemplateKindRules: p.TemplateKindRules.deepCopy()}
+
+	if p.Namespaces != nil {
+		out.Namespaces = make(map[string]TemplateKindRules, len(p.Namespaces))
+		for ns, rules := range p.Namespaces {
+			out.Namespaces[ns] = rules.deepCopy()
+		}
+	}
+
+	return out
+}
+
+func (r TemplateKindRules) deepCopy() TemplateKindRules {
+	return TemplateKindRules{Allowed: append([]string(nil), r.Allowed...), Denied: append([]string(nil), r.Denied...)}
+}
+
+// IsEmpty tells if the policy allows every kind everywhere.
+func (p *TemplateKindsPolicy) IsEmpty() bool {
+	if len(p.Allowed) > 0 || len(p.Denied) > 0 {
+		return false
+	}
+
+	for _, rules := range p.Namespaces {
+		if len(rules.Allowed) > 0 || len(rules.Denied) > 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Check returns why the resources may not be propagated by a deployable of the namespace, nil if they may.
+func (p *TemplateKindsPolicy) Check(namespace string, objs []*unstructured.Unstructured) error {
+	rules, ok := p.Namespaces[namespace]
+	if !ok {
+		rules = p.TemplateKindRules
+	}
+
+	for _, obj := range objs {
+		gk := obj.GroupVersionKind().GroupKind()
+
+		if matchKinds(rules.Denied, gk) {
+			return fmt.Errorf("kind %s is denied in namespace %s", gk, namespace)
+		}
+
+		if len(rules.Allowed) > 0 && !matchKinds(rules.Allowed, gk) {
+			return fmt.Errorf("kind %s is not allowed in namespace %s", gk, namespace)
+		}
+	}
+
+	return nil
+}
+
+func matchKinds(patterns []string, gk schema.GroupKind) bool {
+	for _, pattern := range patterns {
+		if pattern == "*" {
+			return true
+		}
+
+		kind, group := pattern, ""
+		if i := strings.Index(pattern, "."); i >= 0 {
+			kind, group = pattern[:i], pattern[i+1:]
+		}
+
+		if (kind == "*" || kind == gk.Kind) && (group == "*" || group == gk.Group) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -27,16 +27,15 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/rest"
-	"k8s.io/klog"
 )
 
 // ConvertLabels coverts label selector to lables.Selector
 func ConvertLabels(labelSelector *metav1.LabelSelector) (labels.Selector, error) {
-	if klog.V(QuiteLogLel) {
+	if log.V(QuiteLogLel).Enabled() {
 		fnName := GetFnName()
-		klog.Infof("Entering: %v()", fnName)
+		log.V(QuiteLogLel).Info("Entering", "function", fnName)
 
-		defer klog.Infof("Exiting: %v()", fnName)
+		defer log.V(QuiteLogLel).Info("Exiting", "function", fnName)
 	}
 
 	if labelSelector != nil {
@@ -59,7 +58,7 @@ func CheckAndInstallCRD(crdconfig *rest.Config, pathname string) error {
 
 	crdClient, err := crdclientset.NewForConfig(crdconfig)
 	if err != nil {
-		klog.Fatalf("Error building cluster registry clientset: %s", err.Error())
+		log.Error(err, "Failed to build the apiextensions clientset")
 		return err
 	}
 
@@ -70,39 +69,39 @@ func CheckAndInstallCRD(crdconfig *rest.Config, pathname string) error {
 	crddata, err = ioutil.ReadFile(filepath.Clean(pathname))
 
 	if err != nil {
-		klog.Fatal("Loading app crd file", err.Error())
+		log.Error(err, "Failed to load the CRD file", "file", pathname)
 		return err
 	}
 
 	err = yaml.Unmarshal(crddata, &crdobj)
 
 	if err != nil {
-		klog.Fatal("Unmarshal app crd ", err.Error(), "\n", string(crddata))
+		log.Error(err, "Failed to unmarshal the CRD", "file", pathname)
 		return err
 	}
 
-	klog.V(10).Info("Loaded Application CRD: ", crdobj, "\n - From - \n", string(crddata))
+	log.V(10).Info("Loaded CRD", "crd", crdobj.GetName(), "file", pathname)
 
 	crd, err := crdClient.ApiextensionsV1beta1().CustomResourceDefinitions().Get(context.TODO(), crdobj.GetName(), metav1.GetOptions{})
 	if errors.IsNotFound(err) {
-		klog.Info("Installing SIG Application CRD from File: ", pathname)
+		log.Info("Installing CRD", "file", pathname)
 		// Install sig app
 		_, err = crdClient.ApiextensionsV1beta1().CustomResourceDefinitions().Create(context.TODO(), &crdobj, metav1.CreateOptions{})
 		if err != nil {
-			klog.Fatal("Creating CRD", err.Error())
+			log.Error(err, "Failed to create the CRD", "file", pathname)
 			return err
 		}
 	} else {
 		if !reflect.DeepEqual(crd.Spec, crdobj.Spec) {
-			klog.Info("CRD ", crdobj.GetName(), " is being updated with ", pathname)
+			log.Info("Updating CRD", "crd", crdobj.GetName(), "file", pathname)
 			crdobj.Spec.DeepCopyInto(&crd.Spec)
 			_, err = crdClient.ApiextensionsV1beta1().CustomResourceDefinitions().Update(context.TODO(), crd, metav1.UpdateOptions{})
 			if err != nil {
-				klog.Fatal("Updating CRD", err.Error())
+				log.Error(err, "Failed to update the CRD", "file", pathname)
 				return err
 			}
 		} else {
-			klog.Info("CRD ", crdobj.GetName(), " exists: ", pathname)
+			log.Info("CRD exists", "crd", crdobj.GetName(), "file", pathname)
 		}
 		return err
 	}
@@ -0,0 +1,187 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/kustomize/api/filesys"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/yaml"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+)
+
+// PrepareOverlays returns the kustomize overlays of the cluster, those of the overrides selecting the cluster
+// by name or by labels, in the order of the overrides.
+func PrepareOverlays(cluster types.NamespacedName, clusterLabels labels.Set, instance *appv1alpha1.Deployable) []appv1alpha1.KustomizeOverlay {
+	if instance == nil {
+		return nil
+	}
+
+	var overlays []appv1alpha1.KustomizeOverlay
+
+	for _, ov := range instance.Spec.Overrides {
+		if ov.Kustomize == nil {
+			continue
+		}
+
+		if ov.ClusterSelector == nil {
+			if ov.ClusterName == cluster.Name {
+				overlays = append(overlays, *ov.Kustomize.DeepCopy())
+			}
+
+			continue
+		}
+
+		selector, err := ConvertLabels(ov.ClusterSelector)
+		if err != nil {
+			log.Info("Invalid cluster selector in overrides", "error", err)
+			continue
+		}
+
+		if selector.Matches(clusterLabels) {
+			overlays = append(overlays, *ov.Kustomize.DeepCopy())
+		}
+	}
+
+	return overlays
+}
+
+// KustomizeTemplate applies the kustomize overlays one after the other to the template, or to the resources
+// of a List template. A template of one resource stays one resource, unless the overlays make more.
+func KustomizeTemplate(tpl *runtime.RawExtension, overlays []appv1alpha1.KustomizeOverlay) (*runtime.RawExtension, error) {
+	if tpl == nil || len(overlays) == 0 {
+		return tpl, nil
+	}
+
+	var resources []interface{}
+
+	isList := IsListTemplate(tpl)
+
+	if isList {
+		list := &unstructured.UnstructuredList{}
+		if err := list.UnmarshalJSON(tpl.Raw); err != nil {
+			return nil, err
+		}
+
+		for _, item := range list.Items {
+			resources = append(resources, item.Object)
+		}
+	} else {
+		obj := &unstructured.Unstructured{}
+		if err := json.Unmarshal(tpl.Raw, &obj.Object); err != nil {
+			return nil, err
+		}
+
+		resources = append(resources, obj.Object)
+	}
+
+	fs := filesys.MakeFsInMemory()
+
+	if err := writeKustomization(fs, "/base", map[string]interface{}{"resources": []string{"resources.yaml"}}); err != nil {
+		return nil, err
+	}
+
+	var docs bytes.Buffer
+
+	for _, resource := range resources {
+		doc, err := yaml.Marshal(resource)
+		if err != nil {
+			return nil, err
+		}
+
+		docs.WriteString("---\n")
+		docs.Write(doc)
+	}
+
+	if err := fs.WriteFile("/base/resources.yaml", docs.Bytes()); err != nil {
+		return nil, err
+	}
+
+	dir := "/base"
+
+	for i, overlay := range overlays {
+		kustomization := make(map[string]interface{})
+
+		raw, err := json.Marshal(overlay)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(raw, &kustomization); err != nil {
+			return nil, err
+		}
+
+		kustomization["resources"] = []string{".." + dir}
+		dir = fmt.Sprintf("/overlay-%d", i)
+
+		if err := writeKustomization(fs, dir, kustomization); err != nil {
+			return nil, err
+		}
+	}
+
+	resmap, err := krusty.MakeKustomizer(krusty.MakeDefaultOptions()).Run(fs, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &unstructured.UnstructuredList{Object: map[string]interface{}{"apiVersion": "v1", "kind": "List"}}
+
+	for _, resource := range resmap.Resources() {
+		obj, err := resource.Map()
+		if err != nil {
+			return nil, err
+		}
+
+		list.Items = append(list.Items, unstructured.Unstructured{Object: obj})
+	}
+
+	var raw []byte
+
+	if !isList && len(list.Items) == 1 {
+		raw, err = list.Items[0].MarshalJSON()
+	} else {
+		raw, err = list.MarshalJSON()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &runtime.RawExtension{Raw: raw}, nil
+}
+
+func writeKustomization(fs filesys.FileSystem, dir string, kustomization map[string]interface{}) error {
+	kustomization["apiVersion"] = "kustomize.config.k8s.io/v1beta1"
+	kustomization["kind"] = "Kustomization"
+
+	data, err := yaml.Marshal(kustomization)
+	if err != nil {
+		return err
+	}
+
+	if err := fs.MkdirAll(dir); err != nil {
+		return err
+	}
+
+	return fs.WriteFile(dir+"/kustomization.yaml", data)
+}
@@ -0,0 +1,236 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	ociManifestMediaType    = "application/vnd.oci.image.manifest.v1+json"
+	helmChartLayerMediaType = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+
+	// ociMaxManifestSize is the largest manifest or token response read from a registry.
+	ociMaxManifestSize = 4 << 20
+	// ociMaxChartSize is the largest chart archive pulled from a registry.
+	ociMaxChartSize = 20 << 20
+)
+
+// ociHTTPClient pulls the charts from the registries.
+var ociHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// ociReference is a chart pushed to a registry, oci://host/repository:tag.
+type ociReference struct {
+	host       string
+	repository string
+	tag        string
+}
+
+func parseOCIReference(ref string) (*ociReference, error) {
+	if !strings.HasPrefix(ref, "oci://") {
+		return nil, fmt.Errorf("invalid oci reference %q, expected oci://host/repository:tag", ref)
+	}
+
+	ref = strings.TrimPrefix(ref, "oci://")
+
+	slash := strings.Index(ref, "/")
+	colon := strings.LastIndex(ref, ":")
+
+	if slash <= 0 || colon < slash || colon == len(ref)-1 {
+		return nil, fmt.Errorf("invalid oci reference %q, expected oci://host/repository:tag", "oci://"+ref)
+	}
+
+	return &ociReference{host: ref[:slash], repository: ref[slash+1 : colon], tag: ref[colon+1:]}, nil
+}
+
+// PullOCIChart pulls the chart archive of the OCI reference from the registry, anonymously or with the bearer token
+// the registry hands out for pulling.
+func PullOCIChart(ctx context.Context, ref string) ([]byte, error) {
+	oref, err := parseOCIReference(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isRegistryAllowed(oref.host) {
+		return nil, fmt.Errorf("the registry %s is not in --helm-chart-registries", oref.host)
+	}
+
+	base := "https://" + oref.host + "/v2/" + oref.repository
+
+	body, err := ociGet(ctx, oref.host, base+"/manifests/"+oref.tag, ociManifestMediaType, ociMaxManifestSize)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := struct {
+		Layers []struct {
+			MediaType string `json:"mediaType"`
+			Digest    string `json:"digest"`
+		} `json:"layers"`
+	}{}
+
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse the manifest of %s: %v", ref, err)
+	}
+
+	for _, layer := range manifest.Layers {
+		if layer.MediaType != helmChartLayerMediaType {
+			continue
+		}
+
+		data, err := ociGet(ctx, oref.host, base+"/blobs/"+layer.Digest, layer.MediaType, ociMaxChartSize)
+		if err != nil {
+			return nil, err
+		}
+
+		sum := sha256.Sum256(data)
+		if "sha256:"+hex.EncodeToString(sum[:]) != layer.Digest {
+			return nil, fmt.Errorf("the chart layer of %s does not match its digest %s", ref, layer.Digest)
+		}
+
+		return data, nil
+	}
+
+	return nil, fmt.Errorf("%s is not a helm chart, it has no %s layer", ref, helmChartLayerMediaType)
+}
+
+// ociGet gets a registry URL, and retries once with a bearer token when the registry challenges for one from its own
+// host. The body is read up to limit bytes.
+func ociGet(ctx context.Context, host, target, accept string, limit int64) ([]byte, error) {
+	resp, err := ociDo(ctx, target, accept, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+
+		token, err := ociToken(ctx, host, challenge)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp, err = ociDo(ctx, target, accept, token); err != nil {
+			return nil, err
+		}
+	}
+
+	return ociRead(target, resp, limit)
+}
+
+// ociRead reads the body of a successful response, failing if it is larger than limit bytes.
+func ociRead(target string, resp *http.Response, limit int64) ([]byte, error) {
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get %s: %s", target, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(body)) > limit {
+		return nil, fmt.Errorf("failed to get %s: the response is larger than %d bytes", target, limit)
+	}
+
+	return body, nil
+}
+
+func ociDo(ctx context.Context, target, accept, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", accept)
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return ociHTTPClient.Do(req)
+}
+
+// ociToken gets an anonymous pull token from the realm of a Bearer challenge, only from the host of the registry.
+func ociToken(ctx context.Context, host, challenge string) (string, error) {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return "", fmt.Errorf("unsupported registry challenge %q", challenge)
+	}
+
+	params := make(map[string]string)
+
+	for _, param := range strings.Split(strings.TrimPrefix(challenge, "Bearer "), ",") {
+		if kv := strings.SplitN(strings.TrimSpace(param), "=", 2); len(kv) == 2 {
+			params[kv[0]] = strings.Trim(kv[1], `"`)
+		}
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("invalid registry challenge %q", challenge)
+	}
+
+	if !strings.EqualFold(realm.Host, host) {
+		return "", fmt.Errorf("the token realm %s is not on the registry host %s", params["realm"], host)
+	}
+
+	query := realm.Query()
+
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+
+	realm.RawQuery = query.Encode()
+
+	// the token endpoint is not challenged again
+	resp, err := ociDo(ctx, realm.String(), "application/json", "")
+	if err != nil {
+		return "", err
+	}
+
+	body, err := ociRead(realm.String(), resp, ociMaxManifestSize)
+	if err != nil {
+		return "", err
+	}
+
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", err
+	}
+
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+
+	return token.Token, nil
+}
@@ -17,6 +17,9 @@ package utils
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"reflect"
+	"sort"
 	"strings"
 
 	jsonpatch "github.com/cameront/go-jsonpatch"
@@ -24,65 +27,78 @@ import (
 	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
 
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/klog"
 )
 
 // GenerateOverrides compare 2 deployable and generate array for overrides
-func GenerateOverrides(src, dst *appv1alpha1.Deployable) (covs []appv1alpha1.ClusterOverride) {
-	defer func() {
-		if r := recover(); r != nil {
-			klog.V(5).Infof("Failed to make patch between the source and target deployables: r: %v", r)
+func GenerateOverrides(src, dst *appv1alpha1.Deployable) []appv1alpha1.ClusterOverride {
+	covs, err := diffTemplates(src, dst)
+	if err != nil {
+		log.Info("Failed to generate overrides", "error", err)
+	}
+
+	return covs
+}
 
-			ovmap := make(map[string]interface{})
-			ovmap["path"] = "."
-			ovmap["value"] = dst.Spec.Template.DeepCopy()
+// DiffOverrides generates the overrides turning the template of src into the template of dst.
+// fallback tells why the templates could not be diffed, the overrides then replace the whole template with the "." path.
+func DiffOverrides(src, dst *appv1alpha1.Deployable) (covs []appv1alpha1.ClusterOverride, fallback error) {
+	covs, fallback = diffTemplates(src, dst)
+	if fallback != nil && covs == nil {
+		covs = wholeTemplateOverride(dst)
+	}
 
-			patchb, err := json.Marshal(ovmap)
+	return covs, fallback
+}
 
-			if err != nil {
-				klog.Info("Error in marshal target target subscription template spec.packageOverride ", ovmap, " with error:", err)
+// diffTemplates generates the overrides turning the template of src into the template of dst, none if the templates
+// can not be decoded or diffed, and the override of the whole template if diffing them panics.
+func diffTemplates(src, dst *appv1alpha1.Deployable) (covs []appv1alpha1.ClusterOverride, err error) {
+	var srcobj, dstobj map[string]interface{}
 
-				covs = append(covs, appv1alpha1.ClusterOverride{})
-			} else {
-				clusterOverride := appv1alpha1.ClusterOverride{
-					RawExtension: runtime.RawExtension{
-						Raw: patchb,
-					},
-				}
+	defer func() {
+		if r := recover(); r != nil {
+			log.V(5).Info("Failed to make patch between the source and target deployables", "panic", r)
 
-				covs = append(covs, clusterOverride)
+			err = fmt.Errorf("failed to diff the templates: %v", r)
+			if path := firstTypeMismatch(srcobj, dstobj, ""); path != "" {
+				err = fmt.Errorf("failed to diff the templates, %s", path)
 			}
+
+			covs = wholeTemplateOverride(dst)
 		}
 	}()
 
-	if klog.V(QuiteLogLel) {
+	if log.V(QuiteLogLel).Enabled() {
 		fnName := GetFnName()
-		klog.Infof("Entering: %v()", fnName)
+		log.V(QuiteLogLel).Info("Entering", "function", fnName)
 
-		defer klog.Infof("Exiting: %v()", fnName)
+		defer log.V(QuiteLogLel).Info("Exiting", "function", fnName)
 	}
 
-	klog.V(10).Info("Start Generating patch. Src Template:", string(src.Spec.Template.Raw), " dst:", string(dst.Spec.Template.Raw))
+	log.V(10).Info("Start generating patch", "src", string(src.Spec.Template.Raw), "dst", string(dst.Spec.Template.Raw))
 
-	srcobj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(src.Spec.Template)
-
-	if err != nil {
-		klog.Info("Failed to decode src template ", string(src.Spec.Template.Raw))
+	if err := json.Unmarshal(templateRaw(src.Spec.Template), &srcobj); err != nil {
+		log.Info("Failed to decode src template", "template", string(src.Spec.Template.Raw))
+		return nil, fmt.Errorf("failed to decode the source template: %v", err)
 	}
 
-	dstobj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(dst.Spec.Template)
-
-	if err != nil {
-		klog.Info("Failed to decode dst template ", string(dst.Spec.Template.Raw))
+	if err := json.Unmarshal(templateRaw(dst.Spec.Template), &dstobj); err != nil {
+		log.Info("Failed to decode dst template", "template", string(dst.Spec.Template.Raw))
+		return nil, fmt.Errorf("failed to decode the target template: %v", err)
 	}
 
 	patch, err := jsonpatch.MakePatch(srcobj, dstobj)
-
 	if err != nil {
-		klog.Info("Error in generating patch for", string(src.Spec.Template.Raw), " with error:", err)
-		return covs
+		log.Info("Failed to generate patch", "template", string(src.Spec.Template.Raw), "error", err)
+
+		if path := firstTypeMismatch(srcobj, dstobj, ""); path != "" {
+			return nil, fmt.Errorf("failed to diff the templates, %s: %v", path, err)
+		}
+
+		return nil, fmt.Errorf("failed to diff the templates: %v", err)
 	}
 
 	for _, p := range patch.Operations {
@@ -99,25 +115,101 @@ func GenerateOverrides(src, dst *appv1alpha1.Deployable) (covs []appv1alpha1.Clu
 		patchb, err := json.Marshal(ovmap)
 
 		if err != nil {
-			klog.Info("Error in mashaing patch for", ovmap, " with error:", err)
-			continue
+			log.Info("Failed to marshal patch", "patch", ovmap, "error", err)
+			return nil, fmt.Errorf("failed to marshal the value %v of path %s: %v", p.Value, pathstr, err)
 		}
 
 		covs = append(covs, appv1alpha1.ClusterOverride{RawExtension: runtime.RawExtension{Raw: patchb}})
 	}
 
-	klog.V(5).Info("Got clusteroverrides ", covs)
+	log.V(5).Info("Got cluster overrides", "overrides", len(covs))
 
-	return covs
+	return covs, nil
+}
+
+// templateRaw returns the JSON of the template, encoding its object if it is not raw.
+func templateRaw(tpl *runtime.RawExtension) []byte {
+	if tpl == nil {
+		return nil
+	}
+
+	if tpl.Raw != nil || tpl.Object == nil {
+		return tpl.Raw
+	}
+
+	raw, err := json.Marshal(tpl.Object)
+	if err != nil {
+		return nil
+	}
+
+	return raw
+}
+
+// wholeTemplateOverride returns the override replacing the whole template with the template of dst.
+func wholeTemplateOverride(dst *appv1alpha1.Deployable) []appv1alpha1.ClusterOverride {
+	ovmap := make(map[string]interface{})
+	ovmap["path"] = "."
+	ovmap["value"] = dst.Spec.Template.DeepCopy()
+
+	patchb, err := json.Marshal(ovmap)
+	if err != nil {
+		log.Info("Failed to marshal the whole template override", "error", err)
+
+		return []appv1alpha1.ClusterOverride{{}}
+	}
+
+	return []appv1alpha1.ClusterOverride{{RawExtension: runtime.RawExtension{Raw: patchb}}}
+}
+
+// firstTypeMismatch returns the first path, in override path notation, whose value changes type between src and dst.
+func firstTypeMismatch(src, dst interface{}, path string) string {
+	if src == nil || dst == nil {
+		return ""
+	}
+
+	srcmap, srcok := src.(map[string]interface{})
+	dstmap, dstok := dst.(map[string]interface{})
+
+	if srcok && dstok {
+		keys := make([]string, 0, len(srcmap))
+		for k := range srcmap {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			subpath := k
+			if path != "" {
+				subpath = path + "." + k
+			}
+
+			if mismatch := firstTypeMismatch(srcmap[k], dstmap[k], subpath); mismatch != "" {
+				return mismatch
+			}
+		}
+
+		return ""
+	}
+
+	if reflect.TypeOf(src) != reflect.TypeOf(dst) {
+		if path == "" {
+			path = "."
+		}
+
+		return fmt.Sprintf("path %s changes from %T to %T with value %v", path, src, dst, dst)
+	}
+
+	return ""
 }
 
 // PrepareOverrides returns the overridemap for given deployable instance
 func PrepareOverrides(cluster types.NamespacedName, instance *appv1alpha1.Deployable) ([]appv1alpha1.ClusterOverride, error) {
-	if klog.V(QuiteLogLel) {
+	if log.V(QuiteLogLel).Enabled() {
 		fnName := GetFnName()
-		klog.Infof("Entering: %v()", fnName)
+		log.V(QuiteLogLel).Info("Entering", "function", fnName)
 
-		defer klog.Infof("Exiting: %v()", fnName)
+		defer log.V(QuiteLogLel).Info("Exiting", "function", fnName)
 	}
 
 	if instance == nil || instance.Spec.Overrides == nil {
@@ -128,6 +220,11 @@ func PrepareOverrides(cluster types.NamespacedName, instance *appv1alpha1.Deploy
 
 	// go over clsuters to find matching override
 	for _, ov := range instance.Spec.Overrides {
+		// overrides of a cluster group are prepared by PrepareSelectorOverrides, kustomize overlays by PrepareOverlays
+		if ov.ClusterSelector != nil || len(ov.ClusterOverrides) == 0 {
+			continue
+		}
+
 		if ov.ClusterName != cluster.Name && (ov.ClusterName != "/" || cluster.Name != "" || cluster.Namespace != "") {
 			continue
 		}
@@ -138,26 +235,54 @@ func PrepareOverrides(cluster types.NamespacedName, instance *appv1alpha1.Deploy
 	return overrides, nil
 }
 
+// PrepareSelectorOverrides returns the cluster overrides of the overrides selecting the cluster by labels, in the order
+// of the overrides. They are applied before those of the cluster named, which win on the same path.
+func PrepareSelectorOverrides(clusterLabels labels.Set, instance *appv1alpha1.Deployable) []appv1alpha1.ClusterOverride {
+	if instance == nil {
+		return nil
+	}
+
+	var overrides []appv1alpha1.ClusterOverride
+
+	for _, ov := range instance.Spec.Overrides {
+		if ov.ClusterSelector == nil || len(ov.ClusterOverrides) == 0 {
+			continue
+		}
+
+		selector, err := ConvertLabels(ov.ClusterSelector)
+		if err != nil {
+			log.Info("Invalid cluster selector in overrides", "error", err)
+			continue
+		}
+
+		if selector.Matches(clusterLabels) {
+			overrides = append(overrides, ov.ClusterOverrides...)
+		}
+	}
+
+	return overrides
+}
+
 // OverrideTemplate alter the given template with overrides
 func OverrideTemplate(template *unstructured.Unstructured, overrides []appv1alpha1.ClusterOverride) (*unstructured.Unstructured, error) {
-	if klog.V(QuiteLogLel) {
+	if log.V(QuiteLogLel).Enabled() {
 		fnName := GetFnName()
-		klog.Infof("Entering: %v()", fnName)
+		log.V(QuiteLogLel).Info("Entering", "function", fnName)
 
-		defer klog.Infof("Exiting: %v()", fnName)
+		defer log.V(QuiteLogLel).Info("Exiting", "function", fnName)
 	}
 
 	ovt := template.DeepCopy()
 
 	if template == nil || overrides == nil {
-		klog.V(10).Info("No Instance or no override for template")
+		log.V(10).Info("No instance or no override for template")
 		return ovt, nil
 	}
 
 	for _, override := range overrides {
 		tmpOverride := override
 		ovuobj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&tmpOverride)
-		klog.V(10).Info("From Instance Converter", ovuobj, "with err:", err, " path: ", ovuobj["path"], " value:", ovuobj["value"])
+		log.V(10).Info("Converted override", "path", ovuobj["path"], "value", ovuobj["value"], "error", err)
 
 		if err != nil {
 			return nil, errors.New("can not parse override")
@@ -176,12 +301,12 @@ func OverrideTemplate(template *unstructured.Unstructured, overrides []appv1alph
 			err = unstructured.SetNestedField(ovt.Object, ovuobj["value"], fields...)
 
 			if err != nil {
-				klog.V(5).Info("Error in setting nested field", err)
+				log.V(5).Info("Failed to set nested field", "error", err)
 			}
 		}
 	}
 
-	klog.V(10).Info("Finished overriding template:", ovt)
+	log.V(10).Info("Finished overriding template", "template", ovt)
 
 	return ovt, nil
 }
@@ -0,0 +1,114 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"k8s.io/apimachinery/pkg/labels"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+)
+
+// NextMaintenanceWindow checks the maintenance windows applying to a cluster with the given labels.
+// It returns a zero time if changes are allowed now, either because no window selects the cluster
+// or because one of its windows is open. Otherwise it returns when the next window opens.
+// A window that can not be parsed, and may apply to the cluster, returns an error so the changes wait until it is fixed.
+func NextMaintenanceWindow(windows []appv1alpha1.MaintenanceWindow, clusterLabels labels.Set, now time.Time) (time.Time, error) {
+	var next time.Time
+
+	open := false
+
+	for i, window := range windows {
+		selector, err := ConvertLabels(window.ClusterSelector)
+		if err != nil {
+			return time.Time{}, maintenanceWindowError(i, window, err)
+		}
+
+		if !selector.Matches(clusterLabels) {
+			continue
+		}
+
+		start, isOpen, err := getMaintenanceWindowStart(window, now)
+		if err != nil {
+			return time.Time{}, maintenanceWindowError(i, window, err)
+		}
+
+		if isOpen {
+			open = true
+		}
+
+		if next.IsZero() || start.Before(next) {
+			next = start
+		}
+	}
+
+	if open {
+		return time.Time{}, nil
+	}
+
+	return next, nil
+}
+
+// ValidateMaintenanceWindows returns the error of the first maintenance window that can not be parsed.
+func ValidateMaintenanceWindows(windows []appv1alpha1.MaintenanceWindow, now time.Time) error {
+	for i, window := range windows {
+		if _, err := ConvertLabels(window.ClusterSelector); err != nil {
+			return maintenanceWindowError(i, window, err)
+		}
+
+		if _, _, err := getMaintenanceWindowStart(window, now); err != nil {
+			return maintenanceWindowError(i, window, err)
+		}
+	}
+
+	return nil
+}
+
+func maintenanceWindowError(i int, window appv1alpha1.MaintenanceWindow, err error) error {
+	log.Info("Invalid maintenance window", "window", i, "schedule", window.Schedule, "timeZone", window.TimeZone, "error", err)
+
+	return fmt.Errorf("maintenance window %d with schedule %q in time zone %q is invalid: %v", i, window.Schedule, window.TimeZone, err)
+}
+
+// getMaintenanceWindowStart returns true if the window is open at now, or else when it opens next.
+func getMaintenanceWindowStart(window appv1alpha1.MaintenanceWindow, now time.Time) (time.Time, bool, error) {
+	loc := time.UTC
+
+	if window.TimeZone != "" {
+		var err error
+
+		loc, err = time.LoadLocation(window.TimeZone)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+	}
+
+	schedule, err := cron.ParseStandard(window.Schedule)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	now = now.In(loc)
+
+	// the window is open if it started within the last duration
+	if start := schedule.Next(now.Add(-window.Duration.Duration)); !start.After(now) {
+		return start, true, nil
+	}
+
+	return schedule.Next(now), false, nil
+}
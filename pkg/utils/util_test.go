@@ -16,17 +16,28 @@ package utils
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/onsi/gomega"
 	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
 
@@ -106,6 +117,42 @@ func TestGenerateOverrides(t *testing.T) {
 	}
 	o = GenerateOverrides(srcD, destD)
 	g.Expect(o).To(gomega.HaveLen(1))
+
+	// a template that can not be decoded generates no overrides
+	destD.Spec.Template = &runtime.RawExtension{Raw: []byte(`["not","an","object"]`)}
+
+	o = GenerateOverrides(srcD, destD)
+	g.Expect(o).To(gomega.BeEmpty())
+}
+
+func TestDiffOverrides(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	srcD := &appv1alpha1.Deployable{Spec: appv1alpha1.DeployableSpec{
+		Template: &runtime.RawExtension{Raw: []byte(`{"kind":"ConfigMap","data":{"a":"1"}}`)}}}
+	destD := &appv1alpha1.Deployable{Spec: appv1alpha1.DeployableSpec{
+		Template: &runtime.RawExtension{Raw: []byte(`{"kind":"ConfigMap","data":{"a":"2"}}`)}}}
+
+	o, fallback := DiffOverrides(srcD, destD)
+	g.Expect(fallback).NotTo(gomega.HaveOccurred())
+	g.Expect(o).To(gomega.HaveLen(1))
+	g.Expect(string(o[0].Raw)).To(gomega.Equal(`{"path":"data.a","value":"2"}`))
+
+	// a field changing type cannot be diffed, the whole template is replaced
+	destD.Spec.Template = &runtime.RawExtension{Raw: []byte(`{"kind":"ConfigMap","data":"a"}`)}
+
+	o, fallback = DiffOverrides(srcD, destD)
+	g.Expect(fallback).To(gomega.MatchError(gomega.ContainSubstring(`path data changes from map[string]interface {} to string with value a`)))
+	g.Expect(o).To(gomega.HaveLen(1))
+	g.Expect(string(o[0].Raw)).To(gomega.Equal(`{"path":".","value":{"kind":"ConfigMap","data":"a"}}`))
+
+	// a template that can not be decoded is reported
+	destD.Spec.Template = &runtime.RawExtension{Raw: []byte(`["not","an","object"]`)}
+
+	o, fallback = DiffOverrides(srcD, destD)
+	g.Expect(fallback).To(gomega.MatchError(gomega.ContainSubstring("failed to decode the target template")))
+	g.Expect(o).To(gomega.HaveLen(1))
+	g.Expect(string(o[0].Raw)).To(gomega.Equal(`{"path":".","value":["not","an","object"]}`))
 }
 
 func TestOverrideTemplate(t *testing.T) {
@@ -128,3 +175,351 @@ func TestOverrideTemplate(t *testing.T) {
 	g.Expect(tOut.Object).NotTo(gomega.BeNil())
 	g.Expect(tOut.Object["foo"]).To(gomega.Equal("bar"))
 }
+
+func TestNextMaintenanceWindow(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	windows := []appv1alpha1.MaintenanceWindow{
+		{
+			ClusterSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+			Schedule:        "0 22 * * *",
+			Duration:        metav1.Duration{Duration: 2 * time.Hour},
+		},
+	}
+
+	prod := labels.Set{"env": "prod"}
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// clusters not selected by any window are not restricted
+	next, err := NextMaintenanceWindow(windows, labels.Set{"env": "dev"}, now)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(next.IsZero()).To(gomega.BeTrue())
+
+	// closed window defers to the next start
+	next, err = NextMaintenanceWindow(windows, prod, now)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(next).To(gomega.BeTemporally("==", time.Date(2021, 6, 1, 22, 0, 0, 0, time.UTC)))
+
+	// open window allows changes
+	next, err = NextMaintenanceWindow(windows, prod, now.Add(11*time.Hour))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(next.IsZero()).To(gomega.BeTrue())
+
+	// time zone of the schedule
+	windows[0].TimeZone = "Europe/Paris"
+	next, err = NextMaintenanceWindow(windows, prod, now)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(next).To(gomega.BeTemporally("==", time.Date(2021, 6, 1, 20, 0, 0, 0, time.UTC)))
+
+	// an invalid window defers the clusters it may apply to, even with another window open
+	windows = append(windows, appv1alpha1.MaintenanceWindow{Schedule: "0 * * * *", Duration: metav1.Duration{Duration: time.Hour}})
+	next, err = NextMaintenanceWindow(windows, prod, now)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(next.IsZero()).To(gomega.BeTrue())
+	g.Expect(ValidateMaintenanceWindows(windows, now)).To(gomega.Succeed())
+
+	windows[0].Schedule = "not a schedule"
+	_, err = NextMaintenanceWindow(windows, prod, now)
+	g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(`maintenance window 0 with schedule "not a schedule"`)))
+	g.Expect(ValidateMaintenanceWindows(windows, now)).NotTo(gomega.Succeed())
+
+	_, err = NextMaintenanceWindow(windows, labels.Set{"env": "dev"}, now)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	windows[0].Schedule = "0 22 * * *"
+	windows[0].TimeZone = "Mars/Olympus"
+	_, err = NextMaintenanceWindow(windows, prod, now)
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	windows[0].TimeZone = ""
+	windows[0].ClusterSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"env": "not a/valid/label"}}
+	_, err = NextMaintenanceWindow(windows, labels.Set{"env": "dev"}, now)
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func writeTestChart(g *gomega.WithT, dir string) *chart.Chart {
+	ch := &chart.Chart{
+		Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "app", Version: "0.1.0"},
+		Values:   map[string]interface{}{"replicas": 1},
+		Templates: []*chart.File{
+			{Name: "templates/deployment.yaml", Data: []byte(
+				"apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: {{ .Release.Name }}\nspec:\n  replicas: {{ .Values.replicas }}\n")},
+			{Name: "templates/configmap.yaml", Data: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Release.Name }}\n")},
+			{Name: "templates/NOTES.txt", Data: []byte("installed {{ .Release.Name }}")},
+		},
+	}
+
+	g.Expect(chartutil.SaveDir(ch, dir)).To(gomega.Succeed())
+
+	return ch
+}
+
+func TestRenderHelmChart(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	dir := t.TempDir()
+	writeTestChart(g, dir)
+
+	SetHelmChartSources([]string{dir}, nil)
+	defer SetHelmChartSources(nil, nil)
+
+	tpl := &runtime.RawExtension{Raw: []byte(`{"apiVersion":"apps.open-cluster-management.io/v1","kind":"HelmChart",` +
+		`"metadata":{"name":"web","namespace":"default"},"spec":{"chart":{"path":"` + dir + `/app"},"values":{"replicas":3}}}`)}
+	g.Expect(IsHelmChartTemplate(tpl)).To(gomega.BeTrue())
+
+	rendered, metadata, err := RenderHelmChart(context.TODO(), tpl)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(metadata.Version).To(gomega.Equal("0.1.0"))
+	g.Expect(IsListTemplate(rendered)).To(gomega.BeTrue())
+
+	list := &unstructured.UnstructuredList{}
+	g.Expect(list.UnmarshalJSON(rendered.Raw)).To(gomega.Succeed())
+	g.Expect(list.Items).To(gomega.HaveLen(2))
+
+	// install order puts the ConfigMap first, the notes are not rendered
+	g.Expect(list.Items[0].GetKind()).To(gomega.Equal("ConfigMap"))
+	g.Expect(list.Items[1].GetName()).To(gomega.Equal("web"))
+	replicas, _, _ := unstructured.NestedInt64(list.Items[1].Object, "spec", "replicas")
+	g.Expect(replicas).To(gomega.BeEquivalentTo(3))
+
+	_, _, err = RenderHelmChart(context.TODO(), &runtime.RawExtension{Raw: []byte(`{"kind":"HelmChart","spec":{"chart":{}}}`)})
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	// a path out of the chart paths is not read
+	_, _, err = RenderHelmChart(context.TODO(), &runtime.RawExtension{Raw: []byte(`{"apiVersion":"apps.open-cluster-management.io/v1",` +
+		`"kind":"HelmChart","spec":{"chart":{"path":"` + dir + `/../../etc"}}}`)})
+	g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("is not in a directory of --helm-chart-paths")))
+
+	// a subchart disabled by the values of one render is still rendered by the next one from the cached chart
+	ch := &chart.Chart{
+		Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "parent", Version: "0.1.0",
+			Dependencies: []*chart.Dependency{{Name: "app", Version: "0.1.0", Condition: "app.enabled"}}},
+		Values: map[string]interface{}{"app": map[string]interface{}{"enabled": true}},
+	}
+	ch.AddDependency(writeTestChart(g, t.TempDir()))
+	g.Expect(chartutil.SaveDir(ch, dir)).To(gomega.Succeed())
+
+	withApp := func(enabled string) *runtime.RawExtension {
+		return &runtime.RawExtension{Raw: []byte(`{"apiVersion":"apps.open-cluster-management.io/v1","kind":"HelmChart",` +
+			`"metadata":{"name":"web","namespace":"default"},"spec":{"chart":{"path":"` + dir + `/parent"},"values":{"app":{"enabled":` + enabled + `}}}}`)}
+	}
+
+	for _, enabled := range []string{"false", "true"} {
+		rendered, _, err = RenderHelmChart(context.TODO(), withApp(enabled))
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+
+		list = &unstructured.UnstructuredList{}
+		g.Expect(list.UnmarshalJSON(rendered.Raw)).To(gomega.Succeed())
+
+		if enabled == "true" {
+			g.Expect(list.Items).To(gomega.HaveLen(2))
+		} else {
+			g.Expect(list.Items).To(gomega.BeEmpty())
+		}
+	}
+}
+
+func TestPullOCIChart(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	dir := t.TempDir()
+	archive, err := chartutil.Save(writeTestChart(g, dir), dir)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	data, err := os.ReadFile(archive)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	sum := sha256.Sum256(data)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.URL.Path == "/token":
+			fmt.Fprint(w, `{"token":"pull"}`)
+		case req.Header.Get("Authorization") != "Bearer pull":
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="registry",scope="repository:charts/app:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case req.URL.Path == "/v2/charts/app/manifests/0.1.0":
+			fmt.Fprintf(w, `{"layers":[{"mediaType":%q,"digest":%q}]}`, helmChartLayerMediaType, digest)
+		case req.URL.Path == "/v2/charts/app/blobs/"+digest:
+			_, _ = w.Write(data)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	defer func(client *http.Client) { ociHTTPClient = client }(ociHTTPClient)
+	ociHTTPClient = server.Client()
+
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	// a registry not allowed is not pulled from
+	_, err = PullOCIChart(context.TODO(), "oci://"+host+"/charts/app:0.1.0")
+	g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("is not in --helm-chart-registries")))
+
+	SetHelmChartSources(nil, []string{host})
+	defer SetHelmChartSources(nil, nil)
+
+	pulled, err := PullOCIChart(context.TODO(), "oci://"+host+"/charts/app:0.1.0")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(pulled).To(gomega.Equal(data))
+
+	_, err = PullOCIChart(context.TODO(), "oci://"+host+"/charts/app:0.2.0")
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	_, err = PullOCIChart(context.TODO(), "https://"+host+"/charts/app:0.1.0")
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	// a token endpoint that challenges again is not followed
+	requests := 0
+	loop := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		w.Header().Set("WWW-Authenticate", `Bearer realm="https://`+req.Host+`/token"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer loop.Close()
+
+	ociHTTPClient = loop.Client()
+	SetHelmChartSources(nil, []string{strings.TrimPrefix(loop.URL, "https://")})
+
+	_, err = PullOCIChart(context.TODO(), "oci://"+strings.TrimPrefix(loop.URL, "https://")+"/charts/app:0.1.0")
+	g.Expect(err).To(gomega.HaveOccurred())
+	g.Expect(requests).To(gomega.Equal(2))
+
+	// a response larger than the limit is rejected
+	large := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write(make([]byte, ociMaxManifestSize+1))
+	}))
+	defer large.Close()
+
+	ociHTTPClient = large.Client()
+	SetHelmChartSources(nil, []string{strings.TrimPrefix(large.URL, "https://")})
+
+	_, err = PullOCIChart(context.TODO(), "oci://"+strings.TrimPrefix(large.URL, "https://")+"/charts/app:0.1.0")
+	g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("larger than")))
+
+	// a token realm on another host is not requested
+	requests = 0
+	foreign := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		w.Header().Set("WWW-Authenticate", `Bearer realm="https://169.254.169.254/token"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer foreign.Close()
+
+	ociHTTPClient = foreign.Client()
+	SetHelmChartSources(nil, []string{strings.TrimPrefix(foreign.URL, "https://")})
+
+	_, err = PullOCIChart(context.TODO(), "oci://"+strings.TrimPrefix(foreign.URL, "https://")+"/charts/app:0.1.0")
+	g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("is not on the registry host")))
+	g.Expect(requests).To(gomega.Equal(1))
+}
+
+func TestKustomizeTemplate(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	prod := &appv1alpha1.KustomizeOverlay{
+		NamePrefix: "prod-",
+		Images:     []appv1alpha1.KustomizeImage{{Name: "nginx", NewTag: "1.21"}},
+	}
+	east := &appv1alpha1.KustomizeOverlay{
+		Patches: []appv1alpha1.KustomizePatch{{
+			Patch:  `[{"op":"replace","path":"/spec/replicas","value":3}]`,
+			Target: &appv1alpha1.KustomizePatchTarget{Kind: "Deployment"},
+		}},
+	}
+
+	instance := &appv1alpha1.Deployable{Spec: appv1alpha1.DeployableSpec{Overrides: []appv1alpha1.Overrides{
+		{ClusterSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}, Kustomize: prod},
+		{ClusterName: "east", Kustomize: east},
+		{ClusterName: "east", ClusterOverrides: []appv1alpha1.ClusterOverride{{RawExtension: runtime.RawExtension{Raw: []byte(`{"path":"data","value":{}}`)}}}},
+	}}}
+
+	eastKey := types.NamespacedName{Name: "east", Namespace: "east"}
+
+	// the group overlay comes first, like in the overrides
+	overlays := PrepareOverlays(eastKey, labels.Set{"env": "prod"}, instance)
+	g.Expect(overlays).To(gomega.Equal([]appv1alpha1.KustomizeOverlay{*prod, *east}))
+	g.Expect(PrepareOverlays(types.NamespacedName{Name: "west"}, labels.Set{"env": "dev"}, instance)).To(gomega.BeEmpty())
+
+	// the dot-path overrides are not hidden by the overlay of the same cluster
+	covs, err := PrepareOverrides(eastKey, instance)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(covs).To(gomega.HaveLen(1))
+
+	// the dot-path overrides of a cluster group apply to the clusters it selects
+	group := appv1alpha1.ClusterOverride{RawExtension: runtime.RawExtension{Raw: []byte(`{"path":"data.env","value":"prod"}`)}}
+	instance.Spec.Overrides = append(instance.Spec.Overrides, appv1alpha1.Overrides{
+		ClusterSelector:  &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+		ClusterOverrides: []appv1alpha1.ClusterOverride{group},
+	})
+
+	g.Expect(PrepareSelectorOverrides(labels.Set{"env": "prod"}, instance)).To(gomega.Equal([]appv1alpha1.ClusterOverride{group}))
+	g.Expect(PrepareSelectorOverrides(labels.Set{"env": "dev"}, instance)).To(gomega.BeEmpty())
+	g.Expect(PrepareOverrides(eastKey, instance)).To(gomega.HaveLen(1))
+
+	tpl := &runtime.RawExtension{Raw: []byte(`{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"web"},` +
+		`"spec":{"replicas":1,"template":{"spec":{"containers":[{"name":"web","image":"nginx:1.19"}]}}}}`)}
+
+	kustomized, err := KustomizeTemplate(tpl, overlays)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(kustomized.Raw).To(gomega.MatchJSON(`{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"prod-web"},` +
+		`"spec":{"replicas":3,"template":{"spec":{"containers":[{"name":"web","image":"nginx:1.21"}]}}}}`))
+
+	// the resources of a List are kustomized one by one
+	list := &runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"List","items":[` + string(tpl.Raw) +
+		`,{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"web"}}]}`)}
+
+	kustomized, err = KustomizeTemplate(list, overlays[:1])
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(IsListTemplate(kustomized)).To(gomega.BeTrue())
+	g.Expect(string(kustomized.Raw)).To(gomega.ContainSubstring(`"name":"prod-web"`))
+	g.Expect(string(kustomized.Raw)).NotTo(gomega.ContainSubstring(`"metadata":{"name":"web"}`))
+
+	// no overlay keeps the template
+	g.Expect(KustomizeTemplate(tpl, nil)).To(gomega.Equal(tpl))
+
+	_, err = KustomizeTemplate(tpl, []appv1alpha1.KustomizeOverlay{{Patches: []appv1alpha1.KustomizePatch{{Patch: "not a patch"}}}})
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func TestTemplateKindsPolicy(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	objs, err := TemplateObjects(&runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"List","items":[` +
+		`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cm"}},` +
+		`{"apiVersion":"rbac.authorization.k8s.io/v1","kind":"ClusterRoleBinding","metadata":{"name":"admin"}}]}`)})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(objs).To(gomega.HaveLen(2))
+
+	policy := &TemplateKindsPolicy{
+		TemplateKindRules: TemplateKindRules{Denied: []string{"*.rbac.authorization.k8s.io"}},
+		Namespaces:        map[string]TemplateKindRules{"admins": {}, "team": {Allowed: []string{"ConfigMap", "Deployment.apps"}}},
+	}
+	g.Expect(policy.Check("default", objs)).To(gomega.MatchError("kind ClusterRoleBinding.rbac.authorization.k8s.io is denied in namespace default"))
+	g.Expect(policy.Check("default", objs[:1])).To(gomega.Succeed())
+	g.Expect(policy.Check("admins", objs)).To(gomega.Succeed())
+	g.Expect(policy.Check("team", objs)).To(gomega.MatchError(gomega.ContainSubstring("is not allowed in namespace team")))
+
+	// the policy of the configmap replaces the policy of the flags
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "kinds", Namespace: "default"},
+		Data:       map[string]string{TemplateKindsPolicyKey: "allowed:\n- ConfigMap\n"},
+	}
+	reader := fake.NewClientBuilder().WithObjects(cm).Build()
+
+	SetTemplateKindsPolicy(*policy, &types.NamespacedName{Name: "kinds", Namespace: "default"})
+	defer SetTemplateKindsPolicy(TemplateKindsPolicy{}, nil)
+
+	loaded, err := GetTemplateKindsPolicy(context.TODO(), reader)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(loaded.Allowed).To(gomega.Equal([]string{"ConfigMap"}))
+	g.Expect(loaded.Check("admins", objs)).NotTo(gomega.Succeed())
+
+	SetTemplateKindsPolicy(*policy, &types.NamespacedName{Name: "none", Namespace: "default"})
+	loaded, err = GetTemplateKindsPolicy(context.TODO(), reader)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(loaded.Denied).To(gomega.Equal(policy.Denied))
+}
@@ -0,0 +1,253 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+	"github.com/stolostron/multicloud-operators-deployable/pkg/utils"
+)
+
+const (
+	// MutatePath is the path of the mutating webhook of the deployables.
+	MutatePath = "/mutate-apps-open-cluster-management-io-v1-deployable"
+	// ValidatePath is the path of the validating webhook of the deployables.
+	ValidatePath = "/validate-apps-open-cluster-management-io-v1-deployable"
+)
+
+// AddToManager registers the admission webhooks of the deployables with the webhook server of the manager.
+func AddToManager(mgr manager.Manager) error {
+	mgr.GetWebhookServer().Register(MutatePath, &admission.Webhook{Handler: &userRecorder{}})
+	mgr.GetWebhookServer().Register(ValidatePath, &admission.Webhook{Handler: &kindsValidator{client: mgr.GetClient(), apiReader: mgr.GetAPIReader()}})
+
+	return nil
+}
+
+// controllerUser is the user of the controller, never recorded and never checked by the webhooks.
+var controllerUser string
+
+// SetControllerUser sets the user of the controller, like system:serviceaccount:<namespace>:<name>, whose requests the
+// webhooks never record as the user of a deployable and do not check.
+func SetControllerUser(user string) {
+	controllerUser = user
+}
+
+// isControllerUser tells if the request comes from the controller.
+func isControllerUser(req admission.Request) bool {
+	return controllerUser != "" && req.UserInfo.Username == controllerUser
+}
+
+// userRecorder records the user that last changes what a deployable propagates, and the user that approves a rollout
+// stage, in its annotations. An update that leaves the spec and the annotations driving the propagation unchanged keeps
+// the recorded user, and the requests of the controller are never recorded.
+type userRecorder struct{}
+
+func (h *userRecorder) Handle(ctx context.Context, req admission.Request) admission.Response {
+	dpl := &unstructured.Unstructured{}
+	if err := dpl.UnmarshalJSON(req.Object.Raw); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	old := &unstructured.Unstructured{}
+
+	if req.Operation == admissionv1.Update {
+		if err := old.UnmarshalJSON(req.OldObject.Raw); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+
+	user, groups := req.UserInfo.Username, req.UserInfo.Groups
+
+	switch {
+	case isControllerUser(req) && req.Operation == admissionv1.Update:
+		user, groups = utils.GetRecordedUser(old)
+	case isControllerUser(req):
+		// the children the controller creates carry the user of their hub deployable
+		user, groups = utils.GetRecordedUser(dpl)
+	case req.Operation == admissionv1.Update && !changesPropagation(dpl, old):
+		if recorded, recordedGroups := utils.GetRecordedUser(old); recorded != "" {
+			user, groups = recorded, recordedGroups
+		}
+	}
+
+	if user != "" {
+		utils.SetRecordedUser(dpl, user, groups)
+	}
+
+	recordApprover(dpl, old, req.UserInfo.Username)
+
+	raw, err := dpl.MarshalJSON()
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	return admission.PatchResponseFromRaw(req.Object.Raw, raw)
+}
+
+// changesPropagation tells if the update changes what the controller propagates: the spec, or the annotations that
+// drive the rendering and the rolling update, all but those the webhook records.
+func changesPropagation(dpl, old *unstructured.Unstructured) bool {
+	if !equality.Semantic.DeepEqual(dpl.Object["spec"], old.Object["spec"]) {
+		return true
+	}
+
+	annotations, oldannotations := propagatedAnnotations(dpl), propagatedAnnotations(old)
+
+	return !equality.Semantic.DeepEqual(annotations, oldannotations)
+}
+
+// propagatedAnnotations returns the annotations of the deployable, without those the webhook records.
+func propagatedAnnotations(dpl *unstructured.Unstructured) map[string]string {
+	annotations := make(map[string]string)
+
+	for k, v := range dpl.GetAnnotations() {
+		switch k {
+		case appv1alpha1.AnnotationUserIdentity, appv1alpha1.AnnotationUserGroups, appv1alpha1.AnnotationRollingUpdateApprover,
+			appv1alpha1.AnnotationRollingUpdateApprovedBy, appv1alpha1.AnnotationRollingUpdateApprovedStage:
+			continue
+		}
+
+		annotations[k] = v
+	}
+
+	return annotations
+}
+
+// recordApprover records the user that sets or changes the approval of a rollout stage, whatever approver the request
+// gives, and keeps the recorded approver while the approval is unchanged.
+func recordApprover(dpl, old *unstructured.Unstructured, user string) {
+	annotations := dpl.GetAnnotations()
+	oldannotations := old.GetAnnotations()
+
+	approval := annotations[appv1alpha1.AnnotationRollingUpdateApprovedBy]
+
+	switch {
+	case approval == "":
+		delete(annotations, appv1alpha1.AnnotationRollingUpdateApprover)
+	case approval == oldannotations[appv1alpha1.AnnotationRollingUpdateApprovedBy] &&
+		annotations[appv1alpha1.AnnotationRollingUpdateApprovedStage] == oldannotations[appv1alpha1.AnnotationRollingUpdateApprovedStage] &&
+		oldannotations[appv1alpha1.AnnotationRollingUpdateApprover] != "":
+		annotations[appv1alpha1.AnnotationRollingUpdateApprover] = oldannotations[appv1alpha1.AnnotationRollingUpdateApprover]
+	default:
+		annotations[appv1alpha1.AnnotationRollingUpdateApprover] = user
+	}
+
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+
+	dpl.SetAnnotations(annotations)
+}
+
+// kindsValidator rejects the deployables whose template, or the template of a dependency, has a kind the template kinds
+// policy does not allow in the namespace of the deployable. The chart of a HelmChart template is only checked once
+// rendered, by the controller, and the requests of the controller are not checked.
+type kindsValidator struct {
+	client    client.Reader
+	apiReader client.Reader
+}
+
+func (v *kindsValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	// the children the controller creates are checked with their hub deployable, whatever annotations a user sets
+	if isControllerUser(req) {
+		return admission.Allowed("")
+	}
+
+	dpl := &appv1alpha1.Deployable{}
+	if err := json.Unmarshal(req.Object.Raw, dpl); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	policy, err := utils.GetTemplateKindsPolicy(ctx, v.apiReader)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	if policy.IsEmpty() {
+		return admission.Allowed("")
+	}
+
+	if dpl.GetNamespace() == "" {
+		dpl.SetNamespace(req.Namespace)
+	}
+
+	objs, err := v.templateObjects(ctx, dpl, sets.NewString())
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	if err := policy.Check(dpl.GetNamespace(), objs); err != nil {
+		return admission.Denied("the template has a " + err.Error())
+	}
+
+	return admission.Allowed("")
+}
+
+// templateObjects returns the resources of the template of the deployable and of its dependencies. The dependencies
+// not created yet are checked when they are propagated.
+func (v *kindsValidator) templateObjects(ctx context.Context, dpl *appv1alpha1.Deployable, seen sets.String) ([]*unstructured.Unstructured, error) {
+	objs, err := utils.TemplateObjects(dpl.Spec.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dependency := range dpl.Spec.Dependencies {
+		if dependency.Kind != "" && dependency.Kind != dpl.Kind {
+			continue
+		}
+
+		key := types.NamespacedName{Name: dependency.Name, Namespace: dependency.Namespace}
+		if key.Namespace == "" {
+			key.Namespace = dpl.GetNamespace()
+		}
+
+		if seen.Has(key.String()) {
+			continue
+		}
+
+		seen.Insert(key.String())
+
+		depobj := &appv1alpha1.Deployable{}
+		if err := v.client.Get(ctx, key, depobj); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		depobjs, err := v.templateObjects(ctx, depobj, seen)
+		if err != nil {
+			return nil, err
+		}
+
+		objs = append(objs, depobjs...)
+	}
+
+	return objs, nil
+}
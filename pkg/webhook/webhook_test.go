@@ -0,0 +1,221 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"testing"
+
+	"github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/stolostron/multicloud-operators-deployable/pkg/apis"
+	appv1alpha1 "github.com/stolostron/multicloud-operators-deployable/pkg/apis/apps/v1"
+	"github.com/stolostron/multicloud-operators-deployable/pkg/utils"
+)
+
+func TestUserRecorder(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	h := &userRecorder{}
+	dpl := []byte(`{"apiVersion":"apps.open-cluster-management.io/v1","kind":"Deployable","metadata":{"name":"dpl","namespace":"default"}}`)
+	recorded := []byte(`{"apiVersion":"apps.open-cluster-management.io/v1","kind":"Deployable","metadata":{"name":"dpl","namespace":"default",` +
+		`"annotations":{"apps.open-cluster-management.io/user-identity":"alice","apps.open-cluster-management.io/user-groups":"dev"}}}`)
+
+	// the user is recorded at creation, whatever the request says
+	resp := h.Handle(context.TODO(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		UserInfo:  authenticationv1.UserInfo{Username: "bob", Groups: []string{"ops", "system:authenticated"}},
+		Object:    runtime.RawExtension{Raw: recorded},
+	}})
+	g.Expect(resp.Allowed).To(gomega.BeTrue())
+	g.Expect(resp.Patches).To(gomega.HaveLen(2))
+	g.Expect(resp.Patches[0].Value).To(gomega.BeElementOf("bob", "ops,system:authenticated"))
+
+	// an update leaving the spec unchanged keeps the recorded user
+	resp = h.Handle(context.TODO(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Update,
+		UserInfo:  authenticationv1.UserInfo{Username: "bob"},
+		Object:    runtime.RawExtension{Raw: dpl},
+		OldObject: runtime.RawExtension{Raw: recorded},
+	}})
+	g.Expect(resp.Allowed).To(gomega.BeTrue())
+	g.Expect(resp.Patches).To(gomega.HaveLen(1))
+	g.Expect(resp.Patches[0].Value).To(gomega.HaveKeyWithValue("apps.open-cluster-management.io/user-identity", "alice"))
+
+	// a user changing the spec of the deployable of another user is recorded in place of the other user
+	changed := []byte(`{"apiVersion":"apps.open-cluster-management.io/v1","kind":"Deployable","metadata":{"name":"dpl","namespace":"default",` +
+		`"annotations":{"apps.open-cluster-management.io/user-identity":"alice","apps.open-cluster-management.io/user-groups":"dev"}},` +
+		`"spec":{"template":{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cm"}}}}`)
+	resp = h.Handle(context.TODO(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Update,
+		UserInfo:  authenticationv1.UserInfo{Username: "bob", Groups: []string{"ops"}},
+		Object:    runtime.RawExtension{Raw: changed},
+		OldObject: runtime.RawExtension{Raw: recorded},
+	}})
+	g.Expect(resp.Allowed).To(gomega.BeTrue())
+	g.Expect(resp.Patches).To(gomega.HaveLen(2))
+	g.Expect(resp.Patches[0].Value).To(gomega.BeElementOf("bob", "ops"))
+	g.Expect(resp.Patches[1].Value).To(gomega.BeElementOf("bob", "ops"))
+
+	// so is a user changing only the annotations driving the propagation, like the rolling update target
+	target := []byte(`{"apiVersion":"apps.open-cluster-management.io/v1","kind":"Deployable","metadata":{"name":"dpl","namespace":"default",` +
+		`"annotations":{"apps.open-cluster-management.io/user-identity":"alice","apps.open-cluster-management.io/user-groups":"dev",` +
+		`"apps.open-cluster-management.io/rollingupdate-target":"admin-dpl"}}}`)
+	resp = h.Handle(context.TODO(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Update,
+		UserInfo:  authenticationv1.UserInfo{Username: "bob", Groups: []string{"ops"}},
+		Object:    runtime.RawExtension{Raw: target},
+		OldObject: runtime.RawExtension{Raw: recorded},
+	}})
+	g.Expect(resp.Allowed).To(gomega.BeTrue())
+	g.Expect(resp.Patches).To(gomega.HaveLen(2))
+	g.Expect(resp.Patches[0].Value).To(gomega.BeElementOf("bob", "ops"))
+
+	// the controller is never recorded, changing the spec or creating a child
+	SetControllerUser("system:serviceaccount:ocm:multicluster-operators")
+	defer SetControllerUser("")
+
+	controller := authenticationv1.UserInfo{Username: "system:serviceaccount:ocm:multicluster-operators"}
+	resp = h.Handle(context.TODO(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Update,
+		UserInfo:  controller,
+		Object:    runtime.RawExtension{Raw: changed},
+		OldObject: runtime.RawExtension{Raw: recorded},
+	}})
+	g.Expect(resp.Allowed).To(gomega.BeTrue())
+	g.Expect(resp.Patches).To(gomega.BeEmpty())
+
+	resp = h.Handle(context.TODO(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		UserInfo:  controller,
+		Object:    runtime.RawExtension{Raw: recorded},
+	}})
+	g.Expect(resp.Allowed).To(gomega.BeTrue())
+	g.Expect(resp.Patches).To(gomega.BeEmpty())
+}
+
+func TestApproverRecorder(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	h := &userRecorder{}
+	recorded := `"apps.open-cluster-management.io/user-identity":"alice","apps.open-cluster-management.io/user-groups":"dev"`
+	dpl := func(annotations string) []byte {
+		return []byte(`{"apiVersion":"apps.open-cluster-management.io/v1","kind":"Deployable","metadata":{"name":"dpl","namespace":"default",` +
+			`"annotations":{` + recorded + annotations + `}}}`)
+	}
+
+	approverOf := func(resp admission.Response) interface{} {
+		for _, patch := range resp.Patches {
+			if patch.Path == "/metadata/annotations/apps.open-cluster-management.io~1rollingupdate-approver" {
+				return patch.Value
+			}
+		}
+
+		return nil
+	}
+
+	// the approver is the user that approves, not the one the approval gives
+	approval := `,"apps.open-cluster-management.io/rollingupdate-approved-by":"carol"`
+	resp := h.Handle(context.TODO(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Update,
+		UserInfo:  authenticationv1.UserInfo{Username: "bob"},
+		Object:    runtime.RawExtension{Raw: dpl(approval + `,"apps.open-cluster-management.io/rollingupdate-approver":"carol"`)},
+		OldObject: runtime.RawExtension{Raw: dpl("")},
+	}})
+	g.Expect(resp.Allowed).To(gomega.BeTrue())
+	g.Expect(approverOf(resp)).To(gomega.Equal("bob"))
+
+	// the recorded approver is kept while the approval is unchanged
+	resp = h.Handle(context.TODO(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Update,
+		UserInfo:  authenticationv1.UserInfo{Username: "dave"},
+		Object:    runtime.RawExtension{Raw: dpl(approval + `,"apps.open-cluster-management.io/rollingupdate-approver":"carol"`)},
+		OldObject: runtime.RawExtension{Raw: dpl(approval + `,"apps.open-cluster-management.io/rollingupdate-approver":"bob"`)},
+	}})
+	g.Expect(resp.Allowed).To(gomega.BeTrue())
+	g.Expect(approverOf(resp)).To(gomega.Equal("bob"))
+
+	// an approver without approval is removed
+	resp = h.Handle(context.TODO(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		UserInfo:  authenticationv1.UserInfo{Username: "alice", Groups: []string{"dev"}},
+		Object:    runtime.RawExtension{Raw: dpl(`,"apps.open-cluster-management.io/rollingupdate-approver":"carol"`)},
+	}})
+	g.Expect(resp.Allowed).To(gomega.BeTrue())
+	g.Expect(resp.Patches).To(gomega.HaveLen(1))
+	g.Expect(resp.Patches[0].Operation).To(gomega.Equal("remove"))
+}
+
+func TestKindsValidator(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(apis.AddToScheme(scheme)).To(gomega.Succeed())
+
+	dependency := &appv1alpha1.Deployable{
+		ObjectMeta: metav1.ObjectMeta{Name: "binding-dpl", Namespace: "default"},
+		Spec: appv1alpha1.DeployableSpec{
+			Template: &runtime.RawExtension{Raw: []byte(`{"apiVersion":"rbac.authorization.k8s.io/v1","kind":"ClusterRoleBinding","metadata":{"name":"admin"}}`)},
+		},
+	}
+	reader := fake.NewClientBuilder().WithScheme(scheme).WithObjects(dependency).Build()
+	v := &kindsValidator{client: reader, apiReader: reader}
+
+	utils.SetTemplateKindsPolicy(utils.TemplateKindsPolicy{
+		Namespaces: map[string]utils.TemplateKindRules{"default": {Allowed: []string{"ConfigMap"}}},
+	}, nil)
+	defer utils.SetTemplateKindsPolicy(utils.TemplateKindsPolicy{}, nil)
+
+	request := func(dpl string) admission.Request {
+		return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Namespace: "default",
+			Object:    runtime.RawExtension{Raw: []byte(dpl)},
+		}}
+	}
+
+	resp := v.Handle(context.TODO(), request(`{"apiVersion":"apps.open-cluster-management.io/v1","kind":"Deployable","metadata":{"name":"dpl"},`+
+		`"spec":{"template":{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cm"}}}}`))
+	g.Expect(resp.Allowed).To(gomega.BeTrue())
+
+	// the kinds of the dependencies are not allowed either
+	resp = v.Handle(context.TODO(), request(`{"apiVersion":"apps.open-cluster-management.io/v1","kind":"Deployable","metadata":{"name":"dpl"},`+
+		`"spec":{"template":{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cm"}},"dependencies":[{"name":"binding-dpl"}]}}`))
+	g.Expect(resp.Allowed).To(gomega.BeFalse())
+	g.Expect(resp.Result.Reason).To(gomega.BeEquivalentTo(
+		"the template has a kind ClusterRoleBinding.rbac.authorization.k8s.io is not allowed in namespace default"))
+
+	// a user can not pass a deployable as generated by the controller
+	generated := `{"apiVersion":"apps.open-cluster-management.io/v1","kind":"Deployable","metadata":{"name":"dpl",` +
+		`"annotations":{"apps.open-cluster-management.io/is-generated":"true"}},` +
+		`"spec":{"template":{"apiVersion":"rbac.authorization.k8s.io/v1","kind":"ClusterRoleBinding","metadata":{"name":"admin"}}}}`
+	resp = v.Handle(context.TODO(), request(generated))
+	g.Expect(resp.Allowed).To(gomega.BeFalse())
+
+	// the controller is not checked
+	SetControllerUser("system:serviceaccount:ocm:multicluster-operators")
+	defer SetControllerUser("")
+
+	req := request(generated)
+	req.UserInfo = authenticationv1.UserInfo{Username: "system:serviceaccount:ocm:multicluster-operators"}
+	resp = v.Handle(context.TODO(), req)
+	g.Expect(resp.Allowed).To(gomega.BeTrue())
+}